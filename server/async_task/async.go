@@ -1,7 +1,11 @@
 package async_task
 
 import (
+	"errors"
+	"fmt"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/zerozwt/octant/server/db"
@@ -11,6 +15,11 @@ import (
 
 type Scheduler interface {
 	AddTask(ctx *swe.Context, name, param string, ts int64, cb func(id int64)) error
+	// Cancel stops a task waiting to run, running tasks can not be canceled
+	Cancel(ctx *swe.Context, id int64) error
+	// Requeue runs a dead or canceled task again from its first attempt
+	Requeue(ctx *swe.Context, id int64) error
+	Stats() Stats
 }
 
 type TaskContext interface {
@@ -18,28 +27,140 @@ type TaskContext interface {
 	Name() string
 	Param() string
 	ChangeSchedule(ts int64)
+	// LastAttempt tells if the task goes dead instead of being retried should this run fail
+	LastAttempt() bool
+	// LeaseLost tells if the task may have been taken over by another instance, long handlers should
+	// check it between steps and return ErrLeaseLost, as the result of this run is dropped anyway
+	LeaseLost() bool
 }
 
 type Handler func(ctx *swe.Context, taskCtx TaskContext) error
 
+// RetryPolicy decides how a failed task is retried, the n-th retry happens
+// Backoff * 2^(n-1) seconds after the failure, but no later than MaxBackoff seconds
+type RetryPolicy struct {
+	MaxAttempts int // including the first run, tasks are never retried if less than 2
+	Backoff     int64
+	MaxBackoff  int64
+}
+
+func (p RetryPolicy) delay(attempts int) int64 {
+	ret := p.Backoff
+	for i := 1; i < attempts && (p.MaxBackoff <= 0 || ret < p.MaxBackoff); i++ {
+		ret *= 2
+	}
+	if p.MaxBackoff > 0 && ret > p.MaxBackoff {
+		ret = p.MaxBackoff
+	}
+	return ret
+}
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error that retrying would not help, the task goes dead at once
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// IsPermanent tells if err is marked by Permanent
+func IsPermanent(err error) bool {
+	return errors.As(err, &permanentError{})
+}
+
+type handlerEntry struct {
+	handler  Handler
+	policy   RetryPolicy
+	limit    int // max tasks running at once on this instance, 0 for no limit
+	priority int
+}
+
 var hMapLock sync.RWMutex
-var hMap map[string]Handler = map[string]Handler{}
+var hMap map[string]*handlerEntry = map[string]*handlerEntry{}
 
 func RegisterHandler(name string, handler Handler) {
 	hMapLock.Lock()
 	defer hMapLock.Unlock()
-	hMap[name] = handler
+	entryOf(name).handler = handler
+}
+
+// SetRetryPolicy sets retry policy of a handler, tasks are not retried by default
+func SetRetryPolicy(name string, policy RetryPolicy) {
+	hMapLock.Lock()
+	defer hMapLock.Unlock()
+	entryOf(name).policy = policy
+}
+
+// SetConcurrency limits tasks of a handler running at once on each instance, 0 for no limit other than workers
+func SetConcurrency(name string, limit int) {
+	hMapLock.Lock()
+	defer hMapLock.Unlock()
+	entryOf(name).limit = limit
+}
+
+// SetPriority sets priority of tasks created for a handler from now on,
+// due tasks with higher priority are run first when workers are busy
+func SetPriority(name string, priority int) {
+	hMapLock.Lock()
+	defer hMapLock.Unlock()
+	entryOf(name).priority = priority
+}
+
+// SetWorkers sets the number of tasks running at once on this instance
+func SetWorkers(workers int) {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+	if workers > 0 {
+		gs.workers = workers
+	}
+}
+
+func entryOf(name string) *handlerEntry {
+	entry, ok := hMap[name]
+	if !ok {
+		entry = &handlerEntry{policy: RetryPolicy{MaxAttempts: 1}}
+		hMap[name] = entry
+	}
+	return entry
 }
 
 //---------------------------------------------------------------------------------
 
+const (
+	// running tasks hold a lease, renewed while the handler runs, so that another
+	// core instance can take over a task whose owner died
+	TASK_LEASE_SECONDS = 60
+	// tasks created by other instances or left by dead ones are picked up from db this often
+	TASK_SYNC_SECONDS = 5
+	TASK_SYNC_BATCH   = 100
+
+	DEFAULT_TASK_WORKERS = 4
+)
+
+var errTaskNotFound = errors.New("task not found")
+
+// ErrLeaseLost is returned by handlers giving up a task whose lease is lost
+var ErrLeaseLost = errors.New("lease of task lost")
+
 type scheduler struct {
 	lock sync.Mutex
 
-	queue *utils.PriorQueue[db.AsyncTask]
+	owner string
+	queue *utils.PriorQueue[db.AsyncTask] // by schedule
+	ready *utils.PriorQueue[db.AsyncTask] // due tasks waiting for workers, by priority
+	tasks map[int64]*db.AsyncTask         // tasks waiting in queue or running on this instance
+
+	workers int
+	running int
+	busy    map[string]int // running tasks by handler
 }
 
-var gs *scheduler = &scheduler{}
+var gs *scheduler = &scheduler{workers: DEFAULT_TASK_WORKERS, busy: map[string]int{}}
 
 func GetScheduler() Scheduler { return gs }
 
@@ -48,20 +169,35 @@ func Init() error { return gs.init() }
 type context struct {
 	task  *db.AsyncTask
 	sched int64
+	last  bool
+	lost  atomic.Bool
 }
 
 func (ctx *context) ID() int64               { return ctx.task.ID }
 func (ctx *context) Name() string            { return ctx.task.Handler }
 func (ctx *context) Param() string           { return ctx.task.Param }
 func (ctx *context) ChangeSchedule(ts int64) { ctx.sched = ts }
+func (ctx *context) LastAttempt() bool       { return ctx.last }
+func (ctx *context) LeaseLost() bool         { return ctx.lost.Load() }
 
 func (s *scheduler) AddTask(ctx *swe.Context, name, param string, ts int64, cb func(id int64)) error {
+	hMapLock.RLock()
+	priority := 0
+	if entry, ok := hMap[name]; ok {
+		priority = entry.priority
+	}
+	hMapLock.RUnlock()
+
+	now := time.Now().Unix()
 	task := &db.AsyncTask{
-		ID:       utils.GenerateID(),
-		Handler:  name,
-		Param:    param,
-		Status:   db.ASYNC_TASK_IDLE,
-		Schedule: ts,
+		ID:         utils.GenerateID(),
+		Handler:    name,
+		Param:      param,
+		Status:     db.ASYNC_TASK_IDLE,
+		Schedule:   ts,
+		Priority:   priority,
+		CreateTime: now,
+		UpdateTime: now,
 	}
 
 	if err := db.GetAsyncTaskDAL().Put(ctx, task); err != nil {
@@ -72,14 +208,79 @@ func (s *scheduler) AddTask(ctx *swe.Context, name, param string, ts int64, cb f
 		cb(task.ID)
 	}
 
+	// instances not running the scheduler, e.g. collectors, leave tasks to core instances
 	s.lock.Lock()
-	s.queue.Put(task)
+	if s.queue != nil {
+		s.enqueue(task)
+	}
 	s.lock.Unlock()
 
 	return nil
 }
 
+func (s *scheduler) Cancel(ctx *swe.Context, id int64) error {
+	ok, err := db.GetAsyncTaskDAL().Cancel(ctx, id, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return s.stateError(ctx, id)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if task, ok := s.tasks[id]; ok && task.Status != db.ASYNC_TASK_RUNNING {
+		s.queue.Remove(task)
+		s.ready.Remove(task)
+		delete(s.tasks, id)
+	}
+	return nil
+}
+
+func (s *scheduler) Requeue(ctx *swe.Context, id int64) error {
+	ok, err := db.GetAsyncTaskDAL().Requeue(ctx, id, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return s.stateError(ctx, id)
+	}
+
+	task, err := db.GetAsyncTaskDAL().Get(ctx, id)
+	if err != nil || task == nil {
+		// picked up by sync later
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.tasks[id]; !ok {
+		s.enqueue(task)
+	}
+	return nil
+}
+
+// stateError explains why a task can not be touched
+func (s *scheduler) stateError(ctx *swe.Context, id int64) error {
+	task, err := db.GetAsyncTaskDAL().Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if task == nil {
+		return errTaskNotFound
+	}
+	return fmt.Errorf("task %d with status %d can not be changed", id, task.Status)
+}
+
+func (s *scheduler) enqueue(task *db.AsyncTask) {
+	s.tasks[task.ID] = task
+	s.queue.Put(task)
+}
+
 func (s *scheduler) init() error {
+	hostname, _ := os.Hostname()
+	s.owner = fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), utils.GenerateID())
+
 	// load all tasks from db
 	tasks, err := db.GetAsyncTaskDAL().All(nil)
 	if err != nil {
@@ -88,9 +289,18 @@ func (s *scheduler) init() error {
 
 	cmp := func(a, b *db.AsyncTask) bool { return a.Schedule < b.Schedule }
 	s.queue = utils.PriorityQueue(cmp)
+	s.ready = utils.PriorityQueue(func(a, b *db.AsyncTask) bool {
+		return a.Priority > b.Priority || (a.Priority == b.Priority && a.Schedule < b.Schedule)
+	})
+	s.tasks = make(map[int64]*db.AsyncTask, len(tasks))
 
+	// tasks left running by a dead instance are taken over after their leases expire
 	for _, item := range tasks {
-		s.queue.Put(item)
+		s.enqueue(item)
+	}
+
+	if err := s.initCron(); err != nil {
+		return err
 	}
 
 	// start tick thread
@@ -100,12 +310,39 @@ func (s *scheduler) init() error {
 }
 
 func (s *scheduler) tick() {
-	for {
+	for count := 0; ; count++ {
 		<-time.After(time.Second)
+		if count%TASK_SYNC_SECONDS == 0 {
+			s.sync()
+		}
 		s.run()
 	}
 }
 
+// sync fires due cron jobs, and queues due tasks in db not known to this instance
+func (s *scheduler) sync() {
+	ctx := &swe.Context{}
+	swe.AssignLogID(ctx)
+	logger := swe.CtxLogger(ctx)
+	logger.SetRenderer(utils.LogRenderer())
+
+	s.fireCron(ctx)
+
+	tasks, err := db.GetAsyncTaskDAL().Due(ctx, time.Now().Unix(), TASK_SYNC_BATCH)
+	if err != nil {
+		logger.Error("load due async tasks failed: %v", err)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, item := range tasks {
+		if _, ok := s.tasks[item.ID]; !ok {
+			s.enqueue(item)
+		}
+	}
+}
+
 func (s *scheduler) run() {
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -117,7 +354,25 @@ func (s *scheduler) run() {
 	for {
 		task := s.queue.Head()
 		if task == nil || task.Schedule > now {
-			return
+			break
+		}
+		s.ready.Put(s.queue.Pop())
+	}
+
+	// tasks of handlers at their limits wait for the next round
+	skipped := []*db.AsyncTask{}
+	for s.running < s.workers {
+		task := s.ready.Pop()
+		if task == nil {
+			break
+		}
+		entry := hMap[task.Handler]
+		if entry == nil {
+			entry = &handlerEntry{}
+		}
+		if entry.limit > 0 && s.busy[task.Handler] >= entry.limit {
+			skipped = append(skipped, task)
+			continue
 		}
 
 		ctx := &swe.Context{}
@@ -125,45 +380,174 @@ func (s *scheduler) run() {
 		logger := swe.CtxLogger(ctx)
 		logger.SetRenderer(utils.LogRenderer())
 
-		task = s.queue.Pop()
-		logger.Info("start async task %d handler: %s", task.ID, task.Handler)
+		task.Status = db.ASYNC_TASK_RUNNING
+		s.running++
+		s.busy[task.Handler]++
 
-		taskCtx := context{task: task}
+		go s.runTask(*entry, ctx, &context{task: task})
+	}
+	for _, task := range skipped {
+		s.ready.Put(task)
+	}
+}
+
+// Stats is a snapshot of tasks on this instance
+type Stats struct {
+	Workers  int
+	Running  int
+	Ready    int // due but waiting for workers
+	Waiting  int // not due yet
+	Handlers map[string]*HandlerStats
+}
+
+type HandlerStats struct {
+	Limit   int
+	Running int
+	Ready   int
+	Waiting int
+}
+
+func (s *scheduler) Stats() Stats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	hMapLock.RLock()
+	defer hMapLock.RUnlock()
 
-		handler, ok := hMap[task.Handler]
+	now := time.Now().Unix()
+	ret := Stats{Workers: s.workers, Running: s.running, Handlers: map[string]*HandlerStats{}}
+	for name, entry := range hMap {
+		ret.Handlers[name] = &HandlerStats{Limit: entry.limit}
+	}
+	for _, task := range s.tasks {
+		item, ok := ret.Handlers[task.Handler]
 		if !ok {
-			logger.Error("run async task %d failed: handler not found for %s", task.ID, task.Handler)
-			continue
+			item = &HandlerStats{}
+			ret.Handlers[task.Handler] = item
+		}
+		switch {
+		case task.Status == db.ASYNC_TASK_RUNNING:
+			item.Running++
+		case task.Schedule <= now:
+			item.Ready++
+			ret.Ready++
+		default:
+			item.Waiting++
+			ret.Waiting++
 		}
+	}
+	return ret
+}
 
-		go s.runTask(handler, ctx, taskCtx)
+// done gives back the worker of a task
+func (s *scheduler) done(handler string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.running--
+	s.busy[handler]--
+}
+
+// drop forgets a task no longer run by this instance
+func (s *scheduler) drop(task *db.AsyncTask) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.tasks[task.ID] == task {
+		delete(s.tasks, task.ID)
+	}
+}
+
+// renew keeps the lease of a running task until done is closed, or until the lease is lost
+func (s *scheduler) renew(ctx *swe.Context, taskCtx *context, done chan struct{}) {
+	id := taskCtx.task.ID
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(TASK_LEASE_SECONDS * time.Second / 3):
+		}
+		ok, err := db.GetAsyncTaskDAL().Renew(ctx, id, s.owner, time.Now().Unix()+TASK_LEASE_SECONDS)
+		if err != nil {
+			swe.CtxLogger(ctx).Error("renew lease of async task %d failed: %v", id, err)
+		} else if !ok {
+			swe.CtxLogger(ctx).Error("lease of async task %d lost", id)
+			taskCtx.lost.Store(true)
+			return
+		}
 	}
 }
 
-func (s *scheduler) runTask(handler Handler, ctx *swe.Context, taskCtx context) {
-	err := handler(ctx, &taskCtx)
+func (s *scheduler) runTask(entry handlerEntry, ctx *swe.Context, taskCtx *context) {
+	defer s.done(taskCtx.task.Handler)
 	logger := swe.CtxLogger(ctx)
+	dal := db.GetAsyncTaskDAL()
+
+	// claim the task first, it may have been run or changed by another instance
+	now := time.Now().Unix()
+	ok, err := dal.Claim(ctx, taskCtx.task.ID, s.owner, now, now+TASK_LEASE_SECONDS)
+	if err != nil || !ok {
+		if err != nil {
+			logger.Error("claim async task %d failed: %v", taskCtx.task.ID, err)
+		}
+		s.drop(taskCtx.task)
+		return
+	}
+	fresh, err := dal.Get(ctx, taskCtx.task.ID)
+	if err != nil || fresh == nil {
+		logger.Error("reload async task %d failed: %v", taskCtx.task.ID, err)
+		s.drop(taskCtx.task)
+		return
+	}
+	task := *fresh
+	taskCtx.last = task.Attempts >= entry.policy.MaxAttempts
+	logger.Info("start async task %d handler: %s attempt: %d", task.ID, task.Handler, task.Attempts)
+
+	done := make(chan struct{})
+	go s.renew(ctx, taskCtx, done)
 
+	if entry.handler == nil {
+		err = Permanent(fmt.Errorf("handler not found for %s", task.Handler))
+	} else {
+		err = entry.handler(ctx, taskCtx)
+	}
+	close(done)
+
+	requeue := true
+	task.UpdateTime = time.Now().Unix()
 	if taskCtx.sched > 0 {
-		logger.Info("async task %d not done, scheduled at %d", taskCtx.task.ID, taskCtx.sched)
-		taskCtx.task.Schedule = taskCtx.sched
-		taskCtx.task.Status = db.ASYNC_TASK_IDLE
+		logger.Info("async task %d not done, scheduled at %d", task.ID, taskCtx.sched)
+		task.Schedule = taskCtx.sched
+		task.Status = db.ASYNC_TASK_IDLE
+		task.Attempts = 0
 	} else if err != nil {
-		logger.Error("async task %d failed: %v", taskCtx.task.ID, err)
-		taskCtx.task.Status = db.ASYNC_TASK_FAILED
+		task.LastError = err.Error()
+		if !IsPermanent(err) && task.Attempts < entry.policy.MaxAttempts {
+			task.Schedule = task.UpdateTime + entry.policy.delay(task.Attempts)
+			task.Status = db.ASYNC_TASK_FAILED
+			logger.Error("async task %d failed: %v, retry at %d", task.ID, err, task.Schedule)
+		} else {
+			task.Status = db.ASYNC_TASK_DEAD
+			requeue = false
+			logger.Error("async task %d failed after %d attempts: %v", task.ID, task.Attempts, err)
+		}
 	} else {
-		logger.Info("async task %d sucess", taskCtx.task.ID)
-		taskCtx.task.Status = db.ASYNC_TASK_DONE
+		logger.Info("async task %d sucess", task.ID)
+		task.Status = db.ASYNC_TASK_DONE
+		requeue = false
 	}
 
-	err = db.GetAsyncTaskDAL().Put(ctx, taskCtx.task)
-	if err != nil {
-		logger.Error("update async task %d to db failed: %v", taskCtx.task.ID, err)
+	ok, err = dal.Release(ctx, &task, s.owner)
+	if err != nil || !ok {
+		// result is dropped, whoever holds the lease now decides what happens to the task
+		logger.Error("release async task %d failed: %v, lease lost: %v", task.ID, err, !ok)
+		s.drop(taskCtx.task)
+		return
 	}
 
-	if taskCtx.sched > 0 {
-		s.lock.Lock()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	*taskCtx.task = task
+	if requeue {
 		s.queue.Put(taskCtx.task)
-		s.lock.Unlock()
+	} else {
+		delete(s.tasks, task.ID)
 	}
 }
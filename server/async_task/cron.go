@@ -0,0 +1,212 @@
+package async_task
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zerozwt/octant/server/db"
+	"github.com/zerozwt/octant/server/utils"
+	"github.com/zerozwt/swe"
+)
+
+// CronJob creates a task for Handler with Param each time Spec fires, see utils.CronSchedule.
+// Catchup and Overlap take db.CRON_CATCHUP_* and db.CRON_OVERLAP_*, both skip by default.
+type CronJob struct {
+	Name    string
+	Spec    string
+	Handler string
+	Param   string
+	Catchup int
+	Overlap int
+}
+
+const (
+	// a run started this late is considered missed and handled by the catchup policy
+	CRON_GRACE_SECONDS = 60
+	// runs missed more than this are dropped even if all of them should be caught up
+	CRON_MAX_CATCHUP = 100
+)
+
+type cronEntry struct {
+	job   CronJob
+	sched *utils.CronSchedule
+}
+
+var cronLock sync.RWMutex
+var cronJobs map[string]*cronEntry = map[string]*cronEntry{}
+
+// RegisterCronJob defines a recurring job, the spec is checked when the scheduler starts
+func RegisterCronJob(job CronJob) {
+	if job.Catchup == 0 {
+		job.Catchup = db.CRON_CATCHUP_SKIP
+	}
+	if job.Overlap == 0 {
+		job.Overlap = db.CRON_OVERLAP_SKIP
+	}
+	cronLock.Lock()
+	defer cronLock.Unlock()
+	cronJobs[job.Name] = &cronEntry{job: job}
+}
+
+// SetCronEnabled turns a job on or off, a job turned on again starts from its next run after now
+func SetCronEnabled(ctx *swe.Context, name string, enabled bool) error {
+	cronLock.RLock()
+	entry, ok := cronJobs[name]
+	cronLock.RUnlock()
+	if !ok || entry.sched == nil {
+		return fmt.Errorf("cron job %s not registered", name)
+	}
+
+	now := time.Now().Unix()
+	ok, err := db.GetCronJobDAL().SetEnabled(ctx, name, enabled, entry.sched.Next(now), now)
+	if err == nil && !ok {
+		err = fmt.Errorf("cron job %s not found", name)
+	}
+	return err
+}
+
+// initCron writes registered jobs to db, jobs with a new spec are rescheduled from now
+func (s *scheduler) initCron() error {
+	cronLock.Lock()
+	defer cronLock.Unlock()
+
+	dal := db.GetCronJobDAL()
+	now := time.Now().Unix()
+	for name, entry := range cronJobs {
+		sched, err := utils.ParseCron(entry.job.Spec)
+		if err != nil {
+			return fmt.Errorf("cron job %s: %v", name, err)
+		}
+		entry.sched = sched
+
+		old, err := dal.Get(nil, name)
+		if err != nil {
+			return err
+		}
+		nextRun := int64(0)
+		if old == nil || old.Spec != entry.job.Spec {
+			nextRun = sched.Next(now)
+		}
+
+		err = dal.Define(nil, &db.CronJob{
+			Name:       name,
+			Spec:       entry.job.Spec,
+			Handler:    entry.job.Handler,
+			Param:      entry.job.Param,
+			Catchup:    entry.job.Catchup,
+			Overlap:    entry.job.Overlap,
+			Enabled:    1,
+			UpdateTime: now,
+		}, nextRun)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fireCron creates tasks for due cron jobs
+func (s *scheduler) fireCron(ctx *swe.Context) {
+	logger := swe.CtxLogger(ctx)
+	dal := db.GetCronJobDAL()
+	now := time.Now().Unix()
+
+	jobs, err := dal.Due(ctx, now)
+	if err != nil {
+		logger.Error("load due cron jobs failed: %v", err)
+		return
+	}
+
+	cronLock.RLock()
+	defer cronLock.RUnlock()
+
+	for _, job := range jobs {
+		// jobs removed from code or registered by a newer version are left alone
+		entry, ok := cronJobs[job.Name]
+		if !ok || entry.sched == nil {
+			continue
+		}
+
+		runs, next := s.cronRuns(entry, job.NextRun, now)
+		runs = s.cronFilter(ctx, entry, &job, runs, now)
+
+		ok, err := dal.Advance(ctx, job.Name, job.NextRun, next, now)
+		if err != nil {
+			logger.Error("advance cron job %s failed: %v", job.Name, err)
+			continue
+		}
+		if !ok {
+			// fired by another instance
+			continue
+		}
+
+		for _, ts := range runs {
+			var taskID int64
+			err := s.AddTask(ctx, entry.job.Handler, entry.job.Param, now, func(id int64) { taskID = id })
+			if err != nil {
+				logger.Error("create task for cron job %s run at %d failed: %v", job.Name, ts, err)
+				continue
+			}
+			logger.Info("cron job %s run at %d created task %d", job.Name, ts, taskID)
+			if err = dal.SetLastTask(ctx, job.Name, taskID); err != nil {
+				logger.Error("save last task of cron job %s failed: %v", job.Name, err)
+			}
+		}
+	}
+}
+
+// cronRuns lists runs from nextRun up to now, and the first run after now
+func (s *scheduler) cronRuns(entry *cronEntry, nextRun, now int64) ([]int64, int64) {
+	runs := []int64{}
+	ts := nextRun
+	for ts > 0 && ts <= now && len(runs) < CRON_MAX_CATCHUP {
+		runs = append(runs, ts)
+		ts = entry.sched.Next(ts)
+	}
+	if ts > 0 && ts <= now {
+		ts = entry.sched.Next(now)
+	}
+	return runs, ts
+}
+
+// cronFilter applies catchup & overlap policies to due runs
+func (s *scheduler) cronFilter(ctx *swe.Context, entry *cronEntry, job *db.CronJob, runs []int64, now int64) []int64 {
+	if len(runs) == 0 {
+		return runs
+	}
+
+	missed, onTime := runs, []int64{}
+	if last := runs[len(runs)-1]; now-last <= CRON_GRACE_SECONDS {
+		missed, onTime = runs[:len(runs)-1], runs[len(runs)-1:]
+	}
+	if len(missed) > 0 {
+		swe.CtxLogger(ctx).Warn("cron job %s missed %d runs", job.Name, len(missed))
+	}
+
+	switch entry.job.Catchup {
+	case db.CRON_CATCHUP_ALL:
+	case db.CRON_CATCHUP_ONCE:
+		runs = runs[len(runs)-1:]
+	default:
+		runs = onTime
+	}
+
+	if entry.job.Overlap == db.CRON_OVERLAP_SKIP && len(runs) > 0 {
+		if job.LastTaskID > 0 {
+			task, err := db.GetAsyncTaskDAL().Get(ctx, job.LastTaskID)
+			if err != nil {
+				swe.CtxLogger(ctx).Error("load last task of cron job %s failed: %v", job.Name, err)
+				return nil
+			}
+			if task != nil && (task.Status == db.ASYNC_TASK_IDLE || task.Status == db.ASYNC_TASK_RUNNING ||
+				task.Status == db.ASYNC_TASK_FAILED) {
+				swe.CtxLogger(ctx).Warn("cron job %s skipped, task %d of last run not finished", job.Name, task.ID)
+				return nil
+			}
+		}
+		// runs can not overlap each other either
+		runs = runs[len(runs)-1:]
+	}
+	return runs
+}
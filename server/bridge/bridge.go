@@ -10,9 +10,18 @@ type Receiver interface {
 	OnDelRoom(roomID int64)
 }
 
+// EventReceiver gets records collected from live rooms by any collector, events
+// relayed across processes may be delivered more than once
+type EventReceiver interface {
+	OnEvent(roomID int64, data []byte)
+}
+
 type Bridge interface {
 	Publisher
 	SetReceiver(Receiver)
+	// PublishEvent relays a record collected from a live room to core instances
+	PublishEvent(roomID int64, data []byte) error
+	SetEventReceiver(EventReceiver)
 	Start() error
 	Stop() error
 }
@@ -15,9 +15,10 @@ import (
 
 func CreateEtcdBridge(client *clientv3.Client) Bridge {
 	ret := &etcdBridge{
-		client: client,
-		rooms:  map[int64]bool{},
-		shadow: map[int64]bool{},
+		client:  client,
+		rooms:   map[int64]bool{},
+		shadow:  map[int64]bool{},
+		batches: map[int64][][]byte{},
 	}
 	ret.watcher = etcdutil.NewWatcher(client, ret, etcdRoomPrefix, clientv3.WithPrefix())
 	return ret
@@ -34,6 +35,14 @@ type etcdBridge struct {
 	shadow map[int64]bool
 	recv   Receiver
 
+	events       EventReceiver
+	eventWatcher *etcdutil.Watcher
+	leaseLock    sync.Mutex
+	leaseID      clientv3.LeaseID
+	leaseTime    int64
+	batchLock    sync.Mutex
+	batches      map[int64][][]byte // events waiting to be put by room
+
 	inReset     atomic.Bool
 	recvChanged atomic.Bool
 
@@ -77,7 +86,13 @@ func (b *etcdBridge) Start() error {
 }
 
 func (b *etcdBridge) Stop() error {
+	b.flushAllEvents()
 	atomic.AddInt32(&b.stopped, 1)
+	b.lock.Lock()
+	if b.eventWatcher != nil {
+		b.eventWatcher.Stop()
+	}
+	b.lock.Unlock()
 	return b.watcher.Stop()
 }
 
@@ -0,0 +1,184 @@
+package bridge
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/zerozwt/etcdutil"
+	"github.com/zerozwt/octant/server/utils"
+	"github.com/zerozwt/swe"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// live events are put under this prefix by collectors and watched by core instances,
+// keys expire in short time by leases shared among events put close together. Events of
+// a room collected close together are put as one key, each event prefixed by its length.
+var etcdEventPrefix string = "live_"
+
+const (
+	ETCD_EVENT_TTL         = 30
+	ETCD_EVENT_LEASE_SHARE = 10
+
+	ETCD_EVENT_BATCH_DELAY = 100 * time.Millisecond
+	ETCD_EVENT_BATCH_MAX   = 200
+)
+
+// PublishEvent queues the event, events of a room are put every ETCD_EVENT_BATCH_DELAY,
+// failures are logged only
+func (b *etcdBridge) PublishEvent(roomID int64, data []byte) error {
+	b.batchLock.Lock()
+	defer b.batchLock.Unlock()
+
+	pending, ok := b.batches[roomID]
+	pending = append(pending, data)
+	b.batches[roomID] = pending
+	if len(pending) >= ETCD_EVENT_BATCH_MAX {
+		delete(b.batches, roomID)
+		go b.putEvents(roomID, pending)
+	} else if !ok {
+		time.AfterFunc(ETCD_EVENT_BATCH_DELAY, func() { b.flushEvents(roomID) })
+	}
+	return nil
+}
+
+func (b *etcdBridge) flushEvents(roomID int64) {
+	b.batchLock.Lock()
+	pending := b.batches[roomID]
+	delete(b.batches, roomID)
+	b.batchLock.Unlock()
+
+	if len(pending) > 0 {
+		b.putEvents(roomID, pending)
+	}
+}
+
+// flushAllEvents puts events queued before stopping
+func (b *etcdBridge) flushAllEvents() {
+	b.batchLock.Lock()
+	batches := b.batches
+	b.batches = map[int64][][]byte{}
+	b.batchLock.Unlock()
+
+	for roomID, pending := range batches {
+		b.putEvents(roomID, pending)
+	}
+}
+
+func (b *etcdBridge) putEvents(roomID int64, events [][]byte) {
+	lease, err := b.eventLease()
+	if err == nil {
+		key := fmt.Sprintf("%s%d_%d", etcdEventPrefix, roomID, utils.GenerateID())
+		_, err = b.client.KV.Put(context.Background(), key, string(encodeEvents(events)), clientv3.WithLease(lease))
+	}
+	if err != nil {
+		swe.CtxLogger(nil).Error("put %d live events of room %d to etcd failed: %v", len(events), roomID, err)
+	}
+}
+
+func encodeEvents(events [][]byte) []byte {
+	ret := []byte{}
+	for _, item := range events {
+		ret = binary.AppendUvarint(ret, uint64(len(item)))
+		ret = append(ret, item...)
+	}
+	return ret
+}
+
+func decodeEvents(data []byte) ([][]byte, error) {
+	ret := [][]byte{}
+	for len(data) > 0 {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < size {
+			return ret, fmt.Errorf("bad live event batch")
+		}
+		ret = append(ret, data[n:n+int(size)])
+		data = data[n+int(size):]
+	}
+	return ret, nil
+}
+
+func (b *etcdBridge) eventLease() (clientv3.LeaseID, error) {
+	b.leaseLock.Lock()
+	defer b.leaseLock.Unlock()
+
+	now := time.Now().Unix()
+	if b.leaseID != 0 && now-b.leaseTime < ETCD_EVENT_LEASE_SHARE {
+		return b.leaseID, nil
+	}
+
+	rsp, err := b.client.Lease.Grant(context.Background(), ETCD_EVENT_TTL)
+	if err != nil {
+		return 0, err
+	}
+	b.leaseID, b.leaseTime = rsp.ID, now
+	return rsp.ID, nil
+}
+
+// SetEventReceiver starts watching live events, only instances serving live streams need to
+func (b *etcdBridge) SetEventReceiver(recv EventReceiver) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.events = recv
+	if recv != nil && b.eventWatcher == nil && atomic.LoadInt32(&b.stopped) == 0 {
+		b.eventWatcher = etcdutil.NewWatcher(b.client, &etcdEventHandler{bridge: b}, etcdEventPrefix, clientv3.WithPrefix())
+		b.eventWatcher.Start()
+	}
+}
+
+type etcdEventHandler struct {
+	bridge  *etcdBridge
+	inReset atomic.Bool
+}
+
+func (h *etcdEventHandler) OnPut(key, value []byte) {
+	// events put before the watch started are not live any more
+	if h.inReset.Load() {
+		return
+	}
+
+	tmp := strings.SplitN(strings.TrimPrefix(string(key), etcdEventPrefix), "_", 2)
+	roomID, err := strconv.ParseInt(tmp[0], 10, 64)
+	if err != nil {
+		return
+	}
+
+	h.bridge.lock.Lock()
+	recv := h.bridge.events
+	h.bridge.lock.Unlock()
+	if recv == nil {
+		return
+	}
+
+	events, err := decodeEvents(value)
+	if err != nil {
+		swe.CtxLogger(nil).Error("decode live events of key %s failed: %v", key, err)
+	}
+	for _, item := range events {
+		recv.OnEvent(roomID, item)
+	}
+}
+
+// events expired, nothing to do
+func (h *etcdEventHandler) OnDelete(key []byte) {}
+
+func (h *etcdEventHandler) OnError(err error) {
+	if atomic.LoadInt32(&h.bridge.stopped) > 0 {
+		return
+	}
+
+	swe.CtxLogger(nil).Error("etcd live event watcher error %v, try reconnect ...", err)
+
+	h.bridge.lock.Lock()
+	defer h.bridge.lock.Unlock()
+	h.bridge.eventWatcher = etcdutil.NewWatcher(h.bridge.client, h, etcdEventPrefix, clientv3.WithPrefix())
+	h.bridge.eventWatcher.Start()
+}
+
+func (h *etcdEventHandler) OnResetBegin() { h.inReset.Store(true) }
+func (h *etcdEventHandler) OnResetEnd()   { h.inReset.Store(false) }
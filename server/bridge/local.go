@@ -12,7 +12,8 @@ type localBridge struct {
 	lock  sync.Mutex
 	rooms map[int64]bool
 
-	recv Receiver
+	recv   Receiver
+	events EventReceiver
 }
 
 func (b *localBridge) AddRoom(roomID int64) error {
@@ -56,5 +57,22 @@ func (b *localBridge) SetReceiver(recv Receiver) {
 	}
 }
 
+func (b *localBridge) PublishEvent(roomID int64, data []byte) error {
+	b.lock.Lock()
+	events := b.events
+	b.lock.Unlock()
+
+	if events != nil {
+		events.OnEvent(roomID, data)
+	}
+	return nil
+}
+
+func (b *localBridge) SetEventReceiver(recv EventReceiver) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.events = recv
+}
+
 func (b *localBridge) Start() error { return nil }
 func (b *localBridge) Stop() error  { return nil }
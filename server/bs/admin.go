@@ -64,3 +64,77 @@ func (req AdminResetStreamerReq) Validate(ctx *swe.Context) error {
 	}
 	return nil
 }
+
+type AdminTaskListReq struct {
+	Page    int    `form:"page"`
+	Size    int    `form:"size"`
+	Handler string `form:"handler"`
+	Status  int    `form:"status"`
+}
+
+func (req AdminTaskListReq) Validate(ctx *swe.Context) error {
+	if err := (PageReq{Page: req.Page, Size: req.Size}).Validate(ctx); err != nil {
+		return err
+	}
+	if req.Status < 0 {
+		return fmt.Errorf("invalid status %d", req.Status)
+	}
+	return nil
+}
+
+type AdminTaskItem struct {
+	ID         int64  `json:"id"`
+	Handler    string `json:"handler"`
+	Param      string `json:"param"`
+	Status     int    `json:"status"`
+	Schedule   string `json:"schedule"`
+	Attempts   int    `json:"attempts"`
+	LastError  string `json:"last_error"`
+	CreateTime string `json:"create_time"`
+	UpdateTime string `json:"update_time"`
+}
+
+type AdminCronItem struct {
+	Name       string `json:"name"`
+	Spec       string `json:"spec"`
+	Handler    string `json:"handler"`
+	Param      string `json:"param"`
+	Catchup    int    `json:"catchup"`
+	Overlap    int    `json:"overlap"`
+	Enabled    bool   `json:"enabled"`
+	NextRun    string `json:"next_run"`
+	LastRun    string `json:"last_run"`
+	LastTaskID int64  `json:"last_task_id"`
+}
+
+type AdminCronEnableReq struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+func (req AdminCronEnableReq) Validate(ctx *swe.Context) error {
+	if len(req.Name) == 0 {
+		return fmt.Errorf("empty name")
+	}
+	return nil
+}
+
+type AdminTaskHandlerStats struct {
+	Handler string `json:"handler"`
+	Limit   int    `json:"limit"`
+	Running int    `json:"running"`
+	Ready   int    `json:"ready"`
+	Waiting int    `json:"waiting"`
+}
+
+type AdminTaskStatsRsp struct {
+	Workers  int                     `json:"workers"`
+	Running  int                     `json:"running"`
+	Ready    int                     `json:"ready"`
+	Waiting  int                     `json:"waiting"`
+	Handlers []AdminTaskHandlerStats `json:"handlers"`
+}
+
+type AdminDMMockReq struct {
+	Name string `json:"name" form:"name"`
+}
@@ -0,0 +1,116 @@
+package bs
+
+import (
+	"fmt"
+
+	"github.com/zerozwt/octant/server/utils"
+	"github.com/zerozwt/swe"
+)
+
+// AnalyticsReq takes start & end time in the configured timezone. Stats are read from rollups, so a
+// range not on whole hours is widened to whole hours.
+type AnalyticsReq struct {
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+	Unit      string `json:"unit"`
+	Top       int    `json:"top"`
+
+	startTs int64
+	endTs   int64
+}
+
+func (req AnalyticsReq) Validate(ctx *swe.Context) error {
+	if !utils.IsValidTimeString(req.StartTime) {
+		return fmt.Errorf("start time format invalid: %s", req.StartTime)
+	}
+	if !utils.IsValidTimeString(req.EndTime) {
+		return fmt.Errorf("end time format invalid: %s", req.EndTime)
+	}
+	if len(req.Unit) > 0 && !utils.IsValidTimeUnit(req.Unit) {
+		return fmt.Errorf("invalid time unit: %s", req.Unit)
+	}
+	if req.Top < 0 || req.Top > 100 {
+		return fmt.Errorf("invalid top %d", req.Top)
+	}
+	return nil
+}
+
+func (req *AnalyticsReq) ParseTimeRange() (err error) {
+	req.startTs, err = utils.LocalTimeStringToUTC(req.StartTime)
+	if err != nil {
+		return err
+	}
+	req.endTs, err = utils.LocalTimeStringToUTC(req.EndTime)
+	if err == nil && req.endTs <= req.startTs {
+		err = fmt.Errorf("end time %s not after start time %s", req.EndTime, req.StartTime)
+	}
+	return
+}
+
+func (req AnalyticsReq) StartTs() int64 { return req.startTs }
+func (req AnalyticsReq) EndTs() int64   { return req.endTs }
+
+func (req AnalyticsReq) TimeUnit() string {
+	if len(req.Unit) == 0 {
+		return utils.TIME_UNIT_DAY
+	}
+	return req.Unit
+}
+
+func (req AnalyticsReq) TopN() int {
+	if req.Top == 0 {
+		return 10
+	}
+	return req.Top
+}
+
+type AnalyticsGiftItem struct {
+	GiftID   int64  `json:"gift_id"`
+	GiftName string `json:"gift_name"`
+	Number   int64  `json:"number"`
+	Value    int64  `json:"value"`
+}
+
+type AnalyticsGuardItem struct {
+	Level  int   `json:"level"`
+	Count  int64 `json:"count"`
+	Months int64 `json:"months"`
+	Value  int64 `json:"value"`
+}
+
+// AnalyticsRevenueItem is the revenue of one time bucket,
+// gift values are in gold coins, others in CNY
+type AnalyticsRevenueItem struct {
+	Time       string               `json:"time"`
+	GiftNumber int64                `json:"gift_number"`
+	GiftValue  int64                `json:"gift_value"`
+	Gifts      []AnalyticsGiftItem  `json:"gifts"`
+	SCCount    int64                `json:"sc_count"`
+	SCValue    int64                `json:"sc_value"`
+	GuardCount int64                `json:"guard_count"`
+	GuardValue int64                `json:"guard_value"`
+	Guards     []AnalyticsGuardItem `json:"guards"`
+	Payers     int64                `json:"payers"`
+}
+
+type AnalyticsRevenueRsp struct {
+	Timezone string                 `json:"timezone"`
+	Unit     string                 `json:"unit"`
+	List     []AnalyticsRevenueItem `json:"list"`
+}
+
+type AnalyticsPayerItem struct {
+	UID        int64  `json:"uid"`
+	Name       string `json:"name"`
+	GiftValue  int64  `json:"gift_value"`
+	SCValue    int64  `json:"sc_value"`
+	GuardValue int64  `json:"guard_value"`
+}
+
+type AnalyticsHourItem struct {
+	Hour       int   `json:"hour"`
+	Count      int64 `json:"count"`
+	GiftValue  int64 `json:"gift_value"`
+	SCValue    int64 `json:"sc_value"`
+	GuardValue int64 `json:"guard_value"`
+}
@@ -3,6 +3,7 @@ package bs
 import (
 	"fmt"
 
+	"github.com/zerozwt/octant/server/utils"
 	"github.com/zerozwt/swe"
 )
 
@@ -22,6 +23,38 @@ func (req *DMSenderInfo) Validate(ctx *swe.Context) error {
 	return nil
 }
 
+// DMSchedule tells when a dm task sends, all in the configured timezone. StartTime is
+// YYYYMMDDHHMMSS and empty for now, the window is HHMM to HHMM and empty for all day.
+type DMSchedule struct {
+	StartTime   string `json:"start_time"`
+	WindowStart string `json:"window_start"`
+	WindowEnd   string `json:"window_end"`
+
+	startTs int64
+	window  utils.DayWindow
+}
+
+func (req *DMSchedule) Validate(ctx *swe.Context) (err error) {
+	if len(req.StartTime) > 0 {
+		if req.startTs, err = utils.LocalTimeStringToUTC(req.StartTime); err != nil {
+			return fmt.Errorf("start time invalid: %s", req.StartTime)
+		}
+	}
+	if len(req.WindowStart) == 0 && len(req.WindowEnd) == 0 {
+		return nil
+	}
+	if req.window.Start, err = utils.ParseDayMinute(req.WindowStart); err != nil {
+		return fmt.Errorf("window start invalid: %s", req.WindowStart)
+	}
+	if req.window.End, err = utils.ParseDayMinute(req.WindowEnd); err != nil {
+		return fmt.Errorf("window end invalid: %s", req.WindowEnd)
+	}
+	return nil
+}
+
+func (req DMSchedule) StartTs() int64          { return req.startTs }
+func (req DMSchedule) Window() utils.DayWindow { return req.window }
+
 type DMCreateReq struct {
 	EventID     int64        `json:"event_id"`
 	Name        string       `json:"name"`
@@ -31,7 +64,10 @@ type DMCreateReq struct {
 	IntervalMin int          `json:"interval_min"`
 	IntervalMax int          `json:"interval_max"`
 	RunTask     bool         `json:"run_task"`
+	Policy      int          `json:"sender_policy"` // db.DM_POLICY_*, sender is not needed if sent with pool
+	Transport   string       `json:"transport"`     // empty for bilibili
 	Sender      DMSenderInfo `json:"sender"`
+	Schedule    DMSchedule   `json:"schedule"`
 }
 
 func (req *DMCreateReq) Validate(ctx *swe.Context) error {
@@ -47,12 +83,31 @@ func (req *DMCreateReq) Validate(ctx *swe.Context) error {
 	if req.IntervalMin > req.IntervalMax || req.IntervalMin < 0 || req.IntervalMax < 0 {
 		return fmt.Errorf("invalid interval range")
 	}
-	if req.RunTask {
-		return req.Sender.Validate(ctx)
+	if err := req.Schedule.Validate(ctx); err != nil {
+		return err
+	}
+	return validateTaskSender(ctx, req.RunTask, req.Policy, &req.Sender)
+}
+
+func validateTaskSender(ctx *swe.Context, run bool, policy int, sender *DMSenderInfo) error {
+	if policy < 0 || policy > 2 {
+		return fmt.Errorf("invalid sender policy %d", policy)
+	}
+	if run && policy == 0 {
+		return sender.Validate(ctx)
 	}
 	return nil
 }
 
+// DMSenderStatus shows the sender a task sends with, credentials are never returned
+type DMSenderStatus struct {
+	UID        int64  `json:"uid"`
+	ExpireTime string `json:"expire_time"`
+	Expired    bool   `json:"expired"`
+	Status     int    `json:"status"`
+	Reason     string `json:"reason"`
+}
+
 type DMTaskListItem struct {
 	ID          int64  `json:"id"`
 	Name        string `json:"name"`
@@ -65,6 +120,7 @@ type DMTaskListItem struct {
 		ID   int64  `json:"id"`
 		Name string `json:"name"`
 	} `json:"event"`
+	Sender *DMSenderStatus `json:"sender"`
 }
 
 type DMTaskDetail struct {
@@ -78,22 +134,233 @@ type DMTaskDetail struct {
 	Succ        int    `json:"succ"`
 	Fail        int    `json:"fail"`
 	Total       int    `json:"total"`
+	Retryable   int    `json:"retryable"`
+	SendMode    int    `json:"send_mode"`
+	Policy      int    `json:"sender_policy"`
+	Transport   string `json:"transport"`
+	StartTime   string `json:"start_time"`
+	WindowStart string `json:"window_start"`
+	WindowEnd   string `json:"window_end"`
+	ParentID    int64  `json:"parent_id"`
+
+	Sender *DMSenderStatus `json:"sender"`
 }
 
 type DMSetSenderReq struct {
 	TaskID  int64        `json:"task_id"`
 	RunTask bool         `json:"run_task"`
+	Mode    int          `json:"mode"` // db.DM_SEND_*, 0 keeps the mode of last run
+	Policy  int          `json:"sender_policy"`
 	Sender  DMSenderInfo `json:"sender"`
 }
 
 func (req *DMSetSenderReq) Validate(ctx *swe.Context) error {
-	if req.RunTask {
-		return req.Sender.Validate(ctx)
+	if err := validateSendMode(req.Mode); err != nil {
+		return err
 	}
-	return nil
+	return validateTaskSender(ctx, req.RunTask, req.Policy, &req.Sender)
 }
 
 type DMSwitchReq struct {
 	TaskID  int64 `json:"task_id"`
 	RunTask bool  `json:"run_task"`
+	Mode    int   `json:"mode"` // db.DM_SEND_*, 0 keeps the mode of last run
+}
+
+func (req *DMSwitchReq) Validate(ctx *swe.Context) error {
+	return validateSendMode(req.Mode)
+}
+
+func validateSendMode(mode int) error {
+	if mode < 0 || mode > 3 {
+		return fmt.Errorf("invalid send mode %d", mode)
+	}
+	return nil
+}
+
+type DMAccountAddReq struct {
+	Interval int          `json:"interval"` // seconds between two messages, 0 for default
+	Sender   DMSenderInfo `json:"sender"`
+}
+
+func (req *DMAccountAddReq) Validate(ctx *swe.Context) error {
+	if req.Interval < 0 {
+		return fmt.Errorf("invalid interval %d", req.Interval)
+	}
+	return req.Sender.Validate(ctx)
+}
+
+type DMAccountItem struct {
+	ID         int64  `json:"id"`
+	UID        int64  `json:"uid"`
+	ExpireTime string `json:"expire_time"`
+	Expired    bool   `json:"expired"`
+	Interval   int    `json:"interval"`
+	Status     int    `json:"status"`
+	FailCount  int    `json:"fail_count"`
+	Reason     string `json:"reason"`
+	LastUsed   string `json:"last_used"`
+}
+
+type DMAccountEnableReq struct {
+	ID     int64 `json:"id"`
+	Enable bool  `json:"enable"`
+}
+
+type DMSetScheduleReq struct {
+	TaskID   int64      `json:"task_id"`
+	Schedule DMSchedule `json:"schedule"`
+}
+
+func (req *DMSetScheduleReq) Validate(ctx *swe.Context) error {
+	return req.Schedule.Validate(ctx)
+}
+
+type DMTemplateVarsRsp struct {
+	Vars []string `json:"vars"`
+}
+
+type DMPreviewReq struct {
+	EventID int64  `json:"event_id"`
+	Content string `json:"content"`
+	TLS     bool   `json:"tls"`
+	Samples int    `json:"samples"` // recipients to render, 5 if 0
+}
+
+func (req *DMPreviewReq) Validate(ctx *swe.Context) error {
+	if len(req.Content) == 0 {
+		return fmt.Errorf("no dm content")
+	}
+	if len(req.Content) > 4096 {
+		return fmt.Errorf("content too long")
+	}
+	if req.Samples < 0 || req.Samples > 50 {
+		return fmt.Errorf("invalid samples %d", req.Samples)
+	}
+	return nil
+}
+
+type DMPreviewItem struct {
+	UID     int64  `json:"uid"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+type DMPreviewFailure struct {
+	UID    int64  `json:"uid"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// DMPreviewRsp renders some recipients, and checks all of them for ones that can not be rendered
+type DMPreviewRsp struct {
+	Total    int                `json:"total"`
+	Failed   int                `json:"failed"`
+	Samples  []DMPreviewItem    `json:"samples"`
+	Failures []DMPreviewFailure `json:"failures"`
+}
+
+// DMTestSendReq sends the message rendered for a recipient to the streamer, UID 0 for the first one
+type DMTestSendReq struct {
+	EventID   int64        `json:"event_id"`
+	Content   string       `json:"content"`
+	TLS       bool         `json:"tls"`
+	UID       int64        `json:"uid"`
+	Policy    int          `json:"sender_policy"`
+	Transport string       `json:"transport"`
+	Sender    DMSenderInfo `json:"sender"`
+}
+
+func (req *DMTestSendReq) Validate(ctx *swe.Context) error {
+	if len(req.Content) == 0 {
+		return fmt.Errorf("no dm content")
+	}
+	if len(req.Content) > 4096 {
+		return fmt.Errorf("content too long")
+	}
+	return validateTaskSender(ctx, true, req.Policy, &req.Sender)
+}
+
+type DMTestSendRsp struct {
+	SenderUID   int64  `json:"sender_uid"`
+	ReceiverUID int64  `json:"receiver_uid"`
+	Content     string `json:"content"`
+}
+
+type DMTransportsRsp struct {
+	Transports []string `json:"transports"`
+}
+
+type DMDetailListReq struct {
+	TaskID int64 `form:"task_id"`
+	Page   int   `form:"page"`
+	Size   int   `form:"size"`
+	Status int   `form:"status"` // db.DM_DETAIL_STATUS_*, 0 for all
+}
+
+func (req DMDetailListReq) Validate(ctx *swe.Context) error {
+	if err := (PageReq{Page: req.Page, Size: req.Size}).Validate(ctx); err != nil {
+		return err
+	}
+	return validateDetailStatus(req.Status)
+}
+
+type DMDetailDownloadReq struct {
+	TaskID int64 `form:"task_id"`
+	Status int   `form:"status"` // db.DM_DETAIL_STATUS_*, 0 for all
+}
+
+func (req DMDetailDownloadReq) Validate(ctx *swe.Context) error {
+	return validateDetailStatus(req.Status)
+}
+
+func validateDetailStatus(status int) error {
+	if status < 0 || status > 3 {
+		return fmt.Errorf("invalid detail status %d", status)
+	}
+	return nil
+}
+
+type DMDetailItem struct {
+	UID        int64  `json:"uid"`
+	Name       string `json:"name"`
+	Content    string `json:"content"`
+	Status     int    `json:"status"`
+	SendTime   string `json:"send_time"`
+	Attempts   int    `json:"attempts"`
+	FailKind   int    `json:"fail_kind"`
+	FailReason string `json:"fail_reason"`
+	NextRetry  string `json:"next_retry"`
+}
+
+// DMFollowUpReq creates a task sending the messages of another task again to its recipients in Statuses
+type DMFollowUpReq struct {
+	TaskID    int64        `json:"task_id"`
+	Name      string       `json:"name"`      // name of the task followed up with a suffix if empty
+	Statuses  []int        `json:"statuses"`  // db.DM_DETAIL_STATUS_NOT_SEND and/or db.DM_DETAIL_STATUS_FAIL
+	Permanent bool         `json:"permanent"` // also recipients failed permanently, e.g. refusing messages
+	RunTask   bool         `json:"run_task"`
+	Policy    int          `json:"sender_policy"`
+	Sender    DMSenderInfo `json:"sender"`
+	Schedule  DMSchedule   `json:"schedule"`
+}
+
+func (req *DMFollowUpReq) Validate(ctx *swe.Context) error {
+	if len(req.Statuses) == 0 {
+		return fmt.Errorf("no recipient status to follow up")
+	}
+	for _, status := range req.Statuses {
+		if status != 1 && status != 2 {
+			return fmt.Errorf("invalid recipient status %d to follow up", status)
+		}
+	}
+	if err := req.Schedule.Validate(ctx); err != nil {
+		return err
+	}
+	return validateTaskSender(ctx, req.RunTask, req.Policy, &req.Sender)
+}
+
+type DMFollowUpRsp struct {
+	ID    int64 `json:"id"`
+	Total int   `json:"total"`
 }
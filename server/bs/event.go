@@ -181,6 +181,16 @@ type EventDetailRsp struct {
 	Condition EventCondition `json:"conditions"`
 	Hidden    bool           `json:"hidden"`
 	Status    int            `json:"status"`
+	Progress  EventProgress  `json:"progress"`
+}
+
+type EventProgress struct {
+	Phase     int    `json:"phase"`
+	Done      int64  `json:"done"`
+	Total     int64  `json:"total"`
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+	Error     string `json:"error"`
 }
 
 type EventUserListReq struct {
@@ -203,14 +213,30 @@ func (req EventUserListReq) Validate(ctx *swe.Context) error {
 }
 
 type EventUserListItem struct {
-	UID   int64          `json:"uid"`
-	Name  string         `json:"name"`
-	Time  string         `json:"time"`
-	Cols  map[string]any `json:"cols"`
-	Block bool           `json:"block"`
+	UID   int64             `json:"uid"`
+	Name  string            `json:"name"`
+	Names []NameHistoryItem `json:"names"`
+	Time  string            `json:"time"`
+	Cols  map[string]any    `json:"cols"`
+	Block bool              `json:"block"`
 }
 
 type EventUIDReq struct {
 	EventID int64 `json:"event_id"`
 	UID     int64 `json:"uid"`
 }
+
+type EventDownloadReq struct {
+	ID     int64  `form:"id"`
+	Format string `form:"format"`
+}
+
+type EventAddrCheckItem struct {
+	UID      int64    `json:"uid"`
+	Name     string   `json:"name"`
+	Province string   `json:"province"`
+	City     string   `json:"city"`
+	District string   `json:"district"`
+	Detail   string   `json:"detail"`
+	Problems []string `json:"problems"`
+}
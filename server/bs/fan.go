@@ -0,0 +1,95 @@
+package bs
+
+import (
+	"fmt"
+
+	"github.com/zerozwt/octant/server/utils"
+	"github.com/zerozwt/swe"
+)
+
+type FanProfileReq struct {
+	UID       int64  `json:"uid"`
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+
+	startTs int64
+	endTs   int64
+}
+
+func (req *FanProfileReq) Validate(ctx *swe.Context) error {
+	if req.UID < 1 {
+		return fmt.Errorf("invalid uid %d", req.UID)
+	}
+	if len(req.StartTime) == 0 && len(req.EndTime) == 0 {
+		return nil
+	}
+	if !utils.IsValidTimeString(req.StartTime) {
+		return fmt.Errorf("start time format invalid: %s", req.StartTime)
+	}
+	if !utils.IsValidTimeString(req.EndTime) {
+		return fmt.Errorf("end time format invalid: %s", req.EndTime)
+	}
+	return nil
+}
+
+func (req *FanProfileReq) ParseTimeRange() (err error) {
+	if !req.HasPeriod() {
+		return nil
+	}
+	req.startTs, err = utils.LocalTimeStringToUTC(req.StartTime)
+	if err != nil {
+		return err
+	}
+	req.endTs, err = utils.LocalTimeStringToUTC(req.EndTime)
+	if err == nil && req.startTs > req.endTs {
+		err = fmt.Errorf("start time %s later than end time %s", req.StartTime, req.EndTime)
+	}
+	return
+}
+
+func (req FanProfileReq) HasPeriod() bool { return len(req.StartTime) > 0 }
+func (req FanProfileReq) StartTs() int64  { return req.startTs }
+func (req FanProfileReq) EndTs() int64    { return req.endTs }
+
+type FanPaySummary struct {
+	GiftCount   int64 `json:"gift_count"`
+	GiftValue   int64 `json:"gift_value"`
+	SCCount     int64 `json:"sc_count"`
+	SCValue     int64 `json:"sc_value"`
+	GuardCount  int64 `json:"guard_count"`
+	GuardMonths int64 `json:"guard_months"`
+}
+
+type FanGuardItem struct {
+	Time  string `json:"time"`
+	Level int    `json:"level"`
+	Count int    `json:"count"`
+}
+
+type FanDMItem struct {
+	TaskID int64  `json:"task_id"`
+	Status int    `json:"status"`
+	Time   string `json:"time"`
+}
+
+type FanEventItem struct {
+	ID     int64       `json:"id"`
+	Name   string      `json:"name"`
+	Reward string      `json:"reward"`
+	Time   string      `json:"time"`
+	Block  bool        `json:"block"`
+	Addr   bool        `json:"addr"`
+	DMs    []FanDMItem `json:"dms"`
+}
+
+type FanProfileRsp struct {
+	UID       int64             `json:"uid"`
+	Name      string            `json:"name"`
+	FirstSeen string            `json:"first_seen"`
+	LastSeen  string            `json:"last_seen"`
+	Lifetime  FanPaySummary     `json:"lifetime"`
+	Period    *FanPaySummary    `json:"period"`
+	Guards    []FanGuardItem    `json:"guards"`
+	Names     []NameHistoryItem `json:"names"`
+	Events    []FanEventItem    `json:"events"`
+}
@@ -31,3 +31,9 @@ type PageRsp struct {
 type IDReq struct {
 	ID int64 `json:"id" form:"id"`
 }
+
+type NameHistoryItem struct {
+	Name      string `json:"name"`
+	FirstSeen string `json:"first_seen"`
+	LastSeen  string `json:"last_seen"`
+}
@@ -0,0 +1,89 @@
+package bs
+
+import (
+	"fmt"
+
+	"github.com/zerozwt/swe"
+)
+
+type GuardRosterReq struct {
+	Page       int    `json:"page"`
+	Size       int    `json:"size"`
+	UID        int64  `json:"uid"`
+	Name       string `json:"name"`
+	GuardLevel []int  `json:"guard_level"`
+	ExpireDays int    `json:"expire_days"`
+}
+
+func (req GuardRosterReq) Validate(ctx *swe.Context) error {
+	if req.Page < 1 {
+		return fmt.Errorf("invalid page %d", req.Page)
+	}
+	if req.Size < 1 || req.Size > 100 {
+		return fmt.Errorf("invalid size %d", req.Size)
+	}
+	if req.ExpireDays < 0 {
+		return fmt.Errorf("invalid expire days %d", req.ExpireDays)
+	}
+	return validateGuardLevels(req.GuardLevel)
+}
+
+type GuardRosterItem struct {
+	UID         int64  `json:"uid"`
+	Name        string `json:"name"`
+	Level       int    `json:"level"`
+	ExpireTime  string `json:"expire_time"`
+	FinalTime   string `json:"final_time"`
+	RemainDays  int    `json:"remain_days"`
+	FirstTime   string `json:"first_time"`
+	TotalMonths int    `json:"total_months"`
+}
+
+type GuardRemindReq struct {
+	Name        string       `json:"name"`
+	Content     string       `json:"content"`
+	ExpireDays  int          `json:"expire_days"`
+	GuardLevel  []int        `json:"guard_level"`
+	BatchMax    int          `json:"batch_max"`
+	IntervalMin int          `json:"interval_min"`
+	IntervalMax int          `json:"interval_max"`
+	RunTask     bool         `json:"run_task"`
+	Policy      int          `json:"sender_policy"`
+	Transport   string       `json:"transport"`
+	Sender      DMSenderInfo `json:"sender"`
+	Schedule    DMSchedule   `json:"schedule"`
+}
+
+func (req *GuardRemindReq) Validate(ctx *swe.Context) error {
+	if len(req.Name) == 0 {
+		return fmt.Errorf("dm task no name")
+	}
+	if len(req.Content) == 0 {
+		return fmt.Errorf("no dm content")
+	}
+	if len(req.Content) > 4096 {
+		return fmt.Errorf("content too long")
+	}
+	if req.ExpireDays < 1 {
+		return fmt.Errorf("invalid expire days %d", req.ExpireDays)
+	}
+	if req.IntervalMin > req.IntervalMax || req.IntervalMin < 0 || req.IntervalMax < 0 {
+		return fmt.Errorf("invalid interval range")
+	}
+	if err := validateGuardLevels(req.GuardLevel); err != nil {
+		return err
+	}
+	if err := req.Schedule.Validate(ctx); err != nil {
+		return err
+	}
+	return validateTaskSender(ctx, req.RunTask, req.Policy, &req.Sender)
+}
+
+func validateGuardLevels(levels []int) error {
+	for _, level := range levels {
+		if level < 1 || level > 3 {
+			return fmt.Errorf("invalid guard level %d", level)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package bs
+
+import (
+	"fmt"
+
+	"github.com/zerozwt/swe"
+)
+
+// IngestRecord is a paid record collected from a live room, shipped by remote collectors to core
+type IngestRecord struct {
+	ID        int64  `json:"id"`
+	Key       string `json:"key"`  // random key given by the collector, a record shipped again is saved once
+	Kind      string `json:"kind"` // sc, guard or gift
+	RoomID    int64  `json:"room_id"`
+	Time      int64  `json:"time"`
+	UID       int64  `json:"uid"`
+	Name      string `json:"name"`
+	Price     int64  `json:"price,omitempty"`      // CNY of sc, gold coins of one gift
+	Count     int64  `json:"count,omitempty"`      // gifts or guard months
+	Message   string `json:"message,omitempty"`    // sc only
+	BgColor   string `json:"bg_color,omitempty"`   // sc only
+	FontColor string `json:"font_color,omitempty"` // sc only
+	Level     int    `json:"guard_level,omitempty"`
+	GiftID    int64  `json:"gift_id,omitempty"`
+	GiftName  string `json:"gift_name,omitempty"` // name of gift or guard
+	BatchID   string `json:"batch_id,omitempty"`  // gifts of a combo share one batch
+}
+
+const (
+	INGEST_MAX_RECORDS = 1000
+	INGEST_MAX_KEY_LEN = 64
+)
+
+type IngestReq struct {
+	Records []IngestRecord `json:"records"`
+}
+
+func (req IngestReq) Validate(ctx *swe.Context) error {
+	if len(req.Records) == 0 || len(req.Records) > INGEST_MAX_RECORDS {
+		return fmt.Errorf("invalid number of records %d", len(req.Records))
+	}
+	for _, item := range req.Records {
+		if item.ID == 0 || item.RoomID <= 0 {
+			return fmt.Errorf("invalid record %d of room %d", item.ID, item.RoomID)
+		}
+		if len(item.Key) == 0 || len(item.Key) > INGEST_MAX_KEY_LEN {
+			return fmt.Errorf("invalid key of record %d", item.ID)
+		}
+		switch item.Kind {
+		case "sc", "guard":
+		case "gift":
+			if len(item.BatchID) == 0 {
+				return fmt.Errorf("no batch id for gift record %d", item.ID)
+			}
+		default:
+			return fmt.Errorf("invalid kind %s of record %d", item.Kind, item.ID)
+		}
+	}
+	return nil
+}
+
+// IngestRsp tells how many records are saved, records saved before are skipped
+type IngestRsp struct {
+	Saved   int `json:"saved"`
+	Skipped int `json:"skipped"`
+}
@@ -0,0 +1,29 @@
+package bs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zerozwt/swe"
+)
+
+type LiveStreamReq struct {
+	Types  string `form:"types"`   // sc, guard and/or gift joined by comma, empty for all
+	LastID int64  `form:"last_id"` // for clients not able to send Last-Event-ID
+}
+
+func (req LiveStreamReq) Validate(ctx *swe.Context) error {
+	for _, item := range req.TypeList() {
+		if item != "sc" && item != "guard" && item != "gift" {
+			return fmt.Errorf("invalid event type %s", item)
+		}
+	}
+	return nil
+}
+
+func (req LiveStreamReq) TypeList() []string {
+	if len(req.Types) == 0 {
+		return nil
+	}
+	return strings.Split(req.Types, ",")
+}
@@ -0,0 +1,86 @@
+package bs
+
+import (
+	"fmt"
+
+	"github.com/zerozwt/swe"
+)
+
+// OverlayReq adds an overlay if ID is 0, or modifies one, whose kind can not be changed
+type OverlayReq struct {
+	ID         int64   `json:"id"`
+	Kind       string  `json:"kind"` // sc_wall or goal
+	Name       string  `json:"name"`
+	Title      string  `json:"title"`
+	MinSC      int64   `json:"min_sc"`      // CNY, sc wall only
+	MaxItems   int     `json:"max_items"`   // sc wall only, 0 for default
+	GoalUnit   string  `json:"goal_unit"`   // value or count, goal only
+	GoalTarget int64   `json:"goal_target"` // gold coins or gifts, goal only
+	GiftIDs    []int64 `json:"gift_ids"`    // goal only, empty for all gifts
+}
+
+const (
+	OVERLAY_MAX_ITEMS_DEFAULT = 10
+	OVERLAY_MAX_ITEMS_LIMIT   = 50
+	OVERLAY_MAX_GIFTS         = 64
+)
+
+func (req *OverlayReq) Validate(ctx *swe.Context) error {
+	if len(req.Name) == 0 || len(req.Name) > 256 {
+		return fmt.Errorf("invalid overlay name")
+	}
+	if len(req.Title) > 256 {
+		return fmt.Errorf("overlay title too long")
+	}
+	switch req.Kind {
+	case "sc_wall":
+		if req.MinSC < 0 || req.MaxItems < 0 || req.MaxItems > OVERLAY_MAX_ITEMS_LIMIT {
+			return fmt.Errorf("invalid sc wall settings")
+		}
+	case "goal":
+		if req.GoalUnit != "value" && req.GoalUnit != "count" {
+			return fmt.Errorf("invalid goal unit %s", req.GoalUnit)
+		}
+		if req.GoalTarget <= 0 {
+			return fmt.Errorf("invalid goal target %d", req.GoalTarget)
+		}
+		if len(req.GiftIDs) > OVERLAY_MAX_GIFTS {
+			return fmt.Errorf("too many gifts for goal")
+		}
+		for _, item := range req.GiftIDs {
+			if item <= 0 {
+				return fmt.Errorf("invalid gift id %d", item)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid overlay kind %s", req.Kind)
+	}
+	return nil
+}
+
+// OverlayItem shows an overlay, Path with its token is the url to paste into OBS
+type OverlayItem struct {
+	ID         int64   `json:"id"`
+	Kind       string  `json:"kind"`
+	Name       string  `json:"name"`
+	Title      string  `json:"title"`
+	MinSC      int64   `json:"min_sc"`
+	MaxItems   int     `json:"max_items"`
+	GoalUnit   string  `json:"goal_unit"`
+	GoalTarget int64   `json:"goal_target"`
+	GiftIDs    []int64 `json:"gift_ids"`
+	Path       string  `json:"path"`
+	StartTime  string  `json:"start_time"`
+	CreateTime string  `json:"create_time"`
+	UpdateTime string  `json:"update_time"`
+}
+
+// OverlayGoalData is the progress of a goal overlay, Text is how it is shown, e.g. ¥12.5 / ¥100
+type OverlayGoalData struct {
+	Title   string  `json:"title"`
+	Unit    string  `json:"unit"`
+	Current int64   `json:"current"`
+	Target  int64   `json:"target"`
+	Percent float64 `json:"percent"` // may exceed 100
+	Text    string  `json:"text"`
+}
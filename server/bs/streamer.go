@@ -71,10 +71,11 @@ func (req SimpleSearchReq) IsGift() bool      { return req.DataSource == "gift"
 func (req SimpleSearchReq) IsMember() bool    { return req.DataSource == "member" }
 
 type SimpleSearchItem struct {
-	UID  int64  `json:"uid"`
-	Name string `json:"name"`
-	Time string `json:"time"`
-	Gift struct {
+	UID   int64             `json:"uid"`
+	Name  string            `json:"name"`
+	Names []NameHistoryItem `json:"names"`
+	Time  string            `json:"time"`
+	Gift  struct {
 		ID    int64  `json:"id"`
 		Name  string `json:"name"`
 		Price int64  `json:"price"`
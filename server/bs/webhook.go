@@ -0,0 +1,113 @@
+package bs
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/zerozwt/octant/server/utils"
+	"github.com/zerozwt/swe"
+)
+
+// WebhookReq adds a webhook if ID is 0, or modifies one keeping its secret if Secret is empty
+type WebhookReq struct {
+	ID         int64    `json:"id"`
+	Name       string   `json:"name"`
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret"`
+	Events     []string `json:"events"`      // sc, guard and/or gift
+	MinSC      int64    `json:"min_sc"`      // CNY
+	MinGift    int64    `json:"min_gift"`    // gold coins of price * count
+	GuardLevel int      `json:"guard_level"` // highest level number to post, 0 for all
+	Template   string   `json:"template"`    // json payload with {var}, empty to post the event as it is
+	Enabled    bool     `json:"enabled"`
+}
+
+func (req *WebhookReq) Validate(ctx *swe.Context) error {
+	if len(req.Name) == 0 || len(req.Name) > 256 {
+		return fmt.Errorf("invalid webhook name")
+	}
+	if len(req.URL) > 1024 {
+		return fmt.Errorf("webhook url too long")
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("invalid webhook url %s", req.URL)
+	}
+	if err = utils.CheckPublicHost(u.Hostname()); err != nil {
+		return fmt.Errorf("invalid webhook url %s: %v", req.URL, err)
+	}
+	if len(req.Secret) > 256 {
+		return fmt.Errorf("webhook secret too long")
+	}
+	if len(req.Events) == 0 {
+		return fmt.Errorf("no event for webhook")
+	}
+	for _, item := range req.Events {
+		if item != "sc" && item != "guard" && item != "gift" {
+			return fmt.Errorf("invalid webhook event %s", item)
+		}
+	}
+	if req.MinSC < 0 || req.MinGift < 0 || req.GuardLevel < 0 || req.GuardLevel > 3 {
+		return fmt.Errorf("invalid webhook threshold")
+	}
+	if len(req.Template) > 4096 {
+		return fmt.Errorf("webhook template too long")
+	}
+	return nil
+}
+
+// WebhookItem shows a webhook, its secret is never returned
+type WebhookItem struct {
+	ID         int64    `json:"id"`
+	Name       string   `json:"name"`
+	URL        string   `json:"url"`
+	HasSecret  bool     `json:"has_secret"`
+	Events     []string `json:"events"`
+	MinSC      int64    `json:"min_sc"`
+	MinGift    int64    `json:"min_gift"`
+	GuardLevel int      `json:"guard_level"`
+	Template   string   `json:"template"`
+	Enabled    bool     `json:"enabled"`
+	CreateTime string   `json:"create_time"`
+	UpdateTime string   `json:"update_time"`
+}
+
+type WebhookDeliveryListReq struct {
+	HookID int64 `form:"hook_id"`
+	Page   int   `form:"page"`
+	Size   int   `form:"size"`
+	Status int   `form:"status"` // db.WEBHOOK_DELIVERY_*, 0 for all
+}
+
+func (req WebhookDeliveryListReq) Validate(ctx *swe.Context) error {
+	if err := (PageReq{Page: req.Page, Size: req.Size}).Validate(ctx); err != nil {
+		return err
+	}
+	if req.Status < 0 || req.Status > 3 {
+		return fmt.Errorf("invalid delivery status %d", req.Status)
+	}
+	return nil
+}
+
+type WebhookDeliveryItem struct {
+	ID         int64  `json:"id"`
+	Event      string `json:"event"`
+	Payload    string `json:"payload"`
+	Status     int    `json:"status"`
+	Attempts   int    `json:"attempts"`
+	StatusCode int    `json:"status_code"`
+	Error      string `json:"error"`
+	CreateTime string `json:"create_time"`
+	UpdateTime string `json:"update_time"`
+}
+
+// WebhookTestRsp is the result of posting a test event to a webhook
+type WebhookTestRsp struct {
+	StatusCode int    `json:"status_code"`
+	Error      string `json:"error"`
+	Payload    string `json:"payload"`
+}
+
+type WebhookTemplateVarsRsp struct {
+	Vars []string `json:"vars"`
+}
@@ -7,7 +7,9 @@ import (
 
 	dm "github.com/zerozwt/BLiveDanmaku"
 	"github.com/zerozwt/BLiveDanmaku/cmds"
-	"github.com/zerozwt/octant/server/db"
+	"github.com/zerozwt/octant/server/bs"
+	"github.com/zerozwt/octant/server/ingest"
+	"github.com/zerozwt/octant/server/utils"
 	"github.com/zerozwt/swe"
 )
 
@@ -23,14 +25,30 @@ type Collector struct {
 	rooms map[int64]*room
 	lock  sync.Mutex
 	stop  atomic.Bool
+	sink  atomic.Value
 }
 
-var cc *Collector = &Collector{rooms: map[int64]*room{}}
+var cc *Collector = newCollector()
+
+func newCollector() *Collector {
+	ret := &Collector{rooms: map[int64]*room{}}
+	ret.SetSink(ingest.DBSink{})
+	return ret
+}
 
 func GetCollector() *Collector {
 	return cc
 }
 
+// SetSink changes where records collected go, they are saved to db by default
+func (c *Collector) SetSink(sink ingest.Sink) {
+	c.sink.Store(&sink)
+}
+
+func (c *Collector) Sink() ingest.Sink {
+	return *c.sink.Load().(*ingest.Sink)
+}
+
 func (c *Collector) OnAddRoom(roomID int64) {
 	if c.stop.Load() {
 		return
@@ -167,6 +185,14 @@ func (r *room) onDisconnect(client *dm.Client, err error) {
 	}()
 }
 
+// put passes a record collected to the sink of collector
+func (r *room) put(rec *bs.IngestRecord) {
+	rec.ID = utils.GenerateID()
+	rec.Key = utils.RandomKey()
+	rec.RoomID = r.id
+	cc.Sink().Put(rec)
+}
+
 func (r *room) onSuperChat(client *dm.Client, cmd string, data []byte) bool {
 	if atomic.LoadInt32(&r.stopped) != 0 {
 		return true
@@ -177,11 +203,16 @@ func (r *room) onSuperChat(client *dm.Client, cmd string, data []byte) bool {
 		return true
 	}
 
-	err := db.GetSCDal().Insert(nil, r.id, msg.Timestamp, msg.UID, msg.User.UserName, int64(msg.Price),
-		msg.Message, msg.BackgroundColor, msg.MessageFontColor)
-	if err != nil {
-		swe.CtxLogger(nil).Error("insert sc to room %d failed: %v, original: %s", r.id, err, string(data))
-	}
+	r.put(&bs.IngestRecord{
+		Kind:      ingest.RECORD_SC,
+		Time:      msg.Timestamp,
+		UID:       msg.UID,
+		Name:      msg.User.UserName,
+		Price:     int64(msg.Price),
+		Message:   msg.Message,
+		BgColor:   msg.BackgroundColor,
+		FontColor: msg.MessageFontColor,
+	})
 	return false
 }
 
@@ -195,10 +226,15 @@ func (r *room) onGuardBuy(client *dm.Client, cmd string, data []byte) bool {
 		return true
 	}
 
-	err := db.GetMemberDal().Insert(nil, r.id, msg.StartTime, msg.UID, msg.UserName, msg.GuardLevel, msg.Num)
-	if err != nil {
-		swe.CtxLogger(nil).Error("insert member to room %d failed: %v, original: %s", r.id, err, string(data))
-	}
+	r.put(&bs.IngestRecord{
+		Kind:     ingest.RECORD_GUARD,
+		Time:     msg.StartTime,
+		UID:      msg.UID,
+		Name:     msg.UserName,
+		Count:    int64(msg.Num),
+		Level:    msg.GuardLevel,
+		GiftName: msg.GiftName,
+	})
 	return false
 }
 
@@ -216,23 +252,16 @@ func (r *room) onGift(client *dm.Client, cmd string, data []byte) bool {
 		return false
 	}
 
-	gift := db.GiftRecord{
-		BatchID:    msg.BatchComboID,
-		RoomID:     r.id,
-		SendTime:   msg.Timestamp,
-		SenderUID:  msg.UID,
-		SenderName: msg.UserName,
-		GiftID:     msg.GiftID,
-		GiftName:   msg.GiftName,
-		GiftPrice:  msg.Price,
-		GiftCount:  int64(msg.Num),
-	}
-
-	if err := db.GetGiftDAL().Insert(nil, &gift); err != nil {
-		swe.CtxLogger(nil).Error("insert gift to room %d failed: %v, original: %s", r.id, err, string(data))
-	}
-
-	db.GetGiftDAL().UpdateGiftInfo(nil, msg.GiftID, msg.GiftName, msg.Price)
-
+	r.put(&bs.IngestRecord{
+		Kind:     ingest.RECORD_GIFT,
+		Time:     msg.Timestamp,
+		UID:      msg.UID,
+		Name:     msg.UserName,
+		Price:    msg.Price,
+		Count:    int64(msg.Num),
+		GiftID:   msg.GiftID,
+		GiftName: msg.GiftName,
+		BatchID:  msg.BatchComboID,
+	})
 	return false
 }
@@ -2,8 +2,11 @@ package main
 
 import (
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/zerozwt/swe"
 	"gopkg.in/yaml.v3"
@@ -19,20 +22,68 @@ type LogConfig struct {
 	File  string `yaml:"file"`
 }
 
+type AsyncTaskConfig struct {
+	Workers int            `yaml:"workers"`
+	Limits  map[string]int `yaml:"limits"` // max running tasks by handler name
+}
+
+// DMTransportConfig defines a dm transport tasks can choose by name besides bilibili,
+// see batch_dm.MockTransport and batch_dm.WebhookTransport
+type DMTransportConfig struct {
+	Name    string        `yaml:"name"`
+	Type    string        `yaml:"type"` // mock or webhook
+	File    string        `yaml:"file"`
+	Codes   map[int64]int `yaml:"codes"`
+	URL     string        `yaml:"url"`
+	Secret  string        `yaml:"secret"`
+	Timeout int           `yaml:"timeout"` // seconds
+}
+
+// WebhookConfig allows webhooks to reach networks refused by default, e.g. a gateway in the LAN,
+// see utils.CheckPublicIP
+type WebhookConfig struct {
+	AllowNetworks []string `yaml:"allow_networks"` // cidr like 192.168.1.0/24
+}
+
+// IngestConfig lets collectors work without db credentials. Core accepts records signed with Secret
+// if it is set, collector only instances with CoreURL ship records to core instead of writing db.
+type IngestConfig struct {
+	Secret     string `yaml:"secret"`
+	CoreURL    string `yaml:"core_url"`
+	Spool      string `yaml:"spool"`       // file keeping records not shipped over restarts
+	BatchSize  int    `yaml:"batch_size"`  // records a request
+	BufferSize int    `yaml:"buffer_size"` // records kept while core is unreachable
+}
+
 type Config struct {
-	LocalHost bool          `yaml:"localhost"`
-	Port      uint16        `yaml:"port"`
-	DbEngine  string        `yaml:"db_engine"`
-	MySQL     string        `yaml:"mysql"`
-	SQLite    string        `yaml:"sqlite"`
-	WebDir    string        `yaml:"www_dir"`
-	Service   ConfigService `yaml:"service"`
-	Etcd      []string      `yaml:"etcd"`
-	Log       LogConfig     `yaml:"log"`
+	LocalHost    bool                `yaml:"localhost"`
+	Port         uint16              `yaml:"port"`
+	DbEngine     string              `yaml:"db_engine"`
+	MySQL        string              `yaml:"mysql"`
+	SQLite       string              `yaml:"sqlite"`
+	WebDir       string              `yaml:"www_dir"`
+	Service      ConfigService       `yaml:"service"`
+	Etcd         []string            `yaml:"etcd"`
+	Log          LogConfig           `yaml:"log"`
+	Timezone     string              `yaml:"timezone"`
+	AsyncTask    AsyncTaskConfig     `yaml:"async_task"`
+	DMTransports []DMTransportConfig `yaml:"dm_transports"`
+	DMSenderKey  string              `yaml:"dm_sender_key"` // see batch_dm.SetServerKey, env DM_SENDER_KEY_ENV overrides
+	Webhook      WebhookConfig       `yaml:"webhook"`
+	Ingest       IngestConfig        `yaml:"ingest"`
 }
 
+// DM_SENDER_KEY_ENV keeps the key sealing sender cookies out of config files, the key is a base64
+// (url encoding) 32 bytes X25519 private key, e.g. head -c 32 /dev/urandom | base64 | tr '+/' '-_'
+const DM_SENDER_KEY_ENV = "OCTANT_DM_SENDER_KEY"
+
 func (c Config) IsMySQL() bool  { return c.DbEngine == "mysql" }
 func (c Config) IsSQLite() bool { return c.DbEngine == "sqlite" }
+
+// ShipsToCore tells if records collected are shipped to core, db is not used then
+func (c Config) ShipsToCore() bool {
+	return c.Service.Collector && !c.Service.Core && len(c.Ingest.CoreURL) > 0
+}
 func (c Config) LogLevel() swe.LogLevel {
 	switch c.Log.Level {
 	case "debug":
@@ -46,6 +97,22 @@ func (c Config) LogLevel() swe.LogLevel {
 	}
 	return swe.LOG_INFO
 }
+func (c Config) Location() *time.Location {
+	if len(c.Timezone) == 0 {
+		return nil
+	}
+	loc, _ := time.LoadLocation(c.Timezone)
+	return loc
+}
+func (c Config) AllowedNetworks() []*net.IPNet {
+	ret := []*net.IPNet{}
+	for _, item := range c.Webhook.AllowNetworks {
+		if _, ipNet, err := net.ParseCIDR(item); err == nil {
+			ret = append(ret, ipNet)
+		}
+	}
+	return ret
+}
 func (c Config) WebAddr() string {
 	if c.LocalHost {
 		return "localhost:" + fmt.Sprint(c.Port)
@@ -81,20 +148,76 @@ func LoadConfig(file string) error {
 	}
 
 	gConfig.DbEngine = strings.ToLower(gConfig.DbEngine)
+	if value := os.Getenv(DM_SENDER_KEY_ENV); len(value) > 0 {
+		gConfig.DMSenderKey = value
+	}
 
 	if gConfig.Port == 0 {
 		return fmt.Errorf("invalid port %d", gConfig.Port)
 	}
 
-	if gConfig.DbEngine != "sqlite" && gConfig.DbEngine != "mysql" {
-		return fmt.Errorf("invalid db engine: %s", gConfig.DbEngine)
-	}
-
 	if !(gConfig.Service.Core || gConfig.Service.Collector) {
 		return fmt.Errorf("no service")
 	}
 
+	if len(gConfig.Ingest.CoreURL) > 0 {
+		if gConfig.Service.Core || !gConfig.Service.Collector {
+			return fmt.Errorf("ingest core_url is for collector only instances")
+		}
+		if u, err := url.Parse(gConfig.Ingest.CoreURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid ingest core_url %s", gConfig.Ingest.CoreURL)
+		}
+		if len(gConfig.Ingest.Secret) == 0 {
+			return fmt.Errorf("no secret to ship records to core")
+		}
+	}
+	if gConfig.Ingest.BatchSize < 0 || gConfig.Ingest.BufferSize < 0 {
+		return fmt.Errorf("invalid ingest batch or buffer size")
+	}
+
+	if gConfig.DbEngine != "sqlite" && gConfig.DbEngine != "mysql" && !gConfig.ShipsToCore() {
+		return fmt.Errorf("invalid db engine: %s", gConfig.DbEngine)
+	}
+
 	gConfig.Log.Level = strings.ToLower(gConfig.Log.Level)
 
+	if gConfig.AsyncTask.Workers < 0 {
+		return fmt.Errorf("invalid async task workers %d", gConfig.AsyncTask.Workers)
+	}
+	for name, limit := range gConfig.AsyncTask.Limits {
+		if limit < 0 {
+			return fmt.Errorf("invalid concurrency limit %d for async task %s", limit, name)
+		}
+	}
+
+	names := map[string]bool{"bilibili": true}
+	for _, item := range gConfig.DMTransports {
+		if len(item.Name) == 0 || names[item.Name] {
+			return fmt.Errorf("invalid or duplicated dm transport name '%s'", item.Name)
+		}
+		names[item.Name] = true
+		switch item.Type {
+		case "mock":
+		case "webhook":
+			if len(item.URL) == 0 {
+				return fmt.Errorf("no url for dm transport %s", item.Name)
+			}
+		default:
+			return fmt.Errorf("invalid type %s of dm transport %s", item.Type, item.Name)
+		}
+	}
+
+	for _, item := range gConfig.Webhook.AllowNetworks {
+		if _, _, err := net.ParseCIDR(item); err != nil {
+			return fmt.Errorf("invalid webhook allowed network %s: %v", item, err)
+		}
+	}
+
+	if len(gConfig.Timezone) > 0 {
+		if _, err := time.LoadLocation(gConfig.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone %s: %v", gConfig.Timezone, err)
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,196 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zerozwt/octant/server/utils"
+	"github.com/zerozwt/swe"
+)
+
+// guard prices in CNY per month, guard records only keep level & months
+const (
+	GUARD_PRICE_ZONGDU    = 19998
+	GUARD_PRICE_TIDU      = 1998
+	GUARD_PRICE_JIANZHANG = 198
+
+	// buckets are joined as a derived table, keep the statement reasonably small
+	MAX_ANALYTICS_BUCKETS = 400
+)
+
+// guardValueExpr calculates value of guard records, prefix is the table alias with dot or empty
+func guardValueExpr(prefix string) string {
+	return fmt.Sprintf("%scount * (case %slevel when 1 then %d when 2 then %d when 3 then %d else 0 end)",
+		prefix, prefix, GUARD_PRICE_ZONGDU, GUARD_PRICE_TIDU, GUARD_PRICE_JIANZHANG)
+}
+
+// payRecordsSQL lists all paid records of a room in [tsBegin, tsEnd) as (uid, ts, gift, sc, guard),
+// gift is in gold coins, sc and guard in CNY. Takes args roomID, tsBegin, tsEnd 3 times.
+var payRecordsSQL string = "select sender_uid as uid, send_time as ts, gift_price*gift_count as gift, 0 as sc, 0 as guard " +
+	"from t_gift where room_id = ? and send_time >= ? and send_time < ? union all " +
+	"select sender_uid as uid, send_time as ts, 0 as gift, price as sc, 0 as guard " +
+	"from t_super_chat where room_id = ? and send_time >= ? and send_time < ? union all " +
+	"select sender_uid as uid, send_time as ts, 0 as gift, 0 as sc, " + guardValueExpr("") + " as guard " +
+	"from t_member where room_id = ? and send_time >= ? and send_time < ?"
+
+type RoomBucketStat struct {
+	Bucket     int `gorm:"column:idx"`
+	RoomRollup `gorm:"embedded"`
+}
+
+type GiftBucketStat struct {
+	Bucket   int    `gorm:"column:idx"`
+	GiftID   int64  `gorm:"column:gift_id"`
+	GiftName string `gorm:"column:gift_name"`
+	Number   int64  `gorm:"column:num"`
+	Value    int64  `gorm:"column:val"`
+}
+
+type PayerBucketStat struct {
+	Bucket int   `gorm:"column:idx"`
+	Payers int64 `gorm:"column:payers"`
+}
+
+type PayerStat struct {
+	UID   int64 `gorm:"column:uid"`
+	Gift  int64 `gorm:"column:gift"`
+	SC    int64 `gorm:"column:sc"`
+	Guard int64 `gorm:"column:guard"`
+}
+
+type HourStat struct {
+	Hour  int64 `gorm:"column:ts"`
+	Count int64 `gorm:"column:ct"`
+	Gift  int64 `gorm:"column:gift"`
+	SC    int64 `gorm:"column:sc"`
+	Guard int64 `gorm:"column:guard"`
+}
+
+// AnalyticsDAL reads from rollups, see RollupDAL
+type AnalyticsDAL struct{}
+
+func GetAnalyticsDAL() AnalyticsDAL { return AnalyticsDAL{} }
+
+// bucketTable renders time buckets as a derived table (idx, ts_begin, ts_end) for rollups to join with,
+// it works the same on sqlite and mysql and leaves timezone & calendar handling to the caller
+func bucketTable(buckets [][2]int64) (string, []any) {
+	parts := make([]string, 0, len(buckets))
+	args := make([]any, 0, len(buckets)*2)
+	for idx, item := range buckets {
+		if idx == 0 {
+			parts = append(parts, "select 0 as idx, ? as ts_begin, ? as ts_end")
+		} else {
+			parts = append(parts, fmt.Sprintf("select %d, ?, ?", idx))
+		}
+		args = append(args, item[0], item[1])
+	}
+	return "(" + strings.Join(parts, " union all ") + ") as b", args
+}
+
+// rollupBuckets picks day rollups if all buckets consist of whole days, or hour rollups otherwise,
+// in which case the buckets are snapped to whole hours: the first bucket is extended to the beginning
+// of its hour, and an hour a bucket ends in is counted as a whole.
+// Returns the derived bucket table and its args followed by the unit.
+func rollupBuckets(buckets [][2]int64) (string, []any, error) {
+	if len(buckets) == 0 || len(buckets) > MAX_ANALYTICS_BUCKETS {
+		return "", nil, fmt.Errorf("invalid bucket count %d", len(buckets))
+	}
+
+	unit := ROLLUP_DAY
+	for _, item := range buckets {
+		if utils.DayStart(item[0]) != item[0] || utils.DayStart(item[1]) != item[1] {
+			unit = ROLLUP_HOUR
+			break
+		}
+	}
+	if unit == ROLLUP_HOUR {
+		buckets = append([][2]int64{{utils.HourStart(buckets[0][0]), buckets[0][1]}}, buckets[1:]...)
+	}
+
+	table, args := bucketTable(buckets)
+	return table, append(args, unit), nil
+}
+
+// rollupRange is rollupBuckets for a single time range, snapped to whole hours the same way
+func rollupRange(tsBegin, tsEnd int64) (int, int64, int64) {
+	if utils.DayStart(tsBegin) == tsBegin && utils.DayStart(tsEnd) == tsEnd {
+		return ROLLUP_DAY, tsBegin, tsEnd
+	}
+	return ROLLUP_HOUR, utils.HourStart(tsBegin), tsEnd
+}
+
+// RoomByBucket sums room rollups in each bucket
+func (dal AnalyticsDAL) RoomByBucket(ctx *swe.Context, roomID int64, buckets [][2]int64) ([]RoomBucketStat, error) {
+	table, args, err := rollupBuckets(buckets)
+	if err != nil {
+		return nil, err
+	}
+
+	sql := "select b.idx as idx, sum(r.gift_number) as gift_number, sum(r.gift_value) as gift_value, " +
+		"sum(r.sc_count) as sc_count, sum(r.sc_value) as sc_value, " +
+		"sum(r.g1_count) as g1_count, sum(r.g1_months) as g1_months, sum(r.g2_count) as g2_count, " +
+		"sum(r.g2_months) as g2_months, sum(r.g3_count) as g3_count, sum(r.g3_months) as g3_months " +
+		"from t_rollup_room as r join " + table + " on r.ts >= b.ts_begin and r.ts < b.ts_end " +
+		"where r.unit = ? and r.room_id = ? group by b.idx order by b.idx"
+	args = append(args, roomID)
+
+	ret := []RoomBucketStat{}
+	err = getInstance(ctx).Raw(sql, args...).Scan(&ret).Error
+	return ret, err
+}
+
+// GiftByBucket sums gifts of each kind in each bucket, value is in gold coins
+func (dal AnalyticsDAL) GiftByBucket(ctx *swe.Context, roomID int64, buckets [][2]int64) ([]GiftBucketStat, error) {
+	table, args, err := rollupBuckets(buckets)
+	if err != nil {
+		return nil, err
+	}
+
+	sql := "select b.idx as idx, r.gift_id as gift_id, max(i.gift_name) as gift_name, " +
+		"sum(r.number) as num, sum(r.value) as val from t_rollup_gift as r join " + table +
+		" on r.ts >= b.ts_begin and r.ts < b.ts_end left join t_gift_info as i on r.gift_id = i.gift_id " +
+		"where r.unit = ? and r.room_id = ? group by b.idx, r.gift_id order by b.idx, val desc"
+	args = append(args, roomID)
+
+	ret := []GiftBucketStat{}
+	err = getInstance(ctx).Raw(sql, args...).Scan(&ret).Error
+	return ret, err
+}
+
+// PayersByBucket counts distinct paying users in each bucket across gifts, super chats and guards
+func (dal AnalyticsDAL) PayersByBucket(ctx *swe.Context, roomID int64, buckets [][2]int64) ([]PayerBucketStat, error) {
+	table, args, err := rollupBuckets(buckets)
+	if err != nil {
+		return nil, err
+	}
+
+	sql := "select b.idx as idx, count(distinct r.uid) as payers from t_rollup_payer as r join " + table +
+		" on r.ts >= b.ts_begin and r.ts < b.ts_end where r.unit = ? and r.room_id = ? group by b.idx order by b.idx"
+	args = append(args, roomID)
+
+	ret := []PayerBucketStat{}
+	err = getInstance(ctx).Raw(sql, args...).Scan(&ret).Error
+	return ret, err
+}
+
+// TopPayers returns users paid most in [tsBegin, tsEnd), ranked by total value in CNY
+func (dal AnalyticsDAL) TopPayers(ctx *swe.Context, roomID, tsBegin, tsEnd int64, limit int) ([]PayerStat, error) {
+	unit, tsBegin, tsEnd := rollupRange(tsBegin, tsEnd)
+	sql := "select uid, sum(gift_value) as gift, sum(sc_value) as sc, sum(guard_value) as guard from t_rollup_payer " +
+		"where room_id = ? and unit = ? and ts >= ? and ts < ? " +
+		"group by uid order by sum(gift_value) + (sum(sc_value) + sum(guard_value)) * 1000 desc, uid limit ?"
+
+	ret := []PayerStat{}
+	err := getInstance(ctx).Raw(sql, roomID, unit, tsBegin, tsEnd, limit).Scan(&ret).Error
+	return ret, err
+}
+
+// Hours returns hour rollups of [tsBegin, tsEnd), it is left for the caller to map them into hour of day
+func (dal AnalyticsDAL) Hours(ctx *swe.Context, roomID, tsBegin, tsEnd int64) ([]HourStat, error) {
+	sql := "select ts, sum(ct) as ct, sum(gift_value) as gift, sum(sc_value) as sc, sum(guard_value) as guard " +
+		"from t_rollup_payer where room_id = ? and unit = ? and ts >= ? and ts < ? group by ts"
+
+	ret := []HourStat{}
+	err := getInstance(ctx).Raw(sql, roomID, ROLLUP_HOUR, utils.HourStart(tsBegin), tsEnd).Scan(&ret).Error
+	return ret, err
+}
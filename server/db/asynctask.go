@@ -1,25 +1,40 @@
 package db
 
 import (
+	"strings"
+
 	"github.com/zerozwt/swe"
 	"gorm.io/gorm/clause"
 )
 
 type AsyncTask struct {
-	ID       int64  `gorm:"primaryKey;column:id"`
-	Handler  string `gorm:"type:string;size:128;column:handler"`
-	Param    string `gorm:"type:string;size:4096;column:param"`
-	Status   int    `gorm:"column:status;index:idx_ast_status"`
-	Schedule int64  `gorm:"column:schedule"`
+	ID         int64  `gorm:"primaryKey;column:id"`
+	Handler    string `gorm:"type:string;size:128;column:handler"`
+	Param      string `gorm:"type:string;size:4096;column:param"`
+	Status     int    `gorm:"column:status;index:idx_ast_status"`
+	Schedule   int64  `gorm:"column:schedule"`
+	Priority   int    `gorm:"column:priority"`
+	Attempts   int    `gorm:"column:attempts"`
+	LastError  string `gorm:"type:string;size:1024;column:last_error"`
+	CreateTime int64  `gorm:"column:create_time"`
+	UpdateTime int64  `gorm:"column:update_time"`
+
+	// running tasks are leased to one core instance, others may take over after the lease expires
+	Owner       string `gorm:"type:string;size:128;column:owner"`
+	LeaseExpire int64  `gorm:"column:lease_expire"`
 }
 
 const (
 	ASYNC_TASK_IDLE = iota + 1
 	ASYNC_TASK_RUNNING
-	ASYNC_TASK_FAILED
+	ASYNC_TASK_FAILED // last attempt failed, waiting for retry
 	ASYNC_TASK_DONE
+	ASYNC_TASK_DEAD // all attempts failed, only re-run when requeued manually
+	ASYNC_TASK_CANCELED
 )
 
+const maxTaskErrorLen = 1024
+
 func (s AsyncTask) TableName() string { return "t_async_task" }
 
 func init() {
@@ -30,9 +45,10 @@ type AsyncTaskDAL struct{}
 
 func GetAsyncTaskDAL() AsyncTaskDAL { return AsyncTaskDAL{} }
 
+// All loads tasks still to be run
 func (dal AsyncTaskDAL) All(ctx *swe.Context) ([]*AsyncTask, error) {
 	tmp := []AsyncTask{}
-	tx := getInstance(ctx).Where("status in ?", []int{ASYNC_TASK_IDLE, ASYNC_TASK_RUNNING})
+	tx := getInstance(ctx).Where("status in ?", []int{ASYNC_TASK_IDLE, ASYNC_TASK_RUNNING, ASYNC_TASK_FAILED})
 	err := tx.Find(&tmp).Error
 	ret := make([]*AsyncTask, 0, len(tmp))
 	for idx := range tmp {
@@ -42,12 +58,81 @@ func (dal AsyncTaskDAL) All(ctx *swe.Context) ([]*AsyncTask, error) {
 }
 
 func (dal AsyncTaskDAL) Put(ctx *swe.Context, value *AsyncTask) error {
+	if len(value.LastError) > maxTaskErrorLen {
+		value.LastError = strings.ToValidUTF8(value.LastError[:maxTaskErrorLen], "")
+	}
 	return getInstance(ctx).Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "id"}},
-		DoUpdates: clause.AssignmentColumns([]string{"status", "schedule"}),
+		DoUpdates: clause.AssignmentColumns([]string{"status", "schedule", "attempts", "last_error", "update_time"}),
 	}).Create(value).Error
 }
 
+// Due lists tasks to be run at ts, including those leased by instances not renewing any more
+func (dal AsyncTaskDAL) Due(ctx *swe.Context, ts int64, limit int) ([]*AsyncTask, error) {
+	tmp := []AsyncTask{}
+	tx := getInstance(ctx).Where("(status in ? and schedule <= ?) or (status = ? and lease_expire < ?)",
+		[]int{ASYNC_TASK_IDLE, ASYNC_TASK_FAILED}, ts, ASYNC_TASK_RUNNING, ts)
+	err := tx.Order("priority desc, schedule").Limit(limit).Find(&tmp).Error
+	ret := make([]*AsyncTask, 0, len(tmp))
+	for idx := range tmp {
+		ret = append(ret, &tmp[idx])
+	}
+	return ret, err
+}
+
+// Claim atomically takes a due task for owner until expire, counting an attempt. Returns false if
+// the task is not due any more, or is running with a valid lease, most likely on another instance.
+func (dal AsyncTaskDAL) Claim(ctx *swe.Context, id int64, owner string, ts, expire int64) (bool, error) {
+	result := getInstance(ctx).Exec("update t_async_task set status = ?, owner = ?, lease_expire = ?, "+
+		"attempts = attempts + 1, update_time = ? where id = ? and "+
+		"((status in ? and schedule <= ?) or (status = ? and lease_expire < ?))",
+		ASYNC_TASK_RUNNING, owner, expire, ts, id,
+		[]int{ASYNC_TASK_IDLE, ASYNC_TASK_FAILED}, ts, ASYNC_TASK_RUNNING, ts)
+	return result.RowsAffected > 0, result.Error
+}
+
+// Renew extends the lease, returns false if the lease is lost
+func (dal AsyncTaskDAL) Renew(ctx *swe.Context, id int64, owner string, expire int64) (bool, error) {
+	result := getInstance(ctx).Exec("update t_async_task set lease_expire = ? where id = ? and status = ? and owner = ?",
+		expire, id, ASYNC_TASK_RUNNING, owner)
+	return result.RowsAffected > 0, result.Error
+}
+
+// Release writes result of a run and gives up the lease, nothing is written if the lease is lost
+func (dal AsyncTaskDAL) Release(ctx *swe.Context, value *AsyncTask, owner string) (bool, error) {
+	if len(value.LastError) > maxTaskErrorLen {
+		value.LastError = strings.ToValidUTF8(value.LastError[:maxTaskErrorLen], "")
+	}
+	value.Owner, value.LeaseExpire = "", 0
+	result := getInstance(ctx).Exec("update t_async_task set status = ?, schedule = ?, attempts = ?, last_error = ?, "+
+		"update_time = ?, owner = '', lease_expire = 0 where id = ? and status = ? and owner = ?",
+		value.Status, value.Schedule, value.Attempts, value.LastError, value.UpdateTime,
+		value.ID, ASYNC_TASK_RUNNING, owner)
+	return result.RowsAffected > 0, result.Error
+}
+
+// Cancel stops a task if it is still waiting to run
+func (dal AsyncTaskDAL) Cancel(ctx *swe.Context, id, ts int64) (bool, error) {
+	result := getInstance(ctx).Exec("update t_async_task set status = ?, update_time = ? where id = ? and status in ?",
+		ASYNC_TASK_CANCELED, ts, id, []int{ASYNC_TASK_IDLE, ASYNC_TASK_FAILED})
+	return result.RowsAffected > 0, result.Error
+}
+
+// Requeue schedules a dead or canceled task at ts from its first attempt
+func (dal AsyncTaskDAL) Requeue(ctx *swe.Context, id, ts int64) (bool, error) {
+	result := getInstance(ctx).Exec("update t_async_task set status = ?, schedule = ?, attempts = 0, update_time = ? "+
+		"where id = ? and status in ?", ASYNC_TASK_IDLE, ts, ts, id, []int{ASYNC_TASK_DEAD, ASYNC_TASK_CANCELED})
+	return result.RowsAffected > 0, result.Error
+}
+
+// Unfinished tells if a task of handler with param is waiting, running or waiting for retry
+func (dal AsyncTaskDAL) Unfinished(ctx *swe.Context, handler, param string) (bool, error) {
+	count := 0
+	err := getInstance(ctx).Table("t_async_task").Where("handler = ? and param = ? and status in ?", handler, param,
+		[]int{ASYNC_TASK_IDLE, ASYNC_TASK_RUNNING, ASYNC_TASK_FAILED}).Select("count(*)").Scan(&count).Error
+	return count > 0, err
+}
+
 func (dal AsyncTaskDAL) Get(ctx *swe.Context, id int64) (*AsyncTask, error) {
 	ret := []AsyncTask{}
 	err := getInstance(ctx).Where("id = ?", id).Find(&ret).Error
@@ -56,3 +141,24 @@ func (dal AsyncTaskDAL) Get(ctx *swe.Context, id int64) (*AsyncTask, error) {
 	}
 	return &ret[0], nil
 }
+
+// Page lists tasks newest first, empty handler or zero status matches all
+func (dal AsyncTaskDAL) Page(ctx *swe.Context, handler string, status int, offset, limit int) (int, []AsyncTask, error) {
+	ret := []AsyncTask{}
+	count := 0
+
+	tx := getInstance(ctx).Table("t_async_task")
+	if len(handler) > 0 {
+		tx = tx.Where("handler = ?", handler)
+	}
+	if status > 0 {
+		tx = tx.Where("status = ?", status)
+	}
+	err := newDBSession(ctx, tx).Select("count(*)").Scan(&count).Error
+	if err != nil {
+		return 0, nil, err
+	}
+
+	err = tx.Offset(offset).Limit(limit).Order("create_time desc, id desc").Find(&ret).Error
+	return count, ret, err
+}
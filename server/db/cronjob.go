@@ -0,0 +1,100 @@
+package db
+
+import (
+	"github.com/zerozwt/swe"
+	"gorm.io/gorm/clause"
+)
+
+// CronJob is a recurring job, each run is an async task created for its handler
+type CronJob struct {
+	Name       string `gorm:"primaryKey;type:string;size:128;column:name"`
+	Spec       string `gorm:"type:string;size:128;column:spec"`
+	Handler    string `gorm:"type:string;size:128;column:handler"`
+	Param      string `gorm:"type:string;size:4096;column:param"`
+	Catchup    int    `gorm:"column:catchup"`
+	Overlap    int    `gorm:"column:overlap"`
+	Enabled    int    `gorm:"column:enabled"`
+	NextRun    int64  `gorm:"column:next_run"`
+	LastRun    int64  `gorm:"column:last_run"`
+	LastTaskID int64  `gorm:"column:last_task_id"`
+	UpdateTime int64  `gorm:"column:update_time"`
+}
+
+// what to do with runs missed while no core instance is running
+const (
+	CRON_CATCHUP_SKIP = iota + 1
+	CRON_CATCHUP_ONCE
+	CRON_CATCHUP_ALL
+)
+
+// what to do if the task of the last run is not finished yet
+const (
+	CRON_OVERLAP_SKIP = iota + 1
+	CRON_OVERLAP_ALLOW
+)
+
+func (s CronJob) TableName() string { return "t_cron_job" }
+
+func init() {
+	registerModel(&CronJob{})
+}
+
+type CronJobDAL struct{}
+
+func GetCronJobDAL() CronJobDAL { return CronJobDAL{} }
+
+func (dal CronJobDAL) All(ctx *swe.Context) ([]CronJob, error) {
+	ret := []CronJob{}
+	err := getInstance(ctx).Order("name").Find(&ret).Error
+	return ret, err
+}
+
+func (dal CronJobDAL) Get(ctx *swe.Context, name string) (*CronJob, error) {
+	ret := []CronJob{}
+	err := getInstance(ctx).Where("name = ?", name).Find(&ret).Error
+	if len(ret) == 0 {
+		return nil, err
+	}
+	return &ret[0], nil
+}
+
+// Define creates the job or updates its definition, schedule state is kept unless nextRun > 0
+func (dal CronJobDAL) Define(ctx *swe.Context, job *CronJob, nextRun int64) error {
+	columns := []string{"spec", "handler", "param", "catchup", "overlap", "update_time"}
+	if nextRun > 0 {
+		columns = append(columns, "next_run")
+	}
+	job.NextRun = nextRun
+	return getInstance(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns(columns),
+	}).Create(job).Error
+}
+
+func (dal CronJobDAL) Due(ctx *swe.Context, ts int64) ([]CronJob, error) {
+	ret := []CronJob{}
+	err := getInstance(ctx).Where("enabled = 1 and next_run <= ?", ts).Find(&ret).Error
+	return ret, err
+}
+
+// Advance moves the job from run at oldNext to nextRun, only one instance wins if several try at once
+func (dal CronJobDAL) Advance(ctx *swe.Context, name string, oldNext, nextRun, ts int64) (bool, error) {
+	result := getInstance(ctx).Exec("update t_cron_job set next_run = ?, last_run = ?, update_time = ? "+
+		"where name = ? and next_run = ?", nextRun, ts, ts, name, oldNext)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (dal CronJobDAL) SetLastTask(ctx *swe.Context, name string, taskID int64) error {
+	return getInstance(ctx).Exec("update t_cron_job set last_task_id = ? where name = ?", taskID, name).Error
+}
+
+// SetEnabled turns the job on or off, runs missed while disabled are skipped by nextRun
+func (dal CronJobDAL) SetEnabled(ctx *swe.Context, name string, enabled bool, nextRun, ts int64) (bool, error) {
+	value := 0
+	if enabled {
+		value = 1
+	}
+	result := getInstance(ctx).Exec("update t_cron_job set enabled = ?, next_run = ?, update_time = ? where name = ?",
+		value, nextRun, ts, name)
+	return result.RowsAffected > 0, result.Error
+}
@@ -40,8 +40,16 @@ var ddDal DDInfoDAL
 
 func GetDDInfoDAL() *DDInfoDAL { return &ddDal }
 
+// BatchCreate creates dd accounts, user names of existing accounts are refreshed
 func (dal DDInfoDAL) BatchCreate(ctx *swe.Context, dd []DDInfo) error {
-	return getInstance(ctx).Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(dd, 500).Error
+	return getInstance(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "uid"}},
+		DoUpdates: clause.AssignmentColumns([]string{"user_name"}),
+	}).CreateInBatches(dd, 500).Error
+}
+
+func (dal *DDInfoDAL) UpdateName(ctx *swe.Context, uid int64, name string) error {
+	return getInstance(ctx).Exec("update t_dd set user_name = ? where uid = ? and user_name <> ?", name, uid, name).Error
 }
 
 func (dal *DDInfoDAL) GenerateAccessCode(ts, eventID, uid int64) string {
@@ -5,20 +5,29 @@ import (
 	"time"
 
 	"github.com/zerozwt/swe"
+	"gorm.io/gorm"
 )
 
 type DMTask struct {
-	ID          int64  `gorm:"primaryKey;column:id"`
-	RoomID      int64  `gorm:"column:room_id;index:idx_dmtask_room"`
-	EventID     int64  `gorm:"column:event_id"`
-	TaskName    string `gorm:"column:task_name;type:string;size:256"`
-	MsgType     int    `gorm:"column:msg_type"`
-	Content     string `gorm:"column:content;type:string;size:4096"`
-	BatchMax    int    `gorm:"column:batch_max"`
-	Status      int    `gorm:"column:status"`
-	IntervalMin int    `gorm:"column:interval_min"`
-	IntervalMax int    `gorm:"column:interval_max"`
-	CreateTime  int64  `gorm:"create_time"`
+	ID           int64  `gorm:"primaryKey;column:id"`
+	RoomID       int64  `gorm:"column:room_id;index:idx_dmtask_room"`
+	EventID      int64  `gorm:"column:event_id"`
+	TaskName     string `gorm:"column:task_name;type:string;size:256"`
+	MsgType      int    `gorm:"column:msg_type"`
+	Content      string `gorm:"column:content;type:string;size:4096"`
+	BatchMax     int    `gorm:"column:batch_max"`
+	Status       int    `gorm:"column:status"`
+	IntervalMin  int    `gorm:"column:interval_min"`
+	IntervalMax  int    `gorm:"column:interval_max"`
+	SendMode     int    `gorm:"column:send_mode"`
+	RetryTaskID  int64  `gorm:"column:retry_task_id"`
+	SenderPolicy int    `gorm:"column:sender_policy"`
+	StartTime    int64  `gorm:"column:start_time"`
+	WindowStart  int    `gorm:"column:window_start"`
+	WindowEnd    int    `gorm:"column:window_end"`
+	Transport    string `gorm:"column:transport;type:string;size:64"`
+	ParentID     int64  `gorm:"column:parent_id"` // the task this one follows up
+	CreateTime   int64  `gorm:"create_time"`
 }
 
 func (s DMTask) TableName() string { return "t_dm_task" }
@@ -27,6 +36,21 @@ const (
 	DM_TASK_STATUS_PAUSED = iota + 1
 	DM_TASK_STATUS_RUNNING
 	DM_TASK_STATUS_DONE
+	DM_TASK_STATUS_WAITING // for its start time or sending window
+)
+
+// which account a task sends with
+const (
+	DM_POLICY_SINGLE      = iota // the sender set on the task
+	DM_POLICY_ROUND_ROBIN        // accounts in the pool of the streamer in turn
+	DM_POLICY_LRU                // the account in the pool used least recently
+)
+
+// which details a run of task sends to
+const (
+	DM_SEND_UNSENT = iota + 1
+	DM_SEND_FAILED // only transient failures not retried too many times
+	DM_SEND_ALL
 )
 
 type DMDetail struct {
@@ -36,14 +60,18 @@ type DMDetail struct {
 	Status      int    `gorm:"column:status"`
 	SendTime    int64  `gorm:"column:send_ts"`
 	FailReason  string `gorm:"column:fail_reason;type:string;size:1024"`
+	Attempts    int    `gorm:"column:attempts"`
+	FailKind    int    `gorm:"column:fail_kind"`
+	NextRetry   int64  `gorm:"column:next_retry"`
 }
 
 func (s DMDetail) TableName() string { return "t_dm_detail" }
 
 type DMTaskStat struct {
-	NotSend int
-	Fail    int
-	Done    int
+	NotSend   int
+	Fail      int
+	Done      int
+	Retryable int // failures to be retried, included in Fail
 }
 
 const (
@@ -52,6 +80,14 @@ const (
 	DM_DETAIL_STATUS_DONE
 )
 
+const (
+	DM_FAIL_TRANSIENT = iota + 1 // e.g. rate limited, worth retrying later
+	DM_FAIL_PERMANENT            // e.g. the user does not accept messages
+)
+
+// details failed this many times are not retried any more
+const DM_DETAIL_MAX_ATTEMPTS = 5
+
 func init() {
 	registerModel(&DMTask{})
 	registerModel(&DMDetail{})
@@ -116,6 +152,10 @@ func (dal DirectMsgDAL) Stats(ctx *swe.Context, id int64) (DMTaskStat, error) {
 	tx = tx.Group("status")
 	err := tx.Find(&tmp).Error
 
+	if err != nil {
+		return ret, err
+	}
+
 	for _, item := range tmp {
 		switch item.Status {
 		case DM_DETAIL_STATUS_NOT_SEND:
@@ -127,6 +167,52 @@ func (dal DirectMsgDAL) Stats(ctx *swe.Context, id int64) (DMTaskStat, error) {
 		}
 	}
 
+	tx = getInstance(ctx).Table((DMDetail{}).TableName()).Select("count(*)")
+	tx = tx.Where("task_id = ? and status = ? and fail_kind = ? and attempts < ?",
+		id, DM_DETAIL_STATUS_FAIL, DM_FAIL_TRANSIENT, DM_DETAIL_MAX_ATTEMPTS)
+	err = tx.Scan(&ret.Retryable).Error
+
+	return ret, err
+}
+
+// PageDetails lists details of task by uid, status 0 for all and limit 0 for no limit
+func (dal DirectMsgDAL) PageDetails(ctx *swe.Context, id int64, status, offset, limit int) (int, []DMDetail, error) {
+	count := 0
+	ret := []DMDetail{}
+	tx := getInstance(ctx).Table((DMDetail{}).TableName()).Where("task_id = ?", id)
+	if status > 0 {
+		tx = tx.Where("status = ?", status)
+	}
+
+	err := newDBSession(ctx, tx).Select("count(*)").Scan(&count).Error
+	if err != nil {
+		return 0, nil, err
+	}
+
+	tx = tx.Order("uid")
+	if limit > 0 {
+		tx = tx.Offset(offset).Limit(limit)
+	}
+	err = tx.Find(&ret).Error
+
+	return count, ret, err
+}
+
+// DetailsByStatus loads all details of task in any of statuses, details failed permanently are left out
+// unless permanent is true
+func (dal DirectMsgDAL) DetailsByStatus(ctx *swe.Context, id int64, statuses []int, permanent bool) ([]DMDetail, error) {
+	ret := []DMDetail{}
+	tx := getInstance(ctx).Where("task_id = ? and status in ?", id, statuses)
+	if !permanent {
+		tx = tx.Where("not (status = ? and fail_kind = ?)", DM_DETAIL_STATUS_FAIL, DM_FAIL_PERMANENT)
+	}
+	err := tx.Order("uid").Find(&ret).Error
+	return ret, err
+}
+
+func (dal DirectMsgDAL) ListByStatus(ctx *swe.Context, status int) ([]DMTask, error) {
+	ret := []DMTask{}
+	err := getInstance(ctx).Where("status = ?", status).Find(&ret).Error
 	return ret, err
 }
 
@@ -134,11 +220,23 @@ func (dal DirectMsgDAL) UpdateStatus(ctx *swe.Context, id int64, status int) err
 	return getInstance(ctx).Exec("update t_dm_task set status = ? where id = ?", status, id).Error
 }
 
-func (dal DirectMsgDAL) LoadUnsentDetails(ctx *swe.Context, id int64, limit int) ([]DMDetail, error) {
+// LoadDetails loads details to send in mode, failed ones are loaded only after their next retry time
+func (dal DirectMsgDAL) LoadDetails(ctx *swe.Context, id int64, mode int, ts int64, limit int) ([]DMDetail, error) {
 	ret := []DMDetail{}
 
+	unsent := getInstance(ctx).Where("status = ?", DM_DETAIL_STATUS_NOT_SEND)
+	failed := getInstance(ctx).Where("status = ? and fail_kind = ? and attempts < ? and next_retry <= ?",
+		DM_DETAIL_STATUS_FAIL, DM_FAIL_TRANSIENT, DM_DETAIL_MAX_ATTEMPTS, ts)
+
 	tx := getInstance(ctx).Where("task_id = ?", id)
-	tx = tx.Where("status <> ?", DM_DETAIL_STATUS_NOT_SEND)
+	switch mode {
+	case DM_SEND_FAILED:
+		tx = tx.Where(failed)
+	case DM_SEND_ALL:
+		tx = tx.Where(unsent.Or(failed))
+	default:
+		tx = tx.Where(unsent)
+	}
 	tx = tx.Order("uid")
 	if limit > 0 {
 		tx = tx.Limit(limit)
@@ -149,9 +247,79 @@ func (dal DirectMsgDAL) LoadUnsentDetails(ctx *swe.Context, id int64, limit int)
 	return ret, err
 }
 
+// NextRetry returns the earliest time a transient failure of the task can be retried, 0 if none
+func (dal DirectMsgDAL) NextRetry(ctx *swe.Context, id int64) (int64, error) {
+	ret := int64(0)
+	err := getInstance(ctx).Table((DMDetail{}).TableName()).Select("coalesce(min(next_retry), 0)").
+		Where("task_id = ? and status = ? and fail_kind = ? and attempts < ?",
+			id, DM_DETAIL_STATUS_FAIL, DM_FAIL_TRANSIENT, DM_DETAIL_MAX_ATTEMPTS).Scan(&ret).Error
+	return ret, err
+}
+
+func (dal DirectMsgDAL) SetSendMode(ctx *swe.Context, id int64, mode int) error {
+	return getInstance(ctx).Exec("update t_dm_task set send_mode = ? where id = ?", mode, id).Error
+}
+
+// SetSchedule changes when task sends, window is minutes of day as in utils.DayWindow
+func (dal DirectMsgDAL) SetSchedule(ctx *swe.Context, id int64, startTime int64, windowStart, windowEnd int) error {
+	return getInstance(ctx).Exec("update t_dm_task set start_time = ?, window_start = ?, window_end = ? where id = ?",
+		startTime, windowStart, windowEnd, id).Error
+}
+
+func (dal DirectMsgDAL) SetSenderPolicy(ctx *swe.Context, id int64, policy int) error {
+	return getInstance(ctx).Exec("update t_dm_task set sender_policy = ? where id = ?", policy, id).Error
+}
+
+// SetRetryTask records the async task starting task again later, for retries or its sending
+// window, 0 cancels the pending run
+func (dal DirectMsgDAL) SetRetryTask(ctx *swe.Context, id, taskID int64) error {
+	return getInstance(ctx).Exec("update t_dm_task set retry_task_id = ? where id = ?", taskID, id).Error
+}
+
+// CreateFollowUp saves task following up task.ParentID with its details, and clears the retry of the
+// parent in the same transaction, so that both never send to the same recipients. Returns false and
+// saves nothing if the parent is running or waiting.
+func (dal DirectMsgDAL) CreateFollowUp(ctx *swe.Context, task *DMTask, details []DMDetail) (bool, error) {
+	ok := false
+	err := getInstance(ctx).Transaction(func(tx *gorm.DB) error {
+		busy := []int{DM_TASK_STATUS_RUNNING, DM_TASK_STATUS_WAITING}
+		result := tx.Exec("update t_dm_task set retry_task_id = 0 where id = ? and status not in ?", task.ParentID, busy)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// mysql counts no row if there was no retry to clear
+			count := 0
+			err := tx.Table("t_dm_task").Where("id = ? and status not in ?", task.ParentID, busy).Select("count(*)").Scan(&count).Error
+			if err != nil || count == 0 {
+				return err
+			}
+		}
+		if err := tx.Create(task).Error; err != nil {
+			return err
+		}
+		if err := tx.CreateInBatches(details, 500).Error; err != nil {
+			return err
+		}
+		ok = true
+		return nil
+	})
+	return ok && err == nil, err
+}
+
+// FailDetail counts a failed attempt, permanent failures are never retried
+func (dal DirectMsgDAL) FailDetail(ctx *swe.Context, id, uid int64, kind int, reason string, nextRetry int64) error {
+	if len(reason) > 1024 {
+		reason = strings.ToValidUTF8(reason[:1024], "")
+	}
+	return getInstance(ctx).Exec("update t_dm_detail set status = ?, send_ts = ?, fail_reason = ?, fail_kind = ?, "+
+		"next_retry = ?, attempts = attempts + 1 where task_id = ? and uid = ?",
+		DM_DETAIL_STATUS_FAIL, time.Now().Unix(), reason, kind, nextRetry, id, uid).Error
+}
+
 func (dal DirectMsgDAL) UpdateDetailStatus(ctx *swe.Context, id, uid int64, status int, reason string) error {
-	fields := []string{"status", "send_ts"}
-	params := []any{status, time.Now().Unix()}
+	fields := []string{"status", "send_ts", "attempts"}
+	params := []any{status, time.Now().Unix(), gorm.Expr("attempts + 1")}
 	if len(reason) > 0 {
 		fields = append(fields, "fail_reason")
 		params = append(params, reason)
@@ -172,3 +340,22 @@ func (dal DirectMsgDAL) UpdateDetailStatus(ctx *swe.Context, id, uid int64, stat
 
 	return getInstance(ctx).Exec(builder.String(), params...).Error
 }
+
+type DMUserDetail struct {
+	EventID  int64 `gorm:"column:event_id"`
+	TaskID   int64 `gorm:"column:task_id"`
+	Status   int   `gorm:"column:status"`
+	SendTime int64 `gorm:"column:send_ts"`
+}
+
+func (dal DirectMsgDAL) UserDetailsByEvents(ctx *swe.Context, uid int64, eventIDs []int64) ([]DMUserDetail, error) {
+	ret := []DMUserDetail{}
+	if len(eventIDs) == 0 {
+		return ret, nil
+	}
+	tx := getInstance(ctx).Table("t_dm_detail as d").Joins("join t_dm_task as t on d.task_id = t.id")
+	tx = tx.Where("d.uid = ? and t.event_id in ?", uid, eventIDs)
+	tx = tx.Select("t.event_id, d.task_id, d.status, d.send_ts")
+	err := tx.Order("d.send_ts").Scan(&ret).Error
+	return ret, err
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"strings"
+
+	"github.com/zerozwt/swe"
+	"gorm.io/gorm/clause"
+)
+
+// DMAccount is a sender account in the pool of a streamer, shared by dm tasks which send
+// with the pool instead of a sender of their own. Cookies are sealed as in DMSender.
+type DMAccount struct {
+	ID         int64  `gorm:"primaryKey;column:id"`
+	RoomID     int64  `gorm:"column:room_id;uniqueIndex:uk_dm_account"`
+	UID        int64  `gorm:"column:uid;uniqueIndex:uk_dm_account"`
+	Credential string `gorm:"type:string;size:2048;column:credential"`
+	ExpireTime int64  `gorm:"column:expire_time"`
+	Interval   int    `gorm:"column:interval_sec"`
+	Status     int    `gorm:"column:status"`
+	FailCount  int    `gorm:"column:fail_count"`
+	Reason     string `gorm:"type:string;size:1024;column:reason"`
+	LastUsed   int64  `gorm:"column:last_used"`
+	CreateTime int64  `gorm:"column:create_time"`
+	UpdateTime int64  `gorm:"column:update_time"`
+}
+
+const (
+	DM_ACCOUNT_ACTIVE = iota + 1
+	DM_ACCOUNT_REMOVED
+)
+
+func (s DMAccount) TableName() string { return "t_dm_account" }
+
+func init() {
+	registerModel(&DMAccount{})
+}
+
+type DMAccountDAL struct{}
+
+func GetDMAccountDAL() DMAccountDAL { return DMAccountDAL{} }
+
+// Put adds the account to the pool, or puts it back with new cookies if it is already in
+func (dal DMAccountDAL) Put(ctx *swe.Context, account *DMAccount) error {
+	return getInstance(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "room_id"}, {Name: "uid"}},
+		DoUpdates: clause.AssignmentColumns([]string{"credential", "expire_time", "interval_sec", "status",
+			"fail_count", "reason", "update_time"}),
+	}).Create(account).Error
+}
+
+// List returns accounts of a room without credentials
+func (dal DMAccountDAL) List(ctx *swe.Context, roomID int64) ([]DMAccount, error) {
+	ret := []DMAccount{}
+	err := getInstance(ctx).Where("room_id = ?", roomID).Select("id", "room_id", "uid", "expire_time",
+		"interval_sec", "status", "fail_count", "reason", "last_used", "create_time", "update_time").
+		Order("id").Find(&ret).Error
+	return ret, err
+}
+
+// Sealed returns id, room id & credential of all accounts
+func (dal DMAccountDAL) Sealed(ctx *swe.Context) ([]DMAccount, error) {
+	ret := []DMAccount{}
+	err := getInstance(ctx).Select("id", "room_id", "credential").Find(&ret).Error
+	return ret, err
+}
+
+func (dal DMAccountDAL) SetCredential(ctx *swe.Context, id int64, credential string) error {
+	return getInstance(ctx).Exec("update t_dm_account set credential = ? where id = ?", credential, id).Error
+}
+
+func (dal DMAccountDAL) Active(ctx *swe.Context, roomID int64) ([]DMAccount, error) {
+	ret := []DMAccount{}
+	err := getInstance(ctx).Where("room_id = ? and status = ?", roomID, DM_ACCOUNT_ACTIVE).Order("id").Find(&ret).Error
+	return ret, err
+}
+
+func (dal DMAccountDAL) Delete(ctx *swe.Context, roomID, id int64) (bool, error) {
+	result := getInstance(ctx).Where("room_id = ? and id = ?", roomID, id).Delete(&DMAccount{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// SetStatus moves the account in or out of the pool, failures counted so far are cleared
+func (dal DMAccountDAL) SetStatus(ctx *swe.Context, roomID, id int64, status int, reason string, ts int64) (bool, error) {
+	result := getInstance(ctx).Exec("update t_dm_account set status = ?, reason = ?, fail_count = 0, update_time = ? "+
+		"where room_id = ? and id = ?", status, reason, ts, roomID, id)
+	return result.RowsAffected > 0, result.Error
+}
+
+// Fail counts a failure in a row of the account, and removes it from the pool once failed
+// maxFails times, returns true if it is removed by this call
+func (dal DMAccountDAL) Fail(ctx *swe.Context, id int64, reason string, maxFails int, ts int64) (bool, error) {
+	if len(reason) > 1024 {
+		reason = strings.ToValidUTF8(reason[:1024], "")
+	}
+	err := getInstance(ctx).Exec("update t_dm_account set fail_count = fail_count + 1, reason = ?, update_time = ? "+
+		"where id = ?", reason, ts, id).Error
+	if err != nil {
+		return false, err
+	}
+	result := getInstance(ctx).Exec("update t_dm_account set status = ? where id = ? and status = ? and fail_count >= ?",
+		DM_ACCOUNT_REMOVED, id, DM_ACCOUNT_ACTIVE, maxFails)
+	return result.RowsAffected > 0, result.Error
+}
+
+// Succeed records a message sent with the account and clears its failures
+func (dal DMAccountDAL) Succeed(ctx *swe.Context, id int64, ts int64) error {
+	return getInstance(ctx).Exec("update t_dm_account set fail_count = 0, last_used = ? where id = ?", ts, id).Error
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"github.com/zerozwt/swe"
+	"gorm.io/gorm/clause"
+)
+
+// DMSender keeps the account a dm task sends with, cookies are sealed by batch_dm
+// so that tasks can be resumed after restart without the streamer logging in
+type DMSender struct {
+	TaskID     int64  `gorm:"primaryKey;column:task_id"`
+	RoomID     int64  `gorm:"column:room_id"`
+	SenderUID  int64  `gorm:"column:sender_uid"`
+	Credential string `gorm:"type:string;size:2048;column:credential"`
+	ExpireTime int64  `gorm:"column:expire_time"`
+	Status     int    `gorm:"column:status"`
+	Reason     string `gorm:"type:string;size:1024;column:reason"`
+	UpdateTime int64  `gorm:"column:update_time"`
+}
+
+const (
+	DM_SENDER_UNKNOWN = iota + 1 // not used to send yet
+	DM_SENDER_VALID
+	DM_SENDER_INVALID
+)
+
+func (s DMSender) TableName() string { return "t_dm_sender" }
+
+func init() {
+	registerModel(&DMSender{})
+}
+
+type DMSenderDAL struct{}
+
+func GetDMSenderDAL() DMSenderDAL { return DMSenderDAL{} }
+
+func (dal DMSenderDAL) Put(ctx *swe.Context, sender *DMSender) error {
+	return getInstance(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "task_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"room_id", "sender_uid", "credential", "expire_time",
+			"status", "reason", "update_time"}),
+	}).Create(sender).Error
+}
+
+func (dal DMSenderDAL) Get(ctx *swe.Context, taskID int64) (*DMSender, error) {
+	ret := []DMSender{}
+	err := getInstance(ctx).Where("task_id = ?", taskID).Find(&ret).Error
+	if len(ret) == 0 {
+		return nil, err
+	}
+	return &ret[0], nil
+}
+
+// BatchGet returns senders without credentials by task id
+func (dal DMSenderDAL) BatchGet(ctx *swe.Context, taskIDs []int64) (map[int64]*DMSender, error) {
+	ret := map[int64]*DMSender{}
+	if len(taskIDs) == 0 {
+		return ret, nil
+	}
+	tmp := []DMSender{}
+	err := getInstance(ctx).Where("task_id in ?", taskIDs).
+		Select("task_id", "room_id", "sender_uid", "expire_time", "status", "reason", "update_time").Find(&tmp).Error
+	for idx := range tmp {
+		ret[tmp[idx].TaskID] = &tmp[idx]
+	}
+	return ret, err
+}
+
+// Sealed returns task id, room id & credential of all senders
+func (dal DMSenderDAL) Sealed(ctx *swe.Context) ([]DMSender, error) {
+	ret := []DMSender{}
+	err := getInstance(ctx).Select("task_id", "room_id", "credential").Find(&ret).Error
+	return ret, err
+}
+
+func (dal DMSenderDAL) SetCredential(ctx *swe.Context, taskID int64, credential string) error {
+	return getInstance(ctx).Exec("update t_dm_sender set credential = ? where task_id = ?", credential, taskID).Error
+}
+
+func (dal DMSenderDAL) SetStatus(ctx *swe.Context, taskID int64, status int, reason string, ts int64) error {
+	return getInstance(ctx).Exec("update t_dm_sender set status = ?, reason = ?, update_time = ? where task_id = ?",
+		status, reason, ts, taskID).Error
+}
@@ -1,6 +1,8 @@
 package db
 
 import (
+	"strings"
+
 	"github.com/zerozwt/swe"
 	"gorm.io/gorm/clause"
 )
@@ -14,6 +16,12 @@ type RewardEvent struct {
 	Hidden        int    `gorm:"column:hidden"`
 	CreateTime    int64  `gorm:"index:idx_re_room;column:create_time"`
 	Status        int    `gorm:"column:status"`
+	CalcPhase     int    `gorm:"column:calc_phase"`
+	CalcDone      int64  `gorm:"column:calc_done"`
+	CalcTotal     int64  `gorm:"column:calc_total"`
+	CalcStart     int64  `gorm:"column:calc_start"`
+	CalcEnd       int64  `gorm:"column:calc_end"`
+	CalcError     string `gorm:"type:string;size:1024;column:calc_error"`
 }
 
 func (s RewardEvent) TableName() string { return "t_event" }
@@ -35,8 +43,19 @@ const (
 	EVENT_CALCULATING
 	EVENT_ERROR
 	EVENT_READY
+	EVENT_RETRYING // calculation failed, waiting for the task to be retried
+)
+
+// phases of list calculation, users are loaded, filtered and written batch by batch
+const (
+	EVENT_PHASE_LOADING = iota + 1
+	EVENT_PHASE_FILTERING
+	EVENT_PHASE_WRITING
+	EVENT_PHASE_DONE
 )
 
+const maxCalcErrorLen = 1024
+
 func init() {
 	registerModel(&RewardEvent{})
 	registerModel(&RewardUser{})
@@ -57,7 +76,8 @@ func (dal RewardEventDAL) Page(ctx *swe.Context, roomID int64, offset, limit int
 	}
 
 	tx = tx.Offset(offset).Limit(limit)
-	tx = tx.Select("id", "name", "status", "content", "hidden").Order("create_time desc")
+	tx = tx.Select("id", "name", "status", "content", "hidden", "calc_phase", "calc_done", "calc_total",
+		"calc_start", "calc_end", "calc_error").Order("create_time desc")
 	err = tx.Find(&ret).Error
 	return count, ret, err
 }
@@ -79,6 +99,41 @@ func (dal RewardEventDAL) SetStatus(ctx *swe.Context, id int64, status int) erro
 	return getInstance(ctx).Exec("update t_event set status = ? where id = ?", status, id).Error
 }
 
+// StartCalc marks the event calculating and resets progress & error of last calculation
+func (dal RewardEventDAL) StartCalc(ctx *swe.Context, id, ts int64) error {
+	return getInstance(ctx).Exec("update t_event set status = ?, calc_phase = ?, calc_done = 0, calc_total = 0, "+
+		"calc_start = ?, calc_end = 0, calc_error = '' where id = ?", EVENT_CALCULATING, EVENT_PHASE_LOADING, ts, id).Error
+}
+
+// SetProgress records the phase of list calculation and how many of total users have been processed
+func (dal RewardEventDAL) SetProgress(ctx *swe.Context, id int64, phase int, done, total int64) error {
+	return getInstance(ctx).Exec("update t_event set calc_phase = ?, calc_done = ?, calc_total = ? where id = ?",
+		phase, done, total, id).Error
+}
+
+// FailCalc marks the event error with the reason, phase & progress are kept for diagnosis
+func (dal RewardEventDAL) FailCalc(ctx *swe.Context, id int64, reason string, ts int64) error {
+	return dal.stopCalc(ctx, id, EVENT_ERROR, reason, ts)
+}
+
+// RetryCalc marks the event waiting for calculation to be retried, with the reason of the failed attempt
+func (dal RewardEventDAL) RetryCalc(ctx *swe.Context, id int64, reason string, ts int64) error {
+	return dal.stopCalc(ctx, id, EVENT_RETRYING, reason, ts)
+}
+
+func (dal RewardEventDAL) stopCalc(ctx *swe.Context, id int64, status int, reason string, ts int64) error {
+	if len(reason) > maxCalcErrorLen {
+		reason = strings.ToValidUTF8(reason[:maxCalcErrorLen], "")
+	}
+	return getInstance(ctx).Exec("update t_event set status = ?, calc_error = ?, calc_end = ? where id = ?",
+		status, reason, ts, id).Error
+}
+
+func (dal RewardEventDAL) FinishCalc(ctx *swe.Context, id, ts int64) error {
+	return getInstance(ctx).Exec("update t_event set status = ?, calc_phase = ?, calc_end = ? where id = ?",
+		EVENT_READY, EVENT_PHASE_DONE, ts, id).Error
+}
+
 func (dal RewardEventDAL) ClearUsers(ctx *swe.Context, eventID int64) error {
 	return getInstance(ctx).Exec("delete from t_event_user where event_id = ?", eventID).Error
 }
@@ -140,7 +195,7 @@ func (dal RewardEventDAL) BlockUser(ctx *swe.Context, eventID, uid int64, block
 func (dal RewardEventDAL) Users(ctx *swe.Context, eventID int64) ([]RewardUser, error) {
 	ret := []RewardUser{}
 	tx := getInstance(ctx)
-	tx = tx.Where("event_id = ?", eventID)
+	tx = tx.Where("event_id = ?", eventID).Order("ts, uid")
 	err := tx.Find(&ret).Error
 	return ret, err
 }
@@ -179,3 +234,22 @@ func (dal RewardEventDAL) UpdateAddrInfo(ctx *swe.Context, uid, eventID int64, a
 		addr, uid, eventID)
 	return tx.RowsAffected, tx.Error
 }
+
+type UserEventRecord struct {
+	EventID   int64  `gorm:"column:event_id"`
+	EventName string `gorm:"column:name"`
+	Reward    string `gorm:"column:content"`
+	Time      int64  `gorm:"column:ts"`
+	Blocked   int    `gorm:"column:block"`
+	HasAddr   int    `gorm:"column:has_addr"`
+}
+
+func (dal RewardEventDAL) UserRecordsByRoom(ctx *swe.Context, uid, roomID int64) ([]UserEventRecord, error) {
+	ret := []UserEventRecord{}
+	tx := getInstance(ctx).Table("t_event_user as u").Joins("join t_event as e on u.event_id = e.id")
+	tx = tx.Where("u.uid = ? and e.room_id = ?", uid, roomID)
+	tx = tx.Select("u.event_id, e.name, e.content, u.ts, u.block, " +
+		"case when length(u.address_info) > 0 then 1 else 0 end as has_addr")
+	err := tx.Order("u.ts desc").Scan(&ret).Error
+	return ret, err
+}
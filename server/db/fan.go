@@ -0,0 +1,83 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zerozwt/swe"
+)
+
+// PaySummary is the aggregation of one kind of paid records,
+// Value is gold coins for gifts, CNY for super chats and months for guards
+type PaySummary struct {
+	Count   int64 `gorm:"column:ct"`
+	Value   int64 `gorm:"column:val"`
+	FirstTs int64 `gorm:"column:first_ts"`
+	LastTs  int64 `gorm:"column:last_ts"`
+}
+
+type FanDAL struct{}
+
+func GetFanDAL() FanDAL { return FanDAL{} }
+
+// PayRange is the time range of one kind of paid records, kind is one of gift, sc and member
+type PayRange struct {
+	Kind  string
+	Begin int64
+	End   int64
+}
+
+var payRangeTables map[string]string = map[string]string{
+	"gift":   "t_gift",
+	"sc":     "t_super_chat",
+	"member": "t_member",
+}
+
+// payersSQL lists sender uids of paid records in ranges, may contain duplicates
+func payersSQL(roomID int64, ranges []PayRange) (string, []any) {
+	parts := []string{}
+	args := []any{}
+	for _, item := range ranges {
+		table, ok := payRangeTables[item.Kind]
+		if !ok {
+			continue
+		}
+		parts = append(parts, "select sender_uid as uid from "+table+" where room_id = ? and send_time between ? and ?")
+		args = append(args, roomID, item.Begin, item.End)
+	}
+	return strings.Join(parts, " union all "), args
+}
+
+// CountPayers counts distinct users paid in ranges
+func (dal FanDAL) CountPayers(ctx *swe.Context, roomID int64, ranges []PayRange) (int64, error) {
+	sql, args := payersSQL(roomID, ranges)
+	if len(args) == 0 {
+		return 0, nil
+	}
+	ret := int64(0)
+	err := getInstance(ctx).Raw("select count(distinct uid) from ("+sql+") as tmp", args...).Scan(&ret).Error
+	return ret, err
+}
+
+// NextPayers returns at most limit users paid in ranges with uid greater than afterUID, ordered by uid.
+// Each table is limited on its own so that only the next few users are scanned through idx_*_room_uid.
+func (dal FanDAL) NextPayers(ctx *swe.Context, roomID int64, ranges []PayRange, afterUID int64, limit int) ([]int64, error) {
+	ret := []int64{}
+	parts := []string{}
+	args := []any{}
+	for idx, item := range ranges {
+		table, ok := payRangeTables[item.Kind]
+		if !ok {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("select uid from (select distinct sender_uid as uid from %s "+
+			"where room_id = ? and sender_uid > ? and send_time between ? and ? order by sender_uid limit ?) as t%d", table, idx))
+		args = append(args, roomID, afterUID, item.Begin, item.End, limit)
+	}
+	if len(parts) == 0 {
+		return ret, nil
+	}
+	args = append(args, limit)
+	err := getInstance(ctx).Raw("select uid from ("+strings.Join(parts, " union ")+") as tmp order by uid limit ?", args...).Scan(&ret).Error
+	return ret, err
+}
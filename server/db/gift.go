@@ -8,9 +8,9 @@ import (
 
 type GiftRecord struct {
 	BatchID    string `gorm:"type:string;size:1024;column:batch_id;primaryKey" json:"-"`
-	RoomID     int64  `gorm:"index:idx_gift_room_time;column:room_id" json:"-"`
+	RoomID     int64  `gorm:"index:idx_gift_room_time;index:idx_gift_room_uid;column:room_id" json:"-"`
 	SendTime   int64  `gorm:"index:idx_gift_room_time;column:send_time"`
-	SenderUID  int64  `gorm:"column:sender_uid" json:"-"`
+	SenderUID  int64  `gorm:"index:idx_gift_room_uid;column:sender_uid" json:"-"`
 	SenderName string `gorm:"type:string;size:256;column:sender_name" json:"-"`
 	GiftID     int64  `gorm:"column:gift_id"`
 	GiftName   string `gorm:"type:string;size:256"`
@@ -37,11 +37,25 @@ type GiftDAL struct{}
 
 func GetGiftDAL() GiftDAL { return GiftDAL{} }
 
-func (dal GiftDAL) Insert(ctx *swe.Context, gift *GiftRecord) error {
-	return getInstance(ctx).Clauses(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "batch_id"}},
-		DoUpdates: clause.Assignments(map[string]interface{}{"gift_count": gorm.Expr("gift_count+?", gift.GiftCount)}),
-	}).Create(gift).Error
+// Insert saves a gift record, messages of a combo are merged into one record by batch id. It returns
+// false if gift is merged into an existing record, and sets gift.SendTime to send time of that record.
+func (dal GiftDAL) Insert(ctx *swe.Context, gift *GiftRecord) (bool, error) {
+	inserted := false
+	err := getInstance(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(gift)
+		if result.Error != nil {
+			return result.Error
+		}
+		if inserted = result.RowsAffected > 0; inserted {
+			return nil
+		}
+		err := tx.Exec("update t_gift set gift_count = gift_count + ? where batch_id = ?", gift.GiftCount, gift.BatchID).Error
+		if err != nil {
+			return err
+		}
+		return tx.Table("t_gift").Where("batch_id = ?", gift.BatchID).Select("send_time").Scan(&gift.SendTime).Error
+	})
+	return inserted, err
 }
 
 func (dal GiftDAL) UpdateGiftInfo(ctx *swe.Context, id int64, name string, price int64) error {
@@ -97,3 +111,44 @@ func (dal GiftDAL) Range(ctx *swe.Context, roomID, tsBegin, tsEnd int64) ([]*Gif
 	}
 	return ret, err
 }
+
+// UsersRange is Range of senders with uid in [uidFrom, uidTo], ordered by sender & send time
+func (dal GiftDAL) UsersRange(ctx *swe.Context, roomID, tsBegin, tsEnd, uidFrom, uidTo int64) ([]*GiftRecord, error) {
+	tmp := []GiftRecord{}
+	tx := getInstance(ctx)
+	tx = tx.Where("room_id = ? and sender_uid between ? and ?", roomID, uidFrom, uidTo)
+	tx = tx.Where("send_time between ? and ?", tsBegin, tsEnd)
+	tx = tx.Order("sender_uid, send_time")
+	err := tx.Find(&tmp).Error
+	ret := make([]*GiftRecord, 0, len(tmp))
+	for idx := range tmp {
+		ret = append(ret, &tmp[idx])
+	}
+	return ret, err
+}
+
+func (dal GiftDAL) UserSummary(ctx *swe.Context, roomID, uid, tsBegin, tsEnd int64) (PaySummary, error) {
+	ret := PaySummary{}
+	tx := getInstance(ctx).Table("t_gift").Where("room_id = ? and sender_uid = ?", roomID, uid)
+	tx = tx.Where("send_time between ? and ?", tsBegin, tsEnd)
+	tx = tx.Select("count(*) as ct, coalesce(sum(gift_price*gift_count), 0) as val, " +
+		"coalesce(min(send_time), 0) as first_ts, coalesce(max(send_time), 0) as last_ts")
+	err := tx.Scan(&ret).Error
+	return ret, err
+}
+
+// Sum counts gifts of room sent in [tsBegin, tsEnd), and their value in gold coins, only gifts in giftIDs
+// if not empty
+func (dal GiftDAL) Sum(ctx *swe.Context, roomID, tsBegin, tsEnd int64, giftIDs []int64) (count, value int64, err error) {
+	ret := struct {
+		Number int64 `gorm:"column:number"`
+		Value  int64 `gorm:"column:val"`
+	}{}
+	tx := getInstance(ctx).Table("t_gift").Where("room_id = ? and send_time >= ? and send_time < ?", roomID, tsBegin, tsEnd)
+	if len(giftIDs) > 0 {
+		tx = tx.Where("gift_id in ?", giftIDs)
+	}
+	err = tx.Select("coalesce(sum(gift_count), 0) as number, coalesce(sum(gift_price*gift_count), 0) as val").
+		Scan(&ret).Error
+	return ret.Number, ret.Value, err
+}
@@ -0,0 +1,40 @@
+package db
+
+import (
+	"github.com/zerozwt/swe"
+	"gorm.io/gorm/clause"
+)
+
+// IngestClaim marks a record shipped by a remote collector as saved, so that it is not saved
+// again when the collector ships it once more after a lost answer
+type IngestClaim struct {
+	Key        string `gorm:"primaryKey;column:claim_key;size:64"`
+	CreateTime int64  `gorm:"column:create_time;index:idx_ingest_claim_time"`
+}
+
+func (s IngestClaim) TableName() string { return "t_ingest_claim" }
+
+func init() {
+	registerModel(&IngestClaim{})
+}
+
+type IngestDAL struct{}
+
+func GetIngestDAL() IngestDAL { return IngestDAL{} }
+
+// Claim marks record key as saved, returns false if it is marked already
+func (dal IngestDAL) Claim(ctx *swe.Context, key string, ts int64) (bool, error) {
+	result := getInstance(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&IngestClaim{Key: key, CreateTime: ts})
+	return result.RowsAffected > 0, result.Error
+}
+
+// Release drops the mark of a record failed to save, so that it is saved when shipped again
+func (dal IngestDAL) Release(ctx *swe.Context, key string) error {
+	return getInstance(ctx).Exec("delete from t_ingest_claim where claim_key = ?", key).Error
+}
+
+// Prune deletes marks created before ts
+func (dal IngestDAL) Prune(ctx *swe.Context, ts int64) (int64, error) {
+	result := getInstance(ctx).Exec("delete from t_ingest_claim where create_time < ?", ts)
+	return result.RowsAffected, result.Error
+}
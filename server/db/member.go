@@ -3,9 +3,9 @@ package db
 import "github.com/zerozwt/swe"
 
 type MembershipRecord struct {
-	RoomID     int64  `gorm:"index:idx_member_room_time;column:room_id" json:"-"`
+	RoomID     int64  `gorm:"index:idx_member_room_time;index:idx_member_room_uid;column:room_id" json:"-"`
 	SendTime   int64  `gorm:"index:idx_member_room_time;column:send_time"`
-	SenderUID  int64  `gorm:"column:sender_uid" json:"-"`
+	SenderUID  int64  `gorm:"index:idx_member_room_uid;column:sender_uid" json:"-"`
 	SenderName string `gorm:"type:string;size:256;column:sender_name" json:"-"`
 	GuardLevel int    `gorm:"column:level"`
 	Count      int
@@ -72,3 +72,68 @@ func (dal MemberDAL) Range(ctx *swe.Context, roomID, tsBegin, tsEnd int64) ([]*M
 	}
 	return ret, err
 }
+
+// UsersRange is Range of senders with uid in [uidFrom, uidTo], ordered by sender & send time
+func (dal MemberDAL) UsersRange(ctx *swe.Context, roomID, tsBegin, tsEnd, uidFrom, uidTo int64) ([]*MembershipRecord, error) {
+	tmp := []MembershipRecord{}
+	tx := getInstance(ctx)
+	tx = tx.Where("room_id = ? and sender_uid between ? and ?", roomID, uidFrom, uidTo)
+	tx = tx.Where("send_time between ? and ?", tsBegin, tsEnd)
+	tx = tx.Order("sender_uid, send_time")
+	err := tx.Find(&tmp).Error
+	ret := make([]*MembershipRecord, 0, len(tmp))
+	for idx := range tmp {
+		ret = append(ret, &tmp[idx])
+	}
+	return ret, err
+}
+
+func (dal MemberDAL) UserSummary(ctx *swe.Context, roomID, uid, tsBegin, tsEnd int64) (PaySummary, error) {
+	ret := PaySummary{}
+	tx := getInstance(ctx).Table("t_member").Where("room_id = ? and sender_uid = ?", roomID, uid)
+	tx = tx.Where("send_time between ? and ?", tsBegin, tsEnd)
+	tx = tx.Select("count(*) as ct, coalesce(sum(count), 0) as val, " +
+		"coalesce(min(send_time), 0) as first_ts, coalesce(max(send_time), 0) as last_ts")
+	err := tx.Scan(&ret).Error
+	return ret, err
+}
+
+func (dal MemberDAL) UserRecords(ctx *swe.Context, roomID, uid int64) ([]MembershipRecord, error) {
+	ret := []MembershipRecord{}
+	tx := getInstance(ctx).Where("room_id = ? and sender_uid = ?", roomID, uid)
+	err := tx.Order("send_time").Find(&ret).Error
+	return ret, err
+}
+
+// PossibleGuards lists users of room who may still be guards at now: only if their last purchase plus all
+// months they ever bought reaches now, so that the roster loads records of these users instead of the room.
+func (dal MemberDAL) PossibleGuards(ctx *swe.Context, roomID, now, monthSeconds int64) ([]int64, error) {
+	ret := []int64{}
+	tx := getInstance(ctx).Table("t_member").Where("room_id = ? and send_time <= ?", roomID, now)
+	tx = tx.Group("sender_uid").Having("max(send_time) + sum(count) * ? > ?", monthSeconds, now)
+	err := tx.Select("sender_uid").Scan(&ret).Error
+	return ret, err
+}
+
+// uids in one query of UsersRecords
+const MEMBER_UID_BATCH = 500
+
+// UsersRecords returns all records of uids in room sent before tsEnd, ordered by sender & send time
+func (dal MemberDAL) UsersRecords(ctx *swe.Context, roomID int64, uids []int64, tsEnd int64) ([]*MembershipRecord, error) {
+	ret := make([]*MembershipRecord, 0, len(uids))
+	for begin := 0; begin < len(uids); begin += MEMBER_UID_BATCH {
+		end := begin + MEMBER_UID_BATCH
+		if end > len(uids) {
+			end = len(uids)
+		}
+		tmp := []MembershipRecord{}
+		tx := getInstance(ctx).Where("room_id = ? and sender_uid in ? and send_time <= ?", roomID, uids[begin:end], tsEnd)
+		if err := tx.Order("sender_uid, send_time").Find(&tmp).Error; err != nil {
+			return nil, err
+		}
+		for idx := range tmp {
+			ret = append(ret, &tmp[idx])
+		}
+	}
+	return ret, nil
+}
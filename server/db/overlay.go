@@ -0,0 +1,98 @@
+package db
+
+import (
+	"github.com/zerozwt/swe"
+)
+
+// Overlay is a page of a room shown in OBS, opened by its token instead of a streamer login
+type Overlay struct {
+	ID         int64  `gorm:"primaryKey;column:id"`
+	RoomID     int64  `gorm:"column:room_id;index:idx_overlay_room"`
+	Kind       string `gorm:"type:string;size:32;column:kind"` // OVERLAY_KIND_*
+	Name       string `gorm:"type:string;size:256;column:name"`
+	Token      string `gorm:"type:string;size:64;index:idx_overlay_token,unique;column:token"`
+	Title      string `gorm:"type:string;size:256;column:title"`
+	MinSC      int64  `gorm:"column:min_sc"`                         // CNY, sc wall only
+	MaxItems   int    `gorm:"column:max_items"`                      // sc wall only
+	GoalUnit   string `gorm:"type:string;size:32;column:goal_unit"`  // OVERLAY_GOAL_*
+	GoalTarget int64  `gorm:"column:goal_target"`                    // gold coins or gifts
+	GiftIDs    string `gorm:"type:string;size:1024;column:gift_ids"` // joined by comma, empty for all gifts
+	StartTime  int64  `gorm:"column:start_time"`                     // gifts are counted from then on
+	CreateTime int64  `gorm:"column:create_time"`
+	UpdateTime int64  `gorm:"column:update_time"`
+}
+
+const (
+	OVERLAY_KIND_SC_WALL = "sc_wall"
+	OVERLAY_KIND_GOAL    = "goal"
+
+	OVERLAY_GOAL_VALUE = "value"
+	OVERLAY_GOAL_COUNT = "count"
+)
+
+func (s Overlay) TableName() string { return "t_overlay" }
+
+func init() {
+	registerModel(&Overlay{})
+}
+
+type OverlayDAL struct{}
+
+func GetOverlayDAL() OverlayDAL { return OverlayDAL{} }
+
+func (dal OverlayDAL) Put(ctx *swe.Context, item *Overlay) error {
+	return getInstance(ctx).Create(item).Error
+}
+
+// Update changes the settings of an overlay of room, its token and start time are kept, returns false if not found
+func (dal OverlayDAL) Update(ctx *swe.Context, item *Overlay) (bool, error) {
+	result := getInstance(ctx).Exec("update t_overlay set name = ?, title = ?, min_sc = ?, max_items = ?, goal_unit = ?, "+
+		"goal_target = ?, gift_ids = ?, update_time = ? where id = ? and room_id = ?",
+		item.Name, item.Title, item.MinSC, item.MaxItems, item.GoalUnit, item.GoalTarget, item.GiftIDs,
+		item.UpdateTime, item.ID, item.RoomID)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (dal OverlayDAL) GetByRoomID(ctx *swe.Context, id, roomID int64) (*Overlay, error) {
+	ret := []Overlay{}
+	err := getInstance(ctx).Where("id = ? and room_id = ?", id, roomID).Find(&ret).Error
+	if len(ret) == 0 {
+		return nil, err
+	}
+	return &ret[0], nil
+}
+
+func (dal OverlayDAL) GetByToken(ctx *swe.Context, token string) (*Overlay, error) {
+	ret := []Overlay{}
+	err := getInstance(ctx).Where("token = ?", token).Find(&ret).Error
+	if len(ret) == 0 {
+		return nil, err
+	}
+	return &ret[0], nil
+}
+
+func (dal OverlayDAL) List(ctx *swe.Context, roomID int64) ([]Overlay, error) {
+	ret := []Overlay{}
+	err := getInstance(ctx).Where("room_id = ?", roomID).Order("id").Find(&ret).Error
+	return ret, err
+}
+
+// Delete removes an overlay of room, returns false if not found
+func (dal OverlayDAL) Delete(ctx *swe.Context, roomID, id int64) (bool, error) {
+	result := getInstance(ctx).Exec("delete from t_overlay where id = ? and room_id = ?", id, roomID)
+	return result.RowsAffected > 0, result.Error
+}
+
+// SetToken replaces the token of an overlay of room, so that its old url stops working
+func (dal OverlayDAL) SetToken(ctx *swe.Context, roomID, id int64, token string, ts int64) (bool, error) {
+	result := getInstance(ctx).Exec("update t_overlay set token = ?, update_time = ? where id = ? and room_id = ?",
+		token, ts, id, roomID)
+	return result.RowsAffected > 0, result.Error
+}
+
+// SetStartTime restarts counting gifts of a goal overlay of room from ts
+func (dal OverlayDAL) SetStartTime(ctx *swe.Context, roomID, id, ts int64) (bool, error) {
+	result := getInstance(ctx).Exec("update t_overlay set start_time = ?, update_time = ? where id = ? and room_id = ?",
+		ts, ts, id, roomID)
+	return result.RowsAffected > 0, result.Error
+}
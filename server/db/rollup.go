@@ -0,0 +1,383 @@
+package db
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/zerozwt/octant/server/utils"
+	"github.com/zerozwt/swe"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// rollups are kept by hour and by day of the configured timezone,
+// day rollups must be rebuilt once the timezone is changed
+const (
+	ROLLUP_HOUR = 1
+	ROLLUP_DAY  = 2
+
+	// upper bound of time ranges covering all records
+	ROLLUP_TS_MAX = math.MaxInt64
+)
+
+var rollupUnits []int = []int{ROLLUP_HOUR, ROLLUP_DAY}
+
+// RoomRollup is the revenue of a live room in an hour or a day,
+// gift value is in gold coins, sc value in CNY, guards are counted per level
+type RoomRollup struct {
+	RoomID       int64 `gorm:"primaryKey;column:room_id"`
+	Unit         int   `gorm:"primaryKey;column:unit"`
+	Ts           int64 `gorm:"primaryKey;column:ts"`
+	GiftNumber   int64 `gorm:"column:gift_number"`
+	GiftValue    int64 `gorm:"column:gift_value"`
+	SCCount      int64 `gorm:"column:sc_count"`
+	SCValue      int64 `gorm:"column:sc_value"`
+	Guard1Count  int64 `gorm:"column:g1_count"`
+	Guard1Months int64 `gorm:"column:g1_months"`
+	Guard2Count  int64 `gorm:"column:g2_count"`
+	Guard2Months int64 `gorm:"column:g2_months"`
+	Guard3Count  int64 `gorm:"column:g3_count"`
+	Guard3Months int64 `gorm:"column:g3_months"`
+}
+
+func (s RoomRollup) TableName() string { return "t_rollup_room" }
+
+// PayerRollup is what a user paid in a live room in an hour or a day, guard value is in CNY
+type PayerRollup struct {
+	RoomID     int64 `gorm:"primaryKey;column:room_id"`
+	Unit       int   `gorm:"primaryKey;column:unit"`
+	Ts         int64 `gorm:"primaryKey;column:ts"`
+	UID        int64 `gorm:"primaryKey;column:uid"`
+	Count      int64 `gorm:"column:ct"`
+	GiftValue  int64 `gorm:"column:gift_value"`
+	SCValue    int64 `gorm:"column:sc_value"`
+	GuardValue int64 `gorm:"column:guard_value"`
+}
+
+func (s PayerRollup) TableName() string { return "t_rollup_payer" }
+
+type GiftRollup struct {
+	RoomID int64 `gorm:"primaryKey;column:room_id"`
+	Unit   int   `gorm:"primaryKey;column:unit"`
+	Ts     int64 `gorm:"primaryKey;column:ts"`
+	GiftID int64 `gorm:"primaryKey;column:gift_id"`
+	Number int64 `gorm:"column:number"`
+	Value  int64 `gorm:"column:value"`
+}
+
+func (s GiftRollup) TableName() string { return "t_rollup_gift" }
+
+func init() {
+	registerModel(&RoomRollup{})
+	registerModel(&PayerRollup{})
+	registerModel(&GiftRollup{})
+}
+
+func GuardPrice(level int) int64 {
+	switch level {
+	case 1:
+		return GUARD_PRICE_ZONGDU
+	case 2:
+		return GUARD_PRICE_TIDU
+	case 3:
+		return GUARD_PRICE_JIANZHANG
+	}
+	return 0
+}
+
+// RollupDAL maintains rollups, which analytics and overlay goals read. Event calculation keeps reading
+// raw records: its conditions test single records, e.g. price of each sc or id of each gift, and its
+// time ranges are not on whole hours, neither of which rollups can tell.
+type RollupDAL struct{}
+
+func GetRollupDAL() RollupDAL { return RollupDAL{} }
+
+func rollupTs(unit int, ts int64) int64 {
+	if unit == ROLLUP_DAY {
+		return utils.DayStart(ts)
+	}
+	return utils.HourStart(ts)
+}
+
+// incrExpr builds assignments adding values of row to existing columns
+func incrExpr(values map[string]int64) clause.Set {
+	ret := map[string]any{}
+	for col, value := range values {
+		ret[col] = gorm.Expr(col+" + ?", value)
+	}
+	return clause.Assignments(ret)
+}
+
+func addRoomRollup(tx *gorm.DB, item *RoomRollup, values map[string]int64) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "room_id"}, {Name: "unit"}, {Name: "ts"}},
+		DoUpdates: incrExpr(values),
+	}).Create(item).Error
+}
+
+func addPayerRollup(tx *gorm.DB, item *PayerRollup, values map[string]int64) error {
+	values["ct"] = item.Count
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "room_id"}, {Name: "unit"}, {Name: "ts"}, {Name: "uid"}},
+		DoUpdates: incrExpr(values),
+	}).Create(item).Error
+}
+
+// AddGift adds a gift message to rollups, value is in gold coins. ts is send time of the stored gift
+// record, inserted tells if the message made a new record rather than merged into its combo.
+func (dal RollupDAL) AddGift(ctx *swe.Context, roomID, ts, uid, giftID, number, value int64, inserted bool) error {
+	count := int64(0)
+	if inserted {
+		count = 1
+	}
+	return getInstance(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, unit := range rollupUnits {
+			bucket := rollupTs(unit, ts)
+			err := addRoomRollup(tx, &RoomRollup{RoomID: roomID, Unit: unit, Ts: bucket, GiftNumber: number, GiftValue: value},
+				map[string]int64{"gift_number": number, "gift_value": value})
+			if err != nil {
+				return err
+			}
+			err = addPayerRollup(tx, &PayerRollup{RoomID: roomID, Unit: unit, Ts: bucket, UID: uid, Count: count, GiftValue: value},
+				map[string]int64{"gift_value": value})
+			if err != nil {
+				return err
+			}
+			err = tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "room_id"}, {Name: "unit"}, {Name: "ts"}, {Name: "gift_id"}},
+				DoUpdates: incrExpr(map[string]int64{"number": number, "value": value}),
+			}).Create(&GiftRollup{RoomID: roomID, Unit: unit, Ts: bucket, GiftID: giftID, Number: number, Value: value}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GiftSum sums hourly gift rollups of room in [tsBegin, tsEnd), value is in gold coins, only gifts in giftIDs
+// if not empty
+func (dal RollupDAL) GiftSum(ctx *swe.Context, roomID, tsBegin, tsEnd int64, giftIDs []int64) (number, value int64, err error) {
+	ret := struct {
+		Number int64 `gorm:"column:number"`
+		Value  int64 `gorm:"column:val"`
+	}{}
+	tx := getInstance(ctx).Table("t_rollup_gift").Where("room_id = ? and unit = ? and ts >= ? and ts < ?",
+		roomID, ROLLUP_HOUR, tsBegin, tsEnd)
+	if len(giftIDs) > 0 {
+		tx = tx.Where("gift_id in ?", giftIDs)
+	}
+	err = tx.Select("coalesce(sum(number), 0) as number, coalesce(sum(value), 0) as val").Scan(&ret).Error
+	return ret.Number, ret.Value, err
+}
+
+// AddSC adds a super chat record to rollups, price is in CNY
+func (dal RollupDAL) AddSC(ctx *swe.Context, roomID, ts, uid, price int64) error {
+	return getInstance(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, unit := range rollupUnits {
+			bucket := rollupTs(unit, ts)
+			err := addRoomRollup(tx, &RoomRollup{RoomID: roomID, Unit: unit, Ts: bucket, SCCount: 1, SCValue: price},
+				map[string]int64{"sc_count": 1, "sc_value": price})
+			if err != nil {
+				return err
+			}
+			err = addPayerRollup(tx, &PayerRollup{RoomID: roomID, Unit: unit, Ts: bucket, UID: uid, Count: 1, SCValue: price},
+				map[string]int64{"sc_value": price})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AddGuard adds a guard purchase to rollups
+func (dal RollupDAL) AddGuard(ctx *swe.Context, roomID, ts, uid int64, level, months int) error {
+	if level < 1 || level > 3 {
+		return fmt.Errorf("invalid guard level %d", level)
+	}
+	countCol, monthsCol := fmt.Sprintf("g%d_count", level), fmt.Sprintf("g%d_months", level)
+	value := GuardPrice(level) * int64(months)
+
+	return getInstance(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, unit := range rollupUnits {
+			bucket := rollupTs(unit, ts)
+			item := RoomRollup{RoomID: roomID, Unit: unit, Ts: bucket}
+			item.setGuard(level, 1, int64(months))
+			err := addRoomRollup(tx, &item, map[string]int64{countCol: 1, monthsCol: int64(months)})
+			if err != nil {
+				return err
+			}
+			err = addPayerRollup(tx, &PayerRollup{RoomID: roomID, Unit: unit, Ts: bucket, UID: uid, Count: 1, GuardValue: value},
+				map[string]int64{"guard_value": value})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (item *RoomRollup) setGuard(level int, count, months int64) {
+	switch level {
+	case 1:
+		item.Guard1Count, item.Guard1Months = count, months
+	case 2:
+		item.Guard2Count, item.Guard2Months = count, months
+	case 3:
+		item.Guard3Count, item.Guard3Months = count, months
+	}
+}
+
+func (item *RoomRollup) add(other *RoomRollup) {
+	item.GiftNumber += other.GiftNumber
+	item.GiftValue += other.GiftValue
+	item.SCCount += other.SCCount
+	item.SCValue += other.SCValue
+	item.Guard1Count += other.Guard1Count
+	item.Guard1Months += other.Guard1Months
+	item.Guard2Count += other.Guard2Count
+	item.Guard2Months += other.Guard2Months
+	item.Guard3Count += other.Guard3Count
+	item.Guard3Months += other.Guard3Months
+}
+
+// Rooms lists live rooms having raw paid records
+func (dal RollupDAL) Rooms(ctx *swe.Context) ([]int64, error) {
+	ret := []int64{}
+	err := getInstance(ctx).Raw("select room_id from t_gift union select room_id from t_super_chat " +
+		"union select room_id from t_member").Scan(&ret).Error
+	return ret, err
+}
+
+// Rebuild recomputes all rollups of a live room from raw records. Hour rollups are aggregated by sql,
+// day rollups are merged from hour rollups as days in the configured timezone are not fixed length.
+func (dal RollupDAL) Rebuild(ctx *swe.Context, roomID int64) error {
+	shift := utils.HourShift()
+	hourExpr := fmt.Sprintf("send_time - (send_time + %d) %% 3600", shift)
+
+	return getInstance(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, table := range []string{"t_rollup_room", "t_rollup_payer", "t_rollup_gift"} {
+			if err := tx.Exec("delete from "+table+" where room_id = ?", roomID).Error; err != nil {
+				return err
+			}
+		}
+
+		// hourly rollups
+		rooms := map[int64]*RoomRollup{}
+		room := func(ts int64) *RoomRollup {
+			if _, ok := rooms[ts]; !ok {
+				rooms[ts] = &RoomRollup{RoomID: roomID, Unit: ROLLUP_HOUR, Ts: ts}
+			}
+			return rooms[ts]
+		}
+
+		gifts := []GiftRollup{}
+		err := tx.Raw("select room_id, ? as unit, "+hourExpr+" as ts, gift_id, sum(gift_count) as number, "+
+			"sum(gift_price*gift_count) as value from t_gift where room_id = ? group by "+hourExpr+", gift_id",
+			ROLLUP_HOUR, roomID).Scan(&gifts).Error
+		if err != nil {
+			return err
+		}
+		for _, item := range gifts {
+			room(item.Ts).GiftNumber += item.Number
+			room(item.Ts).GiftValue += item.Value
+		}
+
+		scs := []RoomRollup{}
+		err = tx.Raw("select "+hourExpr+" as ts, count(*) as sc_count, sum(price) as sc_value "+
+			"from t_super_chat where room_id = ? group by "+hourExpr, roomID).Scan(&scs).Error
+		if err != nil {
+			return err
+		}
+		for _, item := range scs {
+			room(item.Ts).SCCount += item.SCCount
+			room(item.Ts).SCValue += item.SCValue
+		}
+
+		guards := []struct {
+			Ts     int64 `gorm:"column:ts"`
+			Level  int   `gorm:"column:level"`
+			Count  int64 `gorm:"column:ct"`
+			Months int64 `gorm:"column:months"`
+		}{}
+		err = tx.Raw("select "+hourExpr+" as ts, level, count(*) as ct, sum(count) as months "+
+			"from t_member where room_id = ? group by "+hourExpr+", level", roomID).Scan(&guards).Error
+		if err != nil {
+			return err
+		}
+		for _, item := range guards {
+			tmp := RoomRollup{}
+			tmp.setGuard(item.Level, item.Count, item.Months)
+			room(item.Ts).add(&tmp)
+		}
+
+		payers := []PayerRollup{}
+		err = tx.Raw("select ? as room_id, ? as unit, ts - (ts + ?) % 3600 as ts, uid, count(*) as ct, sum(gift) as gift_value, "+
+			"sum(sc) as sc_value, sum(guard) as guard_value from ("+payRecordsSQL+") as r group by ts - (ts + ?) % 3600, uid",
+			roomID, ROLLUP_HOUR, shift, roomID, 0, ROLLUP_TS_MAX, roomID, 0, ROLLUP_TS_MAX, roomID, 0, ROLLUP_TS_MAX, shift).Scan(&payers).Error
+		if err != nil {
+			return err
+		}
+
+		// daily rollups merged from hourly ones
+		dayRooms := map[int64]*RoomRollup{}
+		hourRooms := make([]RoomRollup, 0, len(rooms))
+		for _, item := range rooms {
+			hourRooms = append(hourRooms, *item)
+			day := utils.DayStart(item.Ts)
+			if _, ok := dayRooms[day]; !ok {
+				dayRooms[day] = &RoomRollup{RoomID: roomID, Unit: ROLLUP_DAY, Ts: day}
+			}
+			dayRooms[day].add(item)
+		}
+		for _, item := range dayRooms {
+			hourRooms = append(hourRooms, *item)
+		}
+
+		dayGifts := map[[2]int64]*GiftRollup{}
+		for _, item := range gifts {
+			key := [2]int64{utils.DayStart(item.Ts), item.GiftID}
+			if _, ok := dayGifts[key]; !ok {
+				dayGifts[key] = &GiftRollup{RoomID: roomID, Unit: ROLLUP_DAY, Ts: key[0], GiftID: item.GiftID}
+			}
+			dayGifts[key].Number += item.Number
+			dayGifts[key].Value += item.Value
+		}
+		for _, item := range dayGifts {
+			gifts = append(gifts, *item)
+		}
+
+		dayPayers := map[[2]int64]*PayerRollup{}
+		for _, item := range payers {
+			key := [2]int64{utils.DayStart(item.Ts), item.UID}
+			if _, ok := dayPayers[key]; !ok {
+				dayPayers[key] = &PayerRollup{RoomID: roomID, Unit: ROLLUP_DAY, Ts: key[0], UID: item.UID}
+			}
+			dayPayers[key].Count += item.Count
+			dayPayers[key].GiftValue += item.GiftValue
+			dayPayers[key].SCValue += item.SCValue
+			dayPayers[key].GuardValue += item.GuardValue
+		}
+		for _, item := range dayPayers {
+			payers = append(payers, *item)
+		}
+
+		if len(hourRooms) > 0 {
+			if err = tx.CreateInBatches(hourRooms, 500).Error; err != nil {
+				return err
+			}
+		}
+		if len(gifts) > 0 {
+			if err = tx.CreateInBatches(gifts, 500).Error; err != nil {
+				return err
+			}
+		}
+		if len(payers) > 0 {
+			if err = tx.CreateInBatches(payers, 500).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
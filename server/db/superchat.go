@@ -3,9 +3,9 @@ package db
 import "github.com/zerozwt/swe"
 
 type SuperChatRecord struct {
-	RoomID     int64  `gorm:"index:idx_sc_room_time;column:room_id" json:"-"`
+	RoomID     int64  `gorm:"index:idx_sc_room_time;index:idx_sc_room_uid;column:room_id" json:"-"`
 	SendTime   int64  `gorm:"index:idx_sc_room_time;column:send_time"`
-	SenderUID  int64  `gorm:"column:sender_uid" json:"-"`
+	SenderUID  int64  `gorm:"index:idx_sc_room_uid;column:sender_uid" json:"-"`
 	SenderName string `gorm:"type:string;size:256;column:sender_name" json:"-"`
 	Price      int64
 	Content    string `gorm:"type:string;size:1024;column:content"`
@@ -76,3 +76,36 @@ func (dal SCDal) Range(ctx *swe.Context, roomID, tsBegin, tsEnd int64) ([]*Super
 	}
 	return ret, err
 }
+
+// UsersRange is Range of senders with uid in [uidFrom, uidTo], ordered by sender & send time
+func (dal SCDal) UsersRange(ctx *swe.Context, roomID, tsBegin, tsEnd, uidFrom, uidTo int64) ([]*SuperChatRecord, error) {
+	tmp := []SuperChatRecord{}
+	tx := getInstance(ctx)
+	tx = tx.Where("room_id = ? and sender_uid between ? and ?", roomID, uidFrom, uidTo)
+	tx = tx.Where("send_time between ? and ?", tsBegin, tsEnd)
+	tx = tx.Order("sender_uid, send_time")
+	err := tx.Find(&tmp).Error
+	ret := make([]*SuperChatRecord, 0, len(tmp))
+	for idx := range tmp {
+		ret = append(ret, &tmp[idx])
+	}
+	return ret, err
+}
+
+func (dal SCDal) UserSummary(ctx *swe.Context, roomID, uid, tsBegin, tsEnd int64) (PaySummary, error) {
+	ret := PaySummary{}
+	tx := getInstance(ctx).Table("t_super_chat").Where("room_id = ? and sender_uid = ?", roomID, uid)
+	tx = tx.Where("send_time between ? and ?", tsBegin, tsEnd)
+	tx = tx.Select("count(*) as ct, coalesce(sum(price), 0) as val, " +
+		"coalesce(min(send_time), 0) as first_ts, coalesce(max(send_time), 0) as last_ts")
+	err := tx.Scan(&ret).Error
+	return ret, err
+}
+
+// Latest lists at most limit sc of room sent from ts on with price at least minPrice, newest first
+func (dal SCDal) Latest(ctx *swe.Context, roomID, ts, minPrice int64, limit int) ([]SuperChatRecord, error) {
+	ret := []SuperChatRecord{}
+	tx := getInstance(ctx).Where("room_id = ? and send_time >= ? and price >= ?", roomID, ts, minPrice)
+	err := tx.Order("send_time desc").Limit(limit).Find(&ret).Error
+	return ret, err
+}
@@ -9,6 +9,7 @@ import (
 
 const (
 	DB_SYSCONF_ADMIN_PASS = "admin_pass"
+	DB_SYSCONF_SENDER_KEY = "sender_key" // legacy, the key is given by config now, see batch_dm.SetServerKey
 )
 
 type SysConfig struct {
@@ -49,6 +50,10 @@ func (dal SysConfigDAL) SetConfig(ctx *swe.Context, key, value string) error {
 	}).Create(&tmp).Error
 }
 
+func (dal SysConfigDAL) DeleteConfig(ctx *swe.Context, key string) error {
+	return getInstance(ctx).Where("key = ?", key).Delete(&SysConfig{}).Error
+}
+
 func (dal SysConfigDAL) EncodeAdminPassword(pass string) string {
 	return utils.EncryptByPass(pass, []byte(pass))
 }
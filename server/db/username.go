@@ -0,0 +1,51 @@
+package db
+
+import (
+	"github.com/zerozwt/swe"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type UserName struct {
+	UID     int64  `gorm:"primaryKey;column:uid"`
+	Name    string `gorm:"primaryKey;type:string;size:256;column:name"`
+	FirstTs int64  `gorm:"column:first_ts"`
+	LastTs  int64  `gorm:"column:last_ts"`
+}
+
+func (s UserName) TableName() string { return "t_user_name" }
+
+func init() {
+	registerModel(&UserName{})
+}
+
+type UserNameDAL struct{}
+
+func GetUserNameDAL() UserNameDAL { return UserNameDAL{} }
+
+// Touch records that uid used name at ts
+func (dal UserNameDAL) Touch(ctx *swe.Context, uid int64, name string, ts int64) error {
+	item := UserName{UID: uid, Name: name, FirstTs: ts, LastTs: ts}
+	return getInstance(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "uid"}, {Name: "name"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"first_ts": gorm.Expr("case when first_ts > ? then ? else first_ts end", ts, ts),
+			"last_ts":  gorm.Expr("case when last_ts < ? then ? else last_ts end", ts, ts),
+		}),
+	}).Create(&item).Error
+}
+
+// BatchHistory returns names used by uids, names of each uid are ordered by last used time desc
+func (dal UserNameDAL) BatchHistory(ctx *swe.Context, uids []int64) (map[int64][]UserName, error) {
+	ret := map[int64][]UserName{}
+	if len(uids) == 0 {
+		return ret, nil
+	}
+
+	tmp := []UserName{}
+	err := getInstance(ctx).Where("uid in ?", uids).Order("last_ts desc").Find(&tmp).Error
+	for _, item := range tmp {
+		ret[item.UID] = append(ret[item.UID], item)
+	}
+	return ret, err
+}
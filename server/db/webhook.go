@@ -0,0 +1,187 @@
+package db
+
+import (
+	"strings"
+
+	"github.com/zerozwt/swe"
+)
+
+// Webhook posts paid events of a room to an outside url, e.g. a chat bot of the team
+type Webhook struct {
+	ID         int64  `gorm:"primaryKey;column:id"`
+	RoomID     int64  `gorm:"column:room_id;index:idx_webhook_room"`
+	Name       string `gorm:"type:string;size:256;column:name"`
+	URL        string `gorm:"type:string;size:1024;column:url"`
+	Secret     string `gorm:"type:string;size:256;column:secret"`
+	Events     string `gorm:"type:string;size:256;column:events"` // WEBHOOK_EVENT_* joined by comma
+	MinSC      int64  `gorm:"column:min_sc"`                      // CNY
+	MinGift    int64  `gorm:"column:min_gift"`                    // gold coins of price * count
+	GuardLevel int    `gorm:"column:guard_level"`                 // highest level number to post, 0 for all
+	Template   string `gorm:"type:string;size:4096;column:template"`
+	Enabled    int    `gorm:"column:enabled"`
+	CreateTime int64  `gorm:"column:create_time"`
+	UpdateTime int64  `gorm:"column:update_time"`
+}
+
+const (
+	WEBHOOK_EVENT_SC    = "sc"
+	WEBHOOK_EVENT_GUARD = "guard"
+	WEBHOOK_EVENT_GIFT  = "gift"
+	WEBHOOK_EVENT_TEST  = "test"
+)
+
+func (s Webhook) TableName() string { return "t_webhook" }
+
+func (s Webhook) HasEvent(event string) bool {
+	for _, item := range strings.Split(s.Events, ",") {
+		if item == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is a payload posted or to be posted to a webhook, and the result of its last attempt
+type WebhookDelivery struct {
+	ID         int64  `gorm:"primaryKey;column:id"`
+	HookID     int64  `gorm:"column:hook_id;index:idx_webhook_delivery"`
+	RoomID     int64  `gorm:"column:room_id"`
+	Event      string `gorm:"type:string;size:32;column:event"`
+	Payload    string `gorm:"type:TEXT;column:payload"`
+	Status     int    `gorm:"column:status"`
+	Attempts   int    `gorm:"column:attempts"`
+	StatusCode int    `gorm:"column:status_code"`
+	Error      string `gorm:"type:string;size:1024;column:error"`
+	TaskID     int64  `gorm:"column:task_id"`
+	CreateTime int64  `gorm:"column:create_time;index:idx_webhook_delivery"`
+	UpdateTime int64  `gorm:"column:update_time"`
+}
+
+const (
+	WEBHOOK_DELIVERY_PENDING = iota + 1 // not posted yet or to be retried
+	WEBHOOK_DELIVERY_DONE
+	WEBHOOK_DELIVERY_FAILED // given up
+)
+
+func (s WebhookDelivery) TableName() string { return "t_webhook_delivery" }
+
+func init() {
+	registerModel(&Webhook{})
+	registerModel(&WebhookDelivery{})
+}
+
+type WebhookDAL struct{}
+
+func GetWebhookDAL() WebhookDAL { return WebhookDAL{} }
+
+func (dal WebhookDAL) Put(ctx *swe.Context, hook *Webhook) error {
+	return getInstance(ctx).Create(hook).Error
+}
+
+// Update changes the definition of a hook of room, returns false if not found
+func (dal WebhookDAL) Update(ctx *swe.Context, hook *Webhook) (bool, error) {
+	result := getInstance(ctx).Exec("update t_webhook set name = ?, url = ?, secret = ?, events = ?, min_sc = ?, "+
+		"min_gift = ?, guard_level = ?, template = ?, enabled = ?, update_time = ? where id = ? and room_id = ?",
+		hook.Name, hook.URL, hook.Secret, hook.Events, hook.MinSC, hook.MinGift, hook.GuardLevel, hook.Template,
+		hook.Enabled, hook.UpdateTime, hook.ID, hook.RoomID)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (dal WebhookDAL) Get(ctx *swe.Context, id int64) (*Webhook, error) {
+	ret := []Webhook{}
+	err := getInstance(ctx).Where("id = ?", id).Find(&ret).Error
+	if len(ret) == 0 {
+		return nil, err
+	}
+	return &ret[0], nil
+}
+
+func (dal WebhookDAL) GetByRoomID(ctx *swe.Context, id, roomID int64) (*Webhook, error) {
+	ret := []Webhook{}
+	err := getInstance(ctx).Where("id = ? and room_id = ?", id, roomID).Find(&ret).Error
+	if len(ret) == 0 {
+		return nil, err
+	}
+	return &ret[0], nil
+}
+
+func (dal WebhookDAL) List(ctx *swe.Context, roomID int64) ([]Webhook, error) {
+	ret := []Webhook{}
+	err := getInstance(ctx).Where("room_id = ?", roomID).Order("id").Find(&ret).Error
+	return ret, err
+}
+
+func (dal WebhookDAL) Enabled(ctx *swe.Context, roomID int64) ([]Webhook, error) {
+	ret := []Webhook{}
+	err := getInstance(ctx).Where("room_id = ? and enabled = 1", roomID).Find(&ret).Error
+	return ret, err
+}
+
+// Delete removes a hook of room with its deliveries, returns false if not found
+func (dal WebhookDAL) Delete(ctx *swe.Context, roomID, id int64) (bool, error) {
+	result := getInstance(ctx).Exec("delete from t_webhook where id = ? and room_id = ?", id, roomID)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	return true, getInstance(ctx).Exec("delete from t_webhook_delivery where hook_id = ?", id).Error
+}
+
+func (dal WebhookDAL) PutDelivery(ctx *swe.Context, delivery *WebhookDelivery) error {
+	return getInstance(ctx).Create(delivery).Error
+}
+
+func (dal WebhookDAL) GetDelivery(ctx *swe.Context, id int64) (*WebhookDelivery, error) {
+	ret := []WebhookDelivery{}
+	err := getInstance(ctx).Where("id = ?", id).Find(&ret).Error
+	if len(ret) == 0 {
+		return nil, err
+	}
+	return &ret[0], nil
+}
+
+// PageDeliveries lists deliveries of a hook, newest first, status 0 for all
+func (dal WebhookDAL) PageDeliveries(ctx *swe.Context, hookID int64, status, offset, limit int) (int, []WebhookDelivery, error) {
+	count := 0
+	ret := []WebhookDelivery{}
+	tx := getInstance(ctx).Table((WebhookDelivery{}).TableName()).Where("hook_id = ?", hookID)
+	if status > 0 {
+		tx = tx.Where("status = ?", status)
+	}
+
+	err := newDBSession(ctx, tx).Select("count(*)").Scan(&count).Error
+	if err != nil {
+		return 0, nil, err
+	}
+
+	err = tx.Offset(offset).Limit(limit).Order("create_time desc, id desc").Find(&ret).Error
+
+	return count, ret, err
+}
+
+func (dal WebhookDAL) SetDeliveryTask(ctx *swe.Context, id, taskID int64) error {
+	return getInstance(ctx).Exec("update t_webhook_delivery set task_id = ? where id = ?", taskID, id).Error
+}
+
+// DeliveryAttempt records the result of posting a delivery
+func (dal WebhookDAL) DeliveryAttempt(ctx *swe.Context, id int64, status, statusCode int, errMsg string, ts int64) error {
+	if len(errMsg) > 1024 {
+		errMsg = strings.ToValidUTF8(errMsg[:1024], "")
+	}
+	return getInstance(ctx).Exec("update t_webhook_delivery set status = ?, status_code = ?, error = ?, "+
+		"attempts = attempts + 1, update_time = ? where id = ?", status, statusCode, errMsg, ts, id).Error
+}
+
+// ResetDelivery makes a delivery pending again from its first attempt, returns false if it is pending already
+func (dal WebhookDAL) ResetDelivery(ctx *swe.Context, id int64, ts int64) (bool, error) {
+	result := getInstance(ctx).Exec("update t_webhook_delivery set status = ?, attempts = 0, status_code = 0, "+
+		"error = '', update_time = ? where id = ? and status <> ?",
+		WEBHOOK_DELIVERY_PENDING, ts, id, WEBHOOK_DELIVERY_PENDING)
+	return result.RowsAffected > 0, result.Error
+}
+
+// PruneDeliveries deletes finished deliveries created before ts
+func (dal WebhookDAL) PruneDeliveries(ctx *swe.Context, ts int64) (int64, error) {
+	result := getInstance(ctx).Exec("delete from t_webhook_delivery where create_time < ? and status <> ?",
+		ts, WEBHOOK_DELIVERY_PENDING)
+	return result.RowsAffected, result.Error
+}
@@ -2,11 +2,14 @@ package handler
 
 import (
 	"fmt"
+	"sort"
 
 	dm "github.com/zerozwt/BLiveDanmaku"
+	"github.com/zerozwt/octant/server/async_task"
 	"github.com/zerozwt/octant/server/bridge"
 	"github.com/zerozwt/octant/server/bs"
 	"github.com/zerozwt/octant/server/db"
+	"github.com/zerozwt/octant/server/handler/batch_dm"
 	"github.com/zerozwt/octant/server/session"
 	"github.com/zerozwt/octant/server/utils"
 	"github.com/zerozwt/swe"
@@ -22,6 +25,17 @@ func init() {
 	registerHandler(POST, "/admin/streamer/add", admin.createStreamer, session.CheckAdmin)
 	registerHandler(POST, "/admin/streamer/delete", admin.deleteStreamer, session.CheckAdmin)
 	registerHandler(POST, "/admin/streamer/reset", admin.resetStreamerPassword, session.CheckAdmin)
+
+	registerHandler(GET, "/admin/task/list", admin.taskList, session.CheckAdmin)
+	registerHandler(GET, "/admin/task/detail", admin.taskDetail, session.CheckAdmin)
+	registerHandler(POST, "/admin/task/cancel", admin.cancelTask, session.CheckAdmin)
+	registerHandler(POST, "/admin/task/requeue", admin.requeueTask, session.CheckAdmin)
+	registerHandler(GET, "/admin/task/stats", admin.taskStats, session.CheckAdmin)
+
+	registerHandler(GET, "/admin/cron/list", admin.cronList, session.CheckAdmin)
+	registerHandler(POST, "/admin/cron/enable", admin.enableCron, session.CheckAdmin)
+
+	registerHandler(GET, "/admin/dm/mock", admin.dmMockMessages, session.CheckAdmin)
 }
 
 type adminHandler struct{}
@@ -200,3 +214,155 @@ func (ins adminHandler) resetStreamerPassword(ctx *swe.Context, req *bs.AdminRes
 
 	return &bs.Nothing{}, nil
 }
+
+func (ins adminHandler) taskItem(task *db.AsyncTask) bs.AdminTaskItem {
+	ret := bs.AdminTaskItem{
+		ID:        task.ID,
+		Handler:   task.Handler,
+		Param:     task.Param,
+		Status:    task.Status,
+		Schedule:  utils.TimeToLocalString(task.Schedule),
+		Attempts:  task.Attempts,
+		LastError: task.LastError,
+	}
+	if task.CreateTime > 0 {
+		ret.CreateTime = utils.TimeToLocalString(task.CreateTime)
+	}
+	if task.UpdateTime > 0 {
+		ret.UpdateTime = utils.TimeToLocalString(task.UpdateTime)
+	}
+	return ret
+}
+
+func (ins adminHandler) taskList(ctx *swe.Context, req *bs.AdminTaskListReq) (*bs.PageRsp, swe.SweError) {
+	count, tasks, err := db.GetAsyncTaskDAL().Page(ctx, req.Handler, req.Status, (req.Page-1)*req.Size, req.Size)
+	if err != nil {
+		swe.CtxLogger(ctx).Error("query db error %v", err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+
+	ret := &bs.PageRsp{Count: count, List: []any{}}
+	for idx := range tasks {
+		ret.List = append(ret.List, ins.taskItem(&tasks[idx]))
+	}
+	return ret, nil
+}
+
+func (ins adminHandler) findTask(ctx *swe.Context, id int64) (*db.AsyncTask, swe.SweError) {
+	task, err := db.GetAsyncTaskDAL().Get(ctx, id)
+	if err != nil {
+		swe.CtxLogger(ctx).Error("query async task %d error %v", id, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	if task == nil {
+		return nil, swe.Error(EC_ADMIN_TASK_NOT_FOUND, fmt.Errorf("task %d not found", id))
+	}
+	return task, nil
+}
+
+func (ins adminHandler) taskDetail(ctx *swe.Context, req *bs.IDReq) (*bs.AdminTaskItem, swe.SweError) {
+	task, err := ins.findTask(ctx, req.ID)
+	if err != nil {
+		return nil, err
+	}
+	ret := ins.taskItem(task)
+	return &ret, nil
+}
+
+func (ins adminHandler) cancelTask(ctx *swe.Context, req *bs.IDReq) (*bs.Nothing, swe.SweError) {
+	if _, err := ins.findTask(ctx, req.ID); err != nil {
+		return nil, err
+	}
+	if err := async_task.GetScheduler().Cancel(ctx, req.ID); err != nil {
+		swe.CtxLogger(ctx).Error("cancel async task %d failed: %v", req.ID, err)
+		return nil, swe.Error(EC_ADMIN_TASK_STATE_INVALID, err)
+	}
+	return &bs.Nothing{}, nil
+}
+
+func (ins adminHandler) requeueTask(ctx *swe.Context, req *bs.IDReq) (*bs.Nothing, swe.SweError) {
+	if _, err := ins.findTask(ctx, req.ID); err != nil {
+		return nil, err
+	}
+	if err := async_task.GetScheduler().Requeue(ctx, req.ID); err != nil {
+		swe.CtxLogger(ctx).Error("requeue async task %d failed: %v", req.ID, err)
+		return nil, swe.Error(EC_ADMIN_TASK_STATE_INVALID, err)
+	}
+	return &bs.Nothing{}, nil
+}
+
+// taskStats shows tasks queued & running on the instance serving the request
+func (ins adminHandler) taskStats(ctx *swe.Context, req *bs.Nothing) (*bs.AdminTaskStatsRsp, swe.SweError) {
+	stats := async_task.GetScheduler().Stats()
+	ret := &bs.AdminTaskStatsRsp{
+		Workers:  stats.Workers,
+		Running:  stats.Running,
+		Ready:    stats.Ready,
+		Waiting:  stats.Waiting,
+		Handlers: []bs.AdminTaskHandlerStats{},
+	}
+	for name, item := range stats.Handlers {
+		ret.Handlers = append(ret.Handlers, bs.AdminTaskHandlerStats{
+			Handler: name,
+			Limit:   item.Limit,
+			Running: item.Running,
+			Ready:   item.Ready,
+			Waiting: item.Waiting,
+		})
+	}
+	sort.Slice(ret.Handlers, func(i, j int) bool { return ret.Handlers[i].Handler < ret.Handlers[j].Handler })
+	return ret, nil
+}
+
+func (ins adminHandler) cronList(ctx *swe.Context, req *bs.Nothing) (*bs.PageRsp, swe.SweError) {
+	jobs, err := db.GetCronJobDAL().All(ctx)
+	if err != nil {
+		swe.CtxLogger(ctx).Error("query db error %v", err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+
+	ret := &bs.PageRsp{Count: len(jobs), List: []any{}}
+	for _, item := range jobs {
+		tmp := bs.AdminCronItem{
+			Name:       item.Name,
+			Spec:       item.Spec,
+			Handler:    item.Handler,
+			Param:      item.Param,
+			Catchup:    item.Catchup,
+			Overlap:    item.Overlap,
+			Enabled:    item.Enabled != 0,
+			LastTaskID: item.LastTaskID,
+		}
+		if item.NextRun > 0 {
+			tmp.NextRun = utils.TimeToLocalString(item.NextRun)
+		}
+		if item.LastRun > 0 {
+			tmp.LastRun = utils.TimeToLocalString(item.LastRun)
+		}
+		ret.List = append(ret.List, tmp)
+	}
+	return ret, nil
+}
+
+func (ins adminHandler) enableCron(ctx *swe.Context, req *bs.AdminCronEnableReq) (*bs.Nothing, swe.SweError) {
+	if err := async_task.SetCronEnabled(ctx, req.Name, req.Enabled); err != nil {
+		swe.CtxLogger(ctx).Error("set cron job %s enabled %v failed: %v", req.Name, req.Enabled, err)
+		return nil, swe.Error(EC_ADMIN_CRON_NOT_FOUND, err)
+	}
+	return &bs.Nothing{}, nil
+}
+
+// dmMockMessages lists messages delivered lately by a mock dm transport
+func (ins adminHandler) dmMockMessages(ctx *swe.Context, req *bs.AdminDMMockReq) (*bs.PageRsp, swe.SweError) {
+	messages, ok := batch_dm.MockMessages(req.Name)
+	if !ok {
+		swe.CtxLogger(ctx).Error("mock dm transport %s not found", req.Name)
+		return nil, swe.Error(EC_DM_TRANSPORT_NOT_FOUND, batch_dm.ErrTransportNotFound)
+	}
+
+	ret := &bs.PageRsp{Count: len(messages), List: []any{}}
+	for _, item := range messages {
+		ret.List = append(ret.List, item)
+	}
+	return ret, nil
+}
@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zerozwt/octant/server/bs"
+	"github.com/zerozwt/octant/server/db"
+	"github.com/zerozwt/octant/server/session"
+	"github.com/zerozwt/octant/server/utils"
+	"github.com/zerozwt/swe"
+)
+
+func init() {
+	registerHandler(POST, "/analytics/revenue", analytics.revenue, session.CheckStreamer)
+	registerHandler(POST, "/analytics/top_payers", analytics.topPayers, session.CheckStreamer)
+	registerHandler(POST, "/analytics/hours", analytics.hours, session.CheckStreamer)
+}
+
+type analyticsHandler struct{}
+
+var analytics analyticsHandler
+
+func (ins analyticsHandler) revenue(ctx *swe.Context, req *bs.AnalyticsReq) (*bs.AnalyticsRevenueRsp, swe.SweError) {
+	if err := req.ParseTimeRange(); err != nil {
+		return nil, swe.Error(EC_ST_BAD_TIMESTAMP, err)
+	}
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	buckets := utils.TimeBuckets(req.StartTs(), req.EndTs(), req.TimeUnit())
+	if len(buckets) > db.MAX_ANALYTICS_BUCKETS {
+		return nil, swe.Error(EC_ST_RANGE_TOO_LARGE, fmt.Errorf("too many %ss in time range", req.TimeUnit()))
+	}
+
+	ret := &bs.AnalyticsRevenueRsp{
+		Timezone: utils.LocalLocation().String(),
+		Unit:     req.TimeUnit(),
+		List:     make([]bs.AnalyticsRevenueItem, 0, len(buckets)),
+	}
+	for _, item := range buckets {
+		ret.List = append(ret.List, bs.AnalyticsRevenueItem{
+			Time:   utils.TimeToLocalString(item[0]),
+			Gifts:  []bs.AnalyticsGiftItem{},
+			Guards: []bs.AnalyticsGuardItem{},
+		})
+	}
+
+	// labels use whole units, buckets at both ends are narrowed to the requested range. Rollups are read
+	// by whole hours, so records in the same hour as start or end are counted even if out of range.
+	buckets[0][0] = req.StartTs()
+	buckets[len(buckets)-1][1] = req.EndTs()
+
+	dal := db.GetAnalyticsDAL()
+	rooms, err := dal.RoomByBucket(ctx, st.RoomID, buckets)
+	if err != nil {
+		logger.Error("query revenue stats of room %d error %v", st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	for _, item := range rooms {
+		bucket := &ret.List[item.Bucket]
+		bucket.GiftNumber = item.GiftNumber
+		bucket.GiftValue = item.GiftValue
+		bucket.SCCount = item.SCCount
+		bucket.SCValue = item.SCValue
+		for level, guard := range [][2]int64{
+			{item.Guard1Count, item.Guard1Months},
+			{item.Guard2Count, item.Guard2Months},
+			{item.Guard3Count, item.Guard3Months},
+		} {
+			if guard[0] == 0 {
+				continue
+			}
+			value := guard[1] * db.GuardPrice(level+1)
+			bucket.GuardCount += guard[0]
+			bucket.GuardValue += value
+			bucket.Guards = append(bucket.Guards, bs.AnalyticsGuardItem{
+				Level:  level + 1,
+				Count:  guard[0],
+				Months: guard[1],
+				Value:  value,
+			})
+		}
+	}
+
+	gifts, err := dal.GiftByBucket(ctx, st.RoomID, buckets)
+	if err != nil {
+		logger.Error("query gift stats of room %d error %v", st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	for _, item := range gifts {
+		bucket := &ret.List[item.Bucket]
+		bucket.Gifts = append(bucket.Gifts, bs.AnalyticsGiftItem{
+			GiftID:   item.GiftID,
+			GiftName: item.GiftName,
+			Number:   item.Number,
+			Value:    item.Value,
+		})
+	}
+
+	payers, err := dal.PayersByBucket(ctx, st.RoomID, buckets)
+	if err != nil {
+		logger.Error("query payer stats of room %d error %v", st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	for _, item := range payers {
+		ret.List[item.Bucket].Payers = item.Payers
+	}
+
+	return ret, nil
+}
+
+func (ins analyticsHandler) topPayers(ctx *swe.Context, req *bs.AnalyticsReq) (*bs.PageRsp, swe.SweError) {
+	if err := req.ParseTimeRange(); err != nil {
+		return nil, swe.Error(EC_ST_BAD_TIMESTAMP, err)
+	}
+	st, _ := session.GetStreamerSession(ctx)
+
+	list, err := db.GetAnalyticsDAL().TopPayers(ctx, st.RoomID, req.StartTs(), req.EndTs(), req.TopN())
+	if err != nil {
+		swe.CtxLogger(ctx).Error("query top payers of room %d error %v", st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+
+	uids := make([]int64, 0, len(list))
+	for _, item := range list {
+		uids = append(uids, item.UID)
+	}
+	names, err := loadNameHistory(ctx, uids)
+	if err != nil {
+		swe.CtxLogger(ctx).Error("query name history error %v", err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+
+	ret := &bs.PageRsp{Count: len(list), List: []any{}}
+	for _, item := range list {
+		payer := bs.AnalyticsPayerItem{
+			UID:        item.UID,
+			GiftValue:  item.Gift,
+			SCValue:    item.SC,
+			GuardValue: item.Guard,
+		}
+		if len(names[item.UID]) > 0 {
+			payer.Name = names[item.UID][0].Name
+		}
+		ret.List = append(ret.List, payer)
+	}
+	return ret, nil
+}
+
+func (ins analyticsHandler) hours(ctx *swe.Context, req *bs.AnalyticsReq) (*bs.PageRsp, swe.SweError) {
+	if err := req.ParseTimeRange(); err != nil {
+		return nil, swe.Error(EC_ST_BAD_TIMESTAMP, err)
+	}
+	st, _ := session.GetStreamerSession(ctx)
+
+	list, err := db.GetAnalyticsDAL().Hours(ctx, st.RoomID, req.StartTs(), req.EndTs())
+	if err != nil {
+		swe.CtxLogger(ctx).Error("query hour stats of room %d error %v", st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+
+	// map hour rollups to local hour of day here so daylight saving is respected
+	hours := make([]bs.AnalyticsHourItem, 24)
+	for idx := range hours {
+		hours[idx].Hour = idx
+	}
+	for _, item := range list {
+		hour := &hours[time.Unix(item.Hour, 0).In(utils.LocalLocation()).Hour()]
+		hour.Count += item.Count
+		hour.GiftValue += item.Gift
+		hour.SCValue += item.SC
+		hour.GuardValue += item.Guard
+	}
+
+	ret := &bs.PageRsp{Count: len(hours), List: []any{}}
+	for _, item := range hours {
+		ret.List = append(ret.List, item)
+	}
+	return ret, nil
+}
@@ -0,0 +1,217 @@
+package batch_dm
+
+import (
+	"fmt"
+	"time"
+
+	dm "github.com/zerozwt/BLiveDanmaku"
+	"github.com/zerozwt/octant/server/bs"
+	"github.com/zerozwt/octant/server/db"
+	"github.com/zerozwt/swe"
+)
+
+var ErrNoAccount error = fmt.Errorf("no sender account available")
+
+// an account in pool failed for auth or rate limit this many times in a row is removed from pool
+const DM_ACCOUNT_MAX_FAILS = 3
+
+// sendAccount is an account an executor sends with, either the sender of task or one in pool
+type sendAccount struct {
+	id       int64 // id of db.DMAccount, 0 for the sender of task
+	info     *bs.DMSenderInfo
+	interval time.Duration
+	checked  bool
+}
+
+func (m *manager) AddAccount(ctx *swe.Context, roomID int64, sender *bs.DMSenderInfo, interval int) error {
+	credential, err := sealSender(ctx, roomID, sender)
+	if err != nil {
+		return err
+	}
+	if interval <= 0 {
+		interval = DM_ACCOUNT_DEFAULT_INTERVAL
+	}
+
+	now := time.Now().Unix()
+	return db.GetDMAccountDAL().Put(ctx, &db.DMAccount{
+		RoomID:     roomID,
+		UID:        sender.UID,
+		Credential: credential,
+		ExpireTime: SessDataExpire(sender.SessData),
+		Interval:   interval,
+		Status:     db.DM_ACCOUNT_ACTIVE,
+		CreateTime: now,
+		UpdateTime: now,
+	})
+}
+
+// loadAccounts returns accounts task sends with by its sender policy
+func (m *manager) loadAccounts(ctx *swe.Context, task *db.DMTask) ([]*sendAccount, error) {
+	if task.SenderPolicy == db.DM_POLICY_SINGLE {
+		info, err := m.loadSender(ctx, task.ID)
+		if err != nil {
+			return nil, err
+		}
+		return []*sendAccount{{info: info, interval: DM_ACCOUNT_DEFAULT_INTERVAL * time.Second}}, nil
+	}
+
+	logger := swe.CtxLogger(ctx)
+	dal := db.GetDMAccountDAL()
+
+	accounts, err := dal.Active(ctx, task.RoomID)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []*sendAccount{}
+	now := time.Now().Unix()
+	for _, item := range accounts {
+		if item.ExpireTime > 0 && item.ExpireTime <= now {
+			logger.Warn("dm account %d of room %d expired, removed from pool", item.UID, item.RoomID)
+			dal.SetStatus(ctx, item.RoomID, item.ID, db.DM_ACCOUNT_REMOVED, ErrSenderExpired.Error(), now)
+			continue
+		}
+		info, err := openSender(ctx, item.RoomID, item.Credential)
+		if err == ErrCredentialUnreadable {
+			logger.Warn("dm account %d of room %d unreadable, removed from pool", item.UID, item.RoomID)
+			dal.SetStatus(ctx, item.RoomID, item.ID, db.DM_ACCOUNT_REMOVED, err.Error(), now)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		limiter.seen(item.UID, time.Unix(item.LastUsed, 0))
+		ret = append(ret, &sendAccount{
+			id:       item.ID,
+			info:     info,
+			interval: time.Duration(item.Interval) * time.Second,
+		})
+	}
+
+	if len(ret) == 0 {
+		return nil, ErrNoAccount
+	}
+	return ret, nil
+}
+
+// pick returns the account to send next message with, nil if no account left
+func (e *executor) pick() *sendAccount {
+	if len(e.accounts) == 0 {
+		return nil
+	}
+
+	if e.task.SenderPolicy == db.DM_POLICY_LRU {
+		ret := e.accounts[0]
+		for _, item := range e.accounts[1:] {
+			if limiter.lastUsed(item.info.UID).Before(limiter.lastUsed(ret.info.UID)) {
+				ret = item
+			}
+		}
+		return ret
+	}
+
+	e.next %= len(e.accounts)
+	ret := e.accounts[e.next]
+	e.next++
+	return ret
+}
+
+func (e *executor) drop(acc *sendAccount) {
+	for idx, item := range e.accounts {
+		if item == acc {
+			e.accounts = append(e.accounts[:idx], e.accounts[idx+1:]...)
+			return
+		}
+	}
+}
+
+// accountFailed handles a message rejected for the account it is sent with,
+// returns true if the message can be sent again with another account
+func (e *executor) accountFailed(ctx *swe.Context, acc *sendAccount, rsp *dm.SendDirectMsgRsp, err error) bool {
+	logger := swe.CtxLogger(ctx)
+	now := time.Now().Unix()
+
+	if isRateLimited(rsp) {
+		limiter.penalize(acc.info.UID, DM_RATE_LIMIT_PENALTY)
+	}
+
+	if acc.id == 0 {
+		if isAuthError(rsp) {
+			// no message can be sent with this sender, the streamer has to set a new one
+			logger.Error("sender of direct msg task %d rejected: %v", e.taskID, err)
+			db.GetDMSenderDAL().SetStatus(ctx, e.taskID, db.DM_SENDER_INVALID, err.Error(), now)
+			e.drop(acc)
+		}
+		return false
+	}
+
+	removed, dbErr := db.GetDMAccountDAL().Fail(ctx, acc.id, err.Error(), DM_ACCOUNT_MAX_FAILS, now)
+	if dbErr != nil {
+		logger.Error("count failure of dm account %d failed: %v", acc.info.UID, dbErr)
+	}
+	if removed {
+		logger.Warn("dm account %d failed %d times in a row, removed from pool", acc.info.UID, DM_ACCOUNT_MAX_FAILS)
+	}
+	if removed || isAuthError(rsp) {
+		e.drop(acc)
+	}
+	return len(e.accounts) > 0
+}
+
+func (e *executor) accountSucceed(ctx *swe.Context, acc *sendAccount) {
+	now := time.Now().Unix()
+	if acc.id > 0 {
+		db.GetDMAccountDAL().Succeed(ctx, acc.id, now)
+		return
+	}
+	if !acc.checked {
+		acc.checked = true
+		db.GetDMSenderDAL().SetStatus(ctx, e.taskID, db.DM_SENDER_VALID, "", now)
+	}
+}
+
+// ErrAccountBusy is returned by TestSend instead of waiting for the account to send
+var ErrAccountBusy error = fmt.Errorf("account busy")
+
+func (m *manager) TestSend(ctx *swe.Context, task *db.DMTask, sender *bs.DMSenderInfo, receiver int64, content string) (int64, int64, error) {
+	transport, err := getTransport(task.Transport)
+	if err != nil {
+		return 0, 0, err
+	}
+	exe := &executor{
+		taskID:    task.ID,
+		task:      task,
+		accounts:  []*sendAccount{{info: sender, interval: DM_ACCOUNT_DEFAULT_INTERVAL * time.Second}},
+		transport: transport,
+	}
+	if task.SenderPolicy != db.DM_POLICY_SINGLE {
+		accounts, err := m.loadAccounts(ctx, exe.task)
+		if err != nil {
+			return 0, 0, err
+		}
+		exe.accounts = accounts
+	}
+	acc := exe.pick()
+	if receiver == 0 {
+		receiver = acc.info.UID
+	}
+
+	if exe.devID, err = dm.GetDMDeviceID(); err != nil {
+		return 0, 0, err
+	}
+
+	if wait, ok := limiter.take(acc.info.UID, acc.interval); !ok {
+		return acc.info.UID, receiver, fmt.Errorf("%w, retry in %ds", ErrAccountBusy, int64(wait.Seconds())+1)
+	}
+	rsp, err := exe.send(ctx, acc, receiver, content)
+	if err = exe.processDirectMsgRsp(rsp, err); err != nil {
+		if isRateLimited(rsp) {
+			limiter.penalize(acc.info.UID, DM_RATE_LIMIT_PENALTY)
+		}
+		return acc.info.UID, receiver, err
+	}
+	if acc.id > 0 {
+		db.GetDMAccountDAL().Succeed(ctx, acc.id, time.Now().Unix())
+	}
+	return acc.info.UID, receiver, nil
+}
@@ -0,0 +1,189 @@
+package batch_dm
+
+import (
+	"crypto/ecdh"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/zerozwt/octant/server/bs"
+	"github.com/zerozwt/octant/server/db"
+	"github.com/zerozwt/octant/server/utils"
+	"github.com/zerozwt/swe"
+)
+
+// Sender cookies are sealed with a key agreed between a server key and the public key of the
+// streamer, so that they can be read back to resume tasks without the streamer's password, and
+// become unreadable once the streamer's key pair is reset.
+//
+// The server key comes from config or env, never from db: a leaked db or backup alone does not
+// reveal cookies, but whoever reads both the db and the config of a core instance still can, as
+// core must read cookies without the streamer around. Core instances sharing a db must share the
+// key. Without a key configured, a key of this process is used, cookies sealed with it can not be
+// read after restart, and tasks ask for their sender again.
+
+var ErrCredentialUnreadable error = fmt.Errorf("sender credential can not be decrypted")
+
+var serverKey []byte
+
+// SetServerKey sets the base64 encoded X25519 private key sealing sender cookies, empty for a key of
+// this process. Cookies sealed with the key older versions kept in db are sealed again with this key,
+// then the old key is deleted from db, unless no key is configured.
+func SetServerKey(ctx *swe.Context, value string) error {
+	logger := swe.CtxLogger(ctx)
+	legacy, err := db.GetSysConfigDAL().GetConfig(ctx, db.DB_SYSCONF_SENDER_KEY)
+	if err != nil {
+		return err
+	}
+
+	if len(value) == 0 {
+		if len(legacy) > 0 {
+			logger.Error("dm sender key is still read from db, configure dm_sender_key to move it out")
+			value = legacy
+		} else {
+			logger.Warn("no dm sender key configured, sender cookies can not be read after restart")
+			priKey, _, err := utils.GenerateECDHKeyPair()
+			if err != nil {
+				return err
+			}
+			value = utils.Base64Encode(priKey)
+		}
+	}
+
+	key, err := utils.Base64Decode(value)
+	if err != nil {
+		return fmt.Errorf("decode dm sender key failed: %v", err)
+	}
+	if _, err = ecdh.X25519().NewPrivateKey(key); err != nil {
+		return fmt.Errorf("invalid dm sender key: %v", err)
+	}
+	serverKey = key
+
+	if len(legacy) == 0 || legacy == value {
+		return nil
+	}
+	oldKey, err := utils.Base64Decode(legacy)
+	if err != nil {
+		return err
+	}
+	if err = reseal(ctx, oldKey); err != nil {
+		return err
+	}
+	logger.Info("sender cookies sealed again with the configured dm sender key")
+	return db.GetSysConfigDAL().DeleteConfig(ctx, db.DB_SYSCONF_SENDER_KEY)
+}
+
+// reseal seals cookies sealed with oldKey again with the server key, cookies unreadable with oldKey,
+// e.g. sealed again already, are left as they are
+func reseal(ctx *swe.Context, oldKey []byte) error {
+	convert := func(roomID int64, credential string) (string, bool) {
+		if len(credential) == 0 {
+			return "", false
+		}
+		key, err := credentialKey(ctx, oldKey, roomID)
+		if err != nil {
+			return "", false
+		}
+		data, err := utils.Open(key, credential)
+		if err != nil {
+			return "", false
+		}
+		if key, err = credentialKey(ctx, serverKey, roomID); err != nil {
+			return "", false
+		}
+		ret, err := utils.Seal(key, data)
+		return ret, err == nil
+	}
+
+	senders, err := db.GetDMSenderDAL().Sealed(ctx)
+	if err != nil {
+		return err
+	}
+	for _, item := range senders {
+		if credential, ok := convert(item.RoomID, item.Credential); ok {
+			if err = db.GetDMSenderDAL().SetCredential(ctx, item.TaskID, credential); err != nil {
+				return err
+			}
+		}
+	}
+
+	accounts, err := db.GetDMAccountDAL().Sealed(ctx)
+	if err != nil {
+		return err
+	}
+	for _, item := range accounts {
+		if credential, ok := convert(item.RoomID, item.Credential); ok {
+			if err = db.GetDMAccountDAL().SetCredential(ctx, item.ID, credential); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func credentialKey(ctx *swe.Context, priKey []byte, roomID int64) ([]byte, error) {
+	if priKey == nil {
+		return nil, fmt.Errorf("dm sender key not set")
+	}
+	streamer, err := db.GetStreamerDAL().Find(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if streamer == nil {
+		return nil, fmt.Errorf("streamer of room %d not found", roomID)
+	}
+	pubKey, err := utils.Base64Decode(streamer.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return utils.ECDH(priKey, pubKey)
+}
+
+func sealSender(ctx *swe.Context, roomID int64, sender *bs.DMSenderInfo) (string, error) {
+	key, err := credentialKey(ctx, serverKey, roomID)
+	if err != nil {
+		return "", err
+	}
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	data, _ := json.Marshal(sender)
+	return utils.Seal(key, data)
+}
+
+func openSender(ctx *swe.Context, roomID int64, credential string) (*bs.DMSenderInfo, error) {
+	key, err := credentialKey(ctx, serverKey, roomID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := utils.Open(key, credential)
+	if err != nil {
+		return nil, ErrCredentialUnreadable
+	}
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	ret := &bs.DMSenderInfo{}
+	err = json.Unmarshal(data, ret)
+	return ret, err
+}
+
+// SessDataExpire reads expire time from SESSDATA cookie like "0a1b2c3d%2C1700000000%2Cabcde*11",
+// returns 0 if it is not in this format
+func SessDataExpire(sessData string) int64 {
+	if value, err := url.QueryUnescape(sessData); err == nil {
+		sessData = value
+	}
+	parts := strings.Split(sessData, ",")
+	if len(parts) < 3 {
+		return 0
+	}
+	ts, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return ts
+}
+
+func senderExpired(sender *db.DMSender) bool {
+	return sender.ExpireTime > 0 && sender.ExpireTime <= time.Now().Unix()
+}
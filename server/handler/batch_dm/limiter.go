@@ -0,0 +1,76 @@
+package batch_dm
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// seconds between two messages sent with the same account, by all tasks running in this process
+	DM_ACCOUNT_DEFAULT_INTERVAL = 10
+	// an account told to send slower is not used for this long
+	DM_RATE_LIMIT_PENALTY = 60 * time.Second
+)
+
+// accountLimiter spaces messages sent with the same account, so that tasks sharing an
+// account do not get it rate limited
+type accountLimiter struct {
+	lock sync.Mutex
+	next map[int64]time.Time // earliest time an account can send again
+	used map[int64]time.Time
+}
+
+var limiter *accountLimiter = &accountLimiter{
+	next: map[int64]time.Time{},
+	used: map[int64]time.Time{},
+}
+
+// wait reserves the next slot of account uid and sleeps until it comes
+func (l *accountLimiter) wait(uid int64, interval time.Duration) {
+	l.lock.Lock()
+	slot := time.Now()
+	if next, ok := l.next[uid]; ok && next.After(slot) {
+		slot = next
+	}
+	l.next[uid] = slot.Add(interval)
+	l.used[uid] = slot
+	l.lock.Unlock()
+
+	time.Sleep(time.Until(slot))
+}
+
+// take reserves the current slot of account uid if it is free, or returns how long until the next slot
+func (l *accountLimiter) take(uid int64, interval time.Duration) (time.Duration, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	if next, ok := l.next[uid]; ok && next.After(now) {
+		return next.Sub(now), false
+	}
+	l.next[uid] = now.Add(interval)
+	l.used[uid] = now
+	return 0, true
+}
+
+func (l *accountLimiter) penalize(uid int64, d time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if next := time.Now().Add(d); next.After(l.next[uid]) {
+		l.next[uid] = next
+	}
+}
+
+func (l *accountLimiter) lastUsed(uid int64) time.Time {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.used[uid]
+}
+
+// seen records a use of account uid before this process started
+func (l *accountLimiter) seen(uid int64, ts time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if ts.After(l.used[uid]) {
+		l.used[uid] = ts
+	}
+}
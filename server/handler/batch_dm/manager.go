@@ -8,6 +8,7 @@ import (
 	"time"
 
 	dm "github.com/zerozwt/BLiveDanmaku"
+	"github.com/zerozwt/octant/server/async_task"
 	"github.com/zerozwt/octant/server/bs"
 	"github.com/zerozwt/octant/server/db"
 	"github.com/zerozwt/octant/server/utils"
@@ -15,12 +16,23 @@ import (
 )
 
 var ErrSenderNotSet error = fmt.Errorf("sender info not set")
+var ErrSenderInvalid error = fmt.Errorf("sender info invalid")
+var ErrSenderExpired error = fmt.Errorf("sender info expired")
 var ErrTaskNotFound error = fmt.Errorf("task not found")
 
 type Manager interface {
-	StartTask(ctx *swe.Context, id int64) error
+	// StartTask runs task in mode db.DM_SEND_*, 0 keeps the mode of its last run
+	StartTask(ctx *swe.Context, id int64, mode int) error
 	StopTask(id int64) error
-	SetSenderInfo(id int64, sender *bs.DMSenderInfo) error
+	SetSenderInfo(ctx *swe.Context, id int64, sender *bs.DMSenderInfo) error
+	// TestSend sends one message to receiver, or to the account sent with if receiver is 0, as task would
+	// with its sender policy and transport. sender is used if it sends with a sender of its own. It returns
+	// uid of the account sent with and the receiver, or ErrAccountBusy at once if the account can not send now.
+	TestSend(ctx *swe.Context, task *db.DMTask, sender *bs.DMSenderInfo, receiver int64, content string) (int64, int64, error)
+	// AddAccount puts an account into the sender pool of a streamer, interval is seconds between messages
+	AddAccount(ctx *swe.Context, roomID int64, sender *bs.DMSenderInfo, interval int) error
+	// Resume restarts tasks left running by last process, or pauses them if they can not run
+	Resume(ctx *swe.Context) error
 }
 
 func GetManager() Manager {
@@ -28,31 +40,70 @@ func GetManager() Manager {
 }
 
 type manager struct {
-	taskSender map[int64]*bs.DMSenderInfo
-	tasks      map[int64]*executor
+	tasks map[int64]*executor
 
 	lock sync.Mutex
 }
 
 var gm *manager = &manager{
-	taskSender: map[int64]*bs.DMSenderInfo{},
-	tasks:      map[int64]*executor{},
+	tasks: map[int64]*executor{},
 }
 
-func (m *manager) SetSenderInfo(id int64, sender *bs.DMSenderInfo) error {
-	m.lock.Lock()
-	defer m.lock.Unlock()
+func (m *manager) SetSenderInfo(ctx *swe.Context, id int64, sender *bs.DMSenderInfo) error {
+	task, err := db.GetDirectMsgDAL().Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if task == nil {
+		return ErrTaskNotFound
+	}
 
-	m.taskSender[id] = &bs.DMSenderInfo{
-		UID:      sender.UID,
-		SessData: sender.SessData,
-		JCT:      sender.JCT,
+	credential, err := sealSender(ctx, task.RoomID, sender)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return db.GetDMSenderDAL().Put(ctx, &db.DMSender{
+		TaskID:     id,
+		RoomID:     task.RoomID,
+		SenderUID:  sender.UID,
+		Credential: credential,
+		ExpireTime: SessDataExpire(sender.SessData),
+		Status:     db.DM_SENDER_UNKNOWN,
+		UpdateTime: time.Now().Unix(),
+	})
+}
+
+// loadSender reads sender of a task back from db
+func (m *manager) loadSender(ctx *swe.Context, id int64) (*bs.DMSenderInfo, error) {
+	sender, err := db.GetDMSenderDAL().Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if sender == nil {
+		return nil, ErrSenderNotSet
+	}
+	if sender.Status == db.DM_SENDER_INVALID {
+		return nil, ErrSenderInvalid
+	}
+	if senderExpired(sender) {
+		return nil, ErrSenderExpired
+	}
+
+	info, err := openSender(ctx, sender.RoomID, sender.Credential)
+	if err == ErrCredentialUnreadable {
+		db.GetDMSenderDAL().SetStatus(ctx, id, db.DM_SENDER_INVALID, err.Error(), time.Now().Unix())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Validate(ctx) != nil {
+		return nil, ErrSenderNotSet
+	}
+	return info, nil
 }
 
-func (m *manager) StartTask(ctx *swe.Context, id int64) error {
+func (m *manager) StartTask(ctx *swe.Context, id int64, mode int) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
@@ -60,8 +111,22 @@ func (m *manager) StartTask(ctx *swe.Context, id int64) error {
 		return nil
 	}
 
-	if info, ok := m.taskSender[id]; !ok || info.Validate(ctx) != nil {
-		return ErrSenderNotSet
+	task, err := db.GetDirectMsgDAL().Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if task == nil {
+		return ErrTaskNotFound
+	}
+
+	accounts, err := m.loadAccounts(ctx, task)
+	if err != nil {
+		return err
+	}
+
+	transport, err := getTransport(task.Transport)
+	if err != nil {
+		return err
 	}
 
 	devID, err := dm.GetDMDeviceID()
@@ -70,17 +135,41 @@ func (m *manager) StartTask(ctx *swe.Context, id int64) error {
 	}
 
 	exe := &executor{
-		taskID: id,
-		sender: m.taskSender[id],
-		devID:  devID,
+		taskID:    id,
+		mode:      mode,
+		task:      task,
+		accounts:  accounts,
+		transport: transport,
+		devID:     devID,
 	}
 
-	if err := exe.start(ctx); err != nil {
+	running, err := exe.start(ctx)
+	if err != nil {
 		return err
 	}
+	if running {
+		m.tasks[id] = exe
+	}
 
-	m.tasks[id] = exe
+	return nil
+}
+
+func (m *manager) Resume(ctx *swe.Context) error {
+	logger := swe.CtxLogger(ctx)
+
+	tasks, err := db.GetDirectMsgDAL().ListByStatus(ctx, db.DM_TASK_STATUS_RUNNING)
+	if err != nil {
+		return err
+	}
 
+	for _, item := range tasks {
+		if err := m.StartTask(ctx, item.ID, 0); err != nil {
+			logger.Error("resume direct msg task %d failed: %v, paused", item.ID, err)
+			db.GetDirectMsgDAL().UpdateStatus(ctx, item.ID, db.DM_TASK_STATUS_PAUSED)
+			continue
+		}
+		logger.Info("direct msg task %d resumed", item.ID)
+	}
 	return nil
 }
 
@@ -97,32 +186,42 @@ func (m *manager) StopTask(id int64) error {
 }
 
 type executor struct {
-	taskID int64
-	sender *bs.DMSenderInfo
-	task   *db.DMTask
-	devID  string
+	taskID    int64
+	mode      int
+	task      *db.DMTask
+	accounts  []*sendAccount
+	next      int
+	transport Transport
+	devID     string
 
 	stopped atomic.Bool
 }
 
-func (e *executor) start(ctx *swe.Context) error {
+// start runs task now, or lets it wait if it is not time to send yet, returns true if it runs
+func (e *executor) start(ctx *swe.Context) (bool, error) {
 	logger := swe.CtxLogger(ctx)
 
-	// load task
-	task, err := db.GetDirectMsgDAL().Get(ctx, e.taskID)
-	if err != nil {
-		logger.Error("load direct msg task %d failed: %v", e.taskID, err)
-		return err
+	// change mode & status, a pending retry is replaced by this run
+	if e.mode == 0 {
+		e.mode = e.task.SendMode
 	}
-	if task == nil {
-		logger.Error("load direct msg task %d failed: not found", e.taskID)
-		return ErrTaskNotFound
+	if e.mode == 0 {
+		e.mode = db.DM_SEND_UNSENT
 	}
-
-	// change status
-	if err = db.GetDirectMsgDAL().UpdateStatus(ctx, e.taskID, db.DM_TASK_STATUS_RUNNING); err != nil {
+	if err := db.GetDirectMsgDAL().SetSendMode(ctx, e.taskID, e.mode); err != nil {
+		logger.Error("update direct msg task %d send mode failed: %v", e.taskID, err)
+		return false, err
+	}
+	if err := db.GetDirectMsgDAL().SetRetryTask(ctx, e.taskID, 0); err != nil {
+		logger.Error("clear retry of direct msg task %d failed: %v", e.taskID, err)
+		return false, err
+	}
+	if now := time.Now().Unix(); runAt(e.task, now) > now {
+		return false, e.wait(ctx, runAt(e.task, now))
+	}
+	if err := db.GetDirectMsgDAL().UpdateStatus(ctx, e.taskID, db.DM_TASK_STATUS_RUNNING); err != nil {
 		logger.Error("update direct msg task %d status to running failed: %v", e.taskID, err)
-		return err
+		return false, err
 	}
 
 	// create new context
@@ -132,12 +231,35 @@ func (e *executor) start(ctx *swe.Context) error {
 
 	// start exec
 	logger.Info("start direct msg task %d log id %s", e.taskID, swe.CtxLogID(exeCtx))
-	e.task = task
 	go e.exec(exeCtx)
 
+	return true, nil
+}
+
+// wait pauses task until ts, when it is started again by an async task
+func (e *executor) wait(ctx *swe.Context, ts int64) error {
+	logger := swe.CtxLogger(ctx)
+	logger.Info("direct msg task %d waits until %s", e.taskID, utils.TimeToLocalString(ts))
+
+	if err := db.GetDirectMsgDAL().UpdateStatus(ctx, e.taskID, db.DM_TASK_STATUS_WAITING); err != nil {
+		logger.Error("update direct msg task %d status to waiting failed: %v", e.taskID, err)
+		return err
+	}
+	if err := e.scheduleRun(ctx, ts); err != nil {
+		db.GetDirectMsgDAL().UpdateStatus(ctx, e.taskID, db.DM_TASK_STATUS_PAUSED)
+		return err
+	}
 	return nil
 }
 
+// runAt returns the time task can send at from ts on, by its start time and sending window
+func runAt(task *db.DMTask, ts int64) int64 {
+	if task.StartTime > ts {
+		ts = task.StartTime
+	}
+	return utils.DayWindow{Start: task.WindowStart, End: task.WindowEnd}.NextOpen(ts)
+}
+
 func (e *executor) stop() error {
 	e.stopped.Store(true)
 	return nil
@@ -149,13 +271,13 @@ func (e *executor) exec(ctx *swe.Context) {
 	logger := swe.CtxLogger(ctx)
 
 	// load details
-	details, err := db.GetDirectMsgDAL().LoadUnsentDetails(ctx, e.taskID, e.task.BatchMax)
+	details, err := db.GetDirectMsgDAL().LoadDetails(ctx, e.taskID, e.mode, time.Now().Unix(), e.task.BatchMax)
 	if err != nil {
 		logger.Error("load details for task %d failed: %v", e.taskID, err)
 		db.GetDirectMsgDAL().UpdateStatus(ctx, e.taskID, db.DM_TASK_STATUS_PAUSED)
 		return
 	}
-	logger.Info("load %d details for task %d", len(details), e.taskID)
+	logger.Info("load %d details for task %d in mode %d", len(details), e.taskID, e.mode)
 
 	failCount := 0
 
@@ -179,18 +301,54 @@ func (e *executor) exec(ctx *swe.Context) {
 			return
 		}
 
-		// send dm
-		logger.Info("[%d/%d] sending direct message to uid %d ...", idx+1, len(details), item.RecieverUID)
-		rsp, err := dm.SendDirectMsg(e.sender.UID, item.RecieverUID, item.Content, e.devID, e.sender.SessData, e.sender.JCT)
-		err = e.processDirectMsgRsp(rsp, err)
+		// check sending window, the schedule may be changed by the streamer while running
+		if task, err := db.GetDirectMsgDAL().Get(ctx, e.taskID); err == nil && task != nil {
+			e.task.StartTime, e.task.WindowStart, e.task.WindowEnd = task.StartTime, task.WindowStart, task.WindowEnd
+		}
+		if now := time.Now().Unix(); runAt(e.task, now) > now {
+			logger.Info("direct msg task %d out of sending window, %d details processed", e.taskID, idx)
+			e.wait(ctx, runAt(e.task, now))
+			return
+		}
+
+		// send dm, a message rejected for its account is sent again with another one in pool
+		var acc *sendAccount
+		var rsp *dm.SendDirectMsgRsp
+		var err error
+		for tries := 1; ; tries++ {
+			if acc = e.pick(); acc == nil {
+				break
+			}
+			limiter.wait(acc.info.UID, acc.interval)
+			logger.Info("[%d/%d] sending direct message to uid %d with %d ...", idx+1, len(details), item.RecieverUID, acc.info.UID)
+			rsp, err = e.send(ctx, acc, item.RecieverUID, item.Content)
+			err = e.processDirectMsgRsp(rsp, err)
+			if err == nil || !(isAuthError(rsp) || isRateLimited(rsp)) {
+				break
+			}
+			if !e.accountFailed(ctx, acc, rsp, err) || tries >= DM_ACCOUNT_MAX_FAILS {
+				break
+			}
+		}
+		if len(e.accounts) == 0 {
+			logger.Error("no account left for direct msg task %d, stop", e.taskID)
+			db.GetDirectMsgDAL().UpdateStatus(ctx, e.taskID, db.DM_TASK_STATUS_PAUSED)
+			return
+		}
 		if err != nil {
-			logger.Error("send dm to %d failed: %v", item.RecieverUID, err)
-			failCount += 1
-			db.GetDirectMsgDAL().UpdateDetailStatus(ctx, e.taskID, item.RecieverUID, db.DM_DETAIL_STATUS_FAIL, err.Error())
+			kind := classifyFailure(rsp, err)
+			nextRetry := int64(0)
+			if kind == db.DM_FAIL_TRANSIENT {
+				failCount += 1
+				nextRetry = time.Now().Unix() + retryDelay(item.Attempts+1)
+			}
+			logger.Error("send dm to %d failed (kind %d, attempt %d): %v", item.RecieverUID, kind, item.Attempts+1, err)
+			db.GetDirectMsgDAL().FailDetail(ctx, e.taskID, item.RecieverUID, kind, err.Error(), nextRetry)
 			continue
 		}
 
 		// update detail status
+		e.accountSucceed(ctx, acc)
 		failCount = 0
 		logger.Info("[%d/%d] sending direct message to uid %d succeed", idx+1, len(details), item.RecieverUID)
 		db.GetDirectMsgDAL().UpdateDetailStatus(ctx, e.taskID, item.RecieverUID, db.DM_DETAIL_STATUS_DONE, "")
@@ -204,16 +362,52 @@ func (e *executor) exec(ctx *swe.Context) {
 		return
 	}
 
-	if stat.NotSend == 0 && stat.Fail == 0 {
-		logger.Info("direct msg task %d all done", e.taskID)
+	// failures not to be retried are final
+	if stat.NotSend == 0 && stat.Retryable == 0 {
+		logger.Info("direct msg task %d all done: done[%d] fail[%d]", e.taskID, stat.Done, stat.Fail)
 		db.GetDirectMsgDAL().UpdateStatus(ctx, e.taskID, db.DM_TASK_STATUS_DONE)
-	} else {
-		logger.Info("direct msg task %d partially done: done[%d] fail[%d] not_sent[%d]",
-			e.taskID, stat.Done, stat.Fail, stat.NotSend)
-		db.GetDirectMsgDAL().UpdateStatus(ctx, e.taskID, db.DM_TASK_STATUS_PAUSED)
+		return
+	}
+
+	logger.Info("direct msg task %d partially done: done[%d] fail[%d] retryable[%d] not_sent[%d]",
+		e.taskID, stat.Done, stat.Fail, stat.Retryable, stat.NotSend)
+	db.GetDirectMsgDAL().UpdateStatus(ctx, e.taskID, db.DM_TASK_STATUS_PAUSED)
+	if e.mode != db.DM_SEND_UNSENT && stat.Retryable > 0 {
+		e.scheduleRetry(ctx)
 	}
 }
 
+// scheduleRetry lets task run again once its earliest failure can be retried
+func (e *executor) scheduleRetry(ctx *swe.Context) {
+	ts, err := db.GetDirectMsgDAL().NextRetry(ctx, e.taskID)
+	if err != nil {
+		swe.CtxLogger(ctx).Error("query next retry of direct msg task %d failed: %v", e.taskID, err)
+		return
+	}
+	if now := time.Now().Unix(); ts < now {
+		ts = now
+	}
+	e.scheduleRun(ctx, ts)
+}
+
+// scheduleRun creates an async task to start task again at ts
+func (e *executor) scheduleRun(ctx *swe.Context, ts int64) error {
+	logger := swe.CtxLogger(ctx)
+
+	var runID int64
+	err := async_task.GetScheduler().AddTask(ctx, asyncTaskRetryDM, fmt.Sprint(e.taskID), ts, func(id int64) { runID = id })
+	if err != nil {
+		logger.Error("schedule run of direct msg task %d failed: %v", e.taskID, err)
+		return err
+	}
+	if err = db.GetDirectMsgDAL().SetRetryTask(ctx, e.taskID, runID); err != nil {
+		logger.Error("save run of direct msg task %d failed: %v", e.taskID, err)
+		return err
+	}
+	logger.Info("direct msg task %d will run again at %s by async task %d", e.taskID, utils.TimeToLocalString(ts), runID)
+	return nil
+}
+
 func (e *executor) randomSleep() {
 	lower := time.Second * time.Duration(e.task.IntervalMin)
 	upper := time.Second * time.Duration(e.task.IntervalMax)
@@ -223,6 +417,16 @@ func (e *executor) randomSleep() {
 	time.Sleep(lower)
 }
 
+func (e *executor) send(ctx *swe.Context, acc *sendAccount, uid int64, content string) (*dm.SendDirectMsgRsp, error) {
+	return e.transport.Send(ctx, &Message{
+		TaskID:   e.taskID,
+		DeviceID: e.devID,
+		Sender:   acc.info,
+		Receiver: uid,
+		Content:  content,
+	})
+}
+
 func (e *executor) processDirectMsgRsp(rsp *dm.SendDirectMsgRsp, err error) error {
 	if err != nil {
 		return err
@@ -232,3 +436,8 @@ func (e *executor) processDirectMsgRsp(rsp *dm.SendDirectMsgRsp, err error) erro
 	}
 	return nil
 }
+
+// isAuthError tells if the sender is not logged in (-101) or csrf token is wrong (-111)
+func isAuthError(rsp *dm.SendDirectMsgRsp) bool {
+	return rsp != nil && (rsp.Code == -101 || rsp.Code == -111)
+}
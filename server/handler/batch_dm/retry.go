@@ -0,0 +1,103 @@
+package batch_dm
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	dm "github.com/zerozwt/BLiveDanmaku"
+	"github.com/zerozwt/octant/server/async_task"
+	"github.com/zerozwt/octant/server/db"
+	"github.com/zerozwt/swe"
+)
+
+const asyncTaskRetryDM = "retry_direct_msg"
+
+const (
+	// delay before the first retry of a transient failure, doubled for each attempt after
+	DM_RETRY_BACKOFF     = 300
+	DM_RETRY_MAX_BACKOFF = 3600 * 6
+)
+
+func init() {
+	async_task.RegisterHandler(asyncTaskRetryDM, retryTask)
+}
+
+// retryTask starts a dm task again for failures due to retry or when it is time to send,
+// unless the task was started or stopped by the streamer since the run was scheduled
+func retryTask(ctx *swe.Context, taskCtx async_task.TaskContext) error {
+	logger := swe.CtxLogger(ctx)
+
+	id, err := strconv.ParseInt(taskCtx.Param(), 10, 64)
+	if err != nil {
+		return async_task.Permanent(err)
+	}
+
+	task, err := db.GetDirectMsgDAL().Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if task == nil {
+		return async_task.Permanent(ErrTaskNotFound)
+	}
+	if task.RetryTaskID != taskCtx.ID() ||
+		(task.Status != db.DM_TASK_STATUS_PAUSED && task.Status != db.DM_TASK_STATUS_WAITING) {
+		logger.Info("retry %d of direct msg task %d is outdated, skip", taskCtx.ID(), id)
+		return nil
+	}
+
+	err = GetManager().StartTask(ctx, id, 0)
+	if errors.Is(err, ErrSenderNotSet) || errors.Is(err, ErrSenderInvalid) || errors.Is(err, ErrSenderExpired) ||
+		errors.Is(err, ErrCredentialUnreadable) || errors.Is(err, ErrTaskNotFound) || errors.Is(err, ErrNoAccount) ||
+		errors.Is(err, ErrTransportNotFound) {
+		// waits for the streamer to set a new sender
+		return async_task.Permanent(err)
+	}
+	return err
+}
+
+// retryDelay returns seconds to wait before retrying a transient failure after attempts tries
+func retryDelay(attempts int) int64 {
+	delay := int64(DM_RETRY_BACKOFF)
+	for i := 1; i < attempts && delay < DM_RETRY_MAX_BACKOFF; i++ {
+		delay *= 2
+	}
+	if delay > DM_RETRY_MAX_BACKOFF {
+		delay = DM_RETRY_MAX_BACKOFF
+	}
+	return delay
+}
+
+// classifyFailure tells whether sending to a user is worth retrying. Network errors and
+// rate limits are transient, refusals by the receiver are permanent; unknown errors are
+// retried as transient, bounded by db.DM_DETAIL_MAX_ATTEMPTS.
+func classifyFailure(rsp *dm.SendDirectMsgRsp, err error) int {
+	if rsp == nil {
+		return db.DM_FAIL_TRANSIENT
+	}
+	if isRateLimited(rsp) {
+		return db.DM_FAIL_TRANSIENT
+	}
+	for _, word := range []string{"拒收", "拉黑", "黑名单", "隐私", "不存在", "注销"} {
+		if strings.Contains(rsp.Message, word) {
+			return db.DM_FAIL_PERMANENT
+		}
+	}
+	return db.DM_FAIL_TRANSIENT
+}
+
+// isRateLimited tells if the request is rejected for sending too fast (-412, -509)
+func isRateLimited(rsp *dm.SendDirectMsgRsp) bool {
+	if rsp == nil {
+		return false
+	}
+	if rsp.Code == -412 || rsp.Code == -509 {
+		return true
+	}
+	for _, word := range []string{"频繁", "频率", "过快"} {
+		if strings.Contains(rsp.Message, word) {
+			return true
+		}
+	}
+	return false
+}
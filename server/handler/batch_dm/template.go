@@ -0,0 +1,435 @@
+package batch_dm
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zerozwt/octant/server/db"
+	"github.com/zerozwt/octant/server/handler/event_calc"
+	"github.com/zerozwt/octant/server/utils"
+	"github.com/zerozwt/swe"
+)
+
+// Templates are plain text with placeholders in braces:
+//
+//	{name}                  a variable, see templateVars
+//	{name|friend}           a variable, or the default text if it is empty or 0
+//	{if guard_level}...{end}
+//	{if gift_value >= 10000}...{else}...{end}
+//	{if !sc_count}...{end}  conditions compare numbers, or text by == and !=
+//	{{ and }}               literal braces
+//
+// Unknown variables and unbalanced braces or conditions are reported by MakeBuilder.
+
+type templateValue func(data *templateData) (string, error)
+
+var templateVars map[string]templateValue = map[string]templateValue{
+	"streamer": func(data *templateData) (string, error) { return data.ctx.StreamerName, nil },
+	"room":     func(data *templateData) (string, error) { return fmt.Sprint(data.ctx.Event.RoomID), nil },
+	"event":    func(data *templateData) (string, error) { return data.ctx.Event.EventName, nil },
+	"uid": func(data *templateData) (string, error) {
+		info, err := data.info()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprint(info.UID), nil
+	},
+	"name": func(data *templateData) (string, error) {
+		info, err := data.info()
+		if err != nil {
+			return "", err
+		}
+		return info.UserName, nil
+	},
+	"invite_link": func(data *templateData) (string, error) {
+		info, err := data.info()
+		if err != nil {
+			return "", err
+		}
+		return data.ctx.InviteLink + info.AccessCode, nil
+	},
+	"expire": func(data *templateData) (string, error) {
+		ts, ok := data.ctx.ExpireMap[data.record.UID]
+		if !ok {
+			return "", ErrInfoNotFount
+		}
+		return utils.TimeToLocalString(ts), nil
+	},
+	"time": func(data *templateData) (string, error) {
+		if data.record.Time == 0 {
+			return "", nil
+		}
+		return utils.TimeToLocalString(data.record.Time), nil
+	},
+	// total count of gifts
+	"gift_count": func(data *templateData) (string, error) {
+		return data.sum(func(user *event_calc.UserData) (ret int64) {
+			for _, item := range user.Gift {
+				ret += item.GiftCount
+			}
+			return
+		})
+	},
+	// total value of gifts in gold coins, 1000 for 1 CNY
+	"gift_value": func(data *templateData) (string, error) {
+		return data.sum(func(user *event_calc.UserData) (ret int64) {
+			for _, item := range user.Gift {
+				ret += item.GiftPrice * item.GiftCount
+			}
+			return
+		})
+	},
+	// names of gifts in the order first sent
+	"gift_names": func(data *templateData) (string, error) {
+		user, err := data.user()
+		if err != nil {
+			return "", err
+		}
+		gifts := append([]*db.GiftRecord{}, user.Gift...)
+		sort.SliceStable(gifts, func(i, j int) bool { return gifts[i].SendTime < gifts[j].SendTime })
+		names, seen := []string{}, map[string]bool{}
+		for _, item := range gifts {
+			if !seen[item.GiftName] {
+				seen[item.GiftName] = true
+				names = append(names, item.GiftName)
+			}
+		}
+		return strings.Join(names, "、"), nil
+	},
+	"sc_count": func(data *templateData) (string, error) {
+		return data.sum(func(user *event_calc.UserData) int64 { return int64(len(user.SC)) })
+	},
+	// total price of super chats in CNY
+	"sc_total": func(data *templateData) (string, error) {
+		return data.sum(func(user *event_calc.UserData) (ret int64) {
+			for _, item := range user.SC {
+				ret += item.Price
+			}
+			return
+		})
+	},
+	// highest guard level, 1 for 总督 to 3 for 舰长, 0 for none
+	"guard_level": func(data *templateData) (string, error) {
+		level, err := data.guardLevel()
+		return fmt.Sprint(level), err
+	},
+	"guard_name": func(data *templateData) (string, error) {
+		level, err := data.guardLevel()
+		if err != nil || level < 1 || level > 3 {
+			return "", err
+		}
+		return []string{"总督", "提督", "舰长"}[level-1], nil
+	},
+	// total months of guard bought
+	"guard_count": func(data *templateData) (string, error) {
+		return data.sum(func(user *event_calc.UserData) (ret int64) {
+			for _, item := range user.Member {
+				ret += int64(item.Count)
+			}
+			return
+		})
+	},
+}
+
+// templateData is what a template renders for one record, columns are decoded once if needed
+type templateData struct {
+	record  *db.RewardUser
+	ctx     *BuildCtx
+	decoded *event_calc.UserData
+}
+
+func (data *templateData) info() (*db.DDInfo, error) {
+	info, ok := data.ctx.InfoMap[data.record.UID]
+	if !ok {
+		return nil, ErrInfoNotFount
+	}
+	return info, nil
+}
+
+func (data *templateData) user() (*event_calc.UserData, error) {
+	if data.decoded != nil {
+		return data.decoded, nil
+	}
+	if len(data.record.Columns) == 0 {
+		data.decoded = event_calc.NewEventUser(data.record.UID)
+		return data.decoded, nil
+	}
+	user, err := event_calc.EventUserfromDB(data.record)
+	if err != nil {
+		return nil, err
+	}
+	data.decoded = user
+	return user, nil
+}
+
+func (data *templateData) sum(fn func(user *event_calc.UserData) int64) (string, error) {
+	user, err := data.user()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(fn(user)), nil
+}
+
+func (data *templateData) guardLevel() (int, error) {
+	user, err := data.user()
+	if err != nil {
+		return 0, err
+	}
+	ret := 0
+	for _, item := range user.Member {
+		if item.GuardLevel > 0 && (ret == 0 || item.GuardLevel < ret) {
+			ret = item.GuardLevel
+		}
+	}
+	if ret == 0 {
+		ret = data.ctx.LevelMap[data.record.UID]
+	}
+	return ret, nil
+}
+
+type templateNode interface {
+	render(data *templateData, b *strings.Builder) error
+}
+
+type textNode string
+
+func (n textNode) render(data *templateData, b *strings.Builder) error {
+	b.WriteString(string(n))
+	return nil
+}
+
+type varNode struct {
+	value      templateValue
+	defaultVal string
+	hasDefault bool
+}
+
+func (n varNode) render(data *templateData, b *strings.Builder) error {
+	value, err := n.value(data)
+	if err != nil {
+		return err
+	}
+	if n.hasDefault && (len(value) == 0 || value == "0") {
+		value = n.defaultVal
+	}
+	b.WriteString(value)
+	return nil
+}
+
+type condNode struct {
+	left   templateValue
+	op     string // empty for truthy, "!" for falsy
+	right  string
+	then   []templateNode
+	orElse []templateNode
+}
+
+func (n *condNode) render(data *templateData, b *strings.Builder) error {
+	ok, err := n.eval(data)
+	if err != nil {
+		return err
+	}
+	nodes := n.orElse
+	if ok {
+		nodes = n.then
+	}
+	return renderNodes(nodes, data, b)
+}
+
+func (n *condNode) eval(data *templateData) (bool, error) {
+	value, err := n.left(data)
+	if err != nil {
+		return false, err
+	}
+	switch n.op {
+	case "":
+		return len(value) > 0 && value != "0", nil
+	case "!":
+		return len(value) == 0 || value == "0", nil
+	}
+
+	left, lerr := strconv.ParseInt(value, 10, 64)
+	right, rerr := strconv.ParseInt(n.right, 10, 64)
+	if lerr != nil || rerr != nil {
+		switch n.op {
+		case "==":
+			return value == n.right, nil
+		case "!=":
+			return value != n.right, nil
+		}
+		return false, nil
+	}
+	switch n.op {
+	case "==":
+		return left == right, nil
+	case "!=":
+		return left != right, nil
+	case ">":
+		return left > right, nil
+	case ">=":
+		return left >= right, nil
+	case "<":
+		return left < right, nil
+	}
+	return left <= right, nil
+}
+
+func renderNodes(nodes []templateNode, data *templateData, b *strings.Builder) error {
+	for _, item := range nodes {
+		if err := item.render(data, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type template []templateNode
+
+func (t template) BuildContent(ctx *swe.Context, record *db.RewardUser, buildCtx *BuildCtx) (string, error) {
+	b := strings.Builder{}
+	err := renderNodes(t, &templateData{record: record, ctx: buildCtx}, &b)
+	return b.String(), err
+}
+
+// TemplateVars lists variables can be used in templates
+func TemplateVars() []string {
+	ret := make([]string, 0, len(templateVars))
+	for name := range templateVars {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// MakeBuilder checks a template and returns a Builder for it, see the syntax above
+func MakeBuilder(text string) (Builder, error) {
+	// stack of conditions being parsed, each with the node list placeholders go to
+	type frame struct {
+		cond   *condNode
+		inElse bool
+	}
+	root := []templateNode{}
+	stack := []*frame{}
+	appendNode := func(node templateNode) {
+		if len(stack) == 0 {
+			root = append(root, node)
+			return
+		}
+		top := stack[len(stack)-1]
+		if top.inElse {
+			top.cond.orElse = append(top.cond.orElse, node)
+		} else {
+			top.cond.then = append(top.cond.then, node)
+		}
+	}
+
+	plain := strings.Builder{}
+	flush := func() {
+		if plain.Len() > 0 {
+			appendNode(textNode(plain.String()))
+			plain.Reset()
+		}
+	}
+
+	for pos := 0; pos < len(text); {
+		ch := text[pos]
+		if ch == '}' {
+			if pos+1 < len(text) && text[pos+1] == '}' {
+				plain.WriteByte('}')
+				pos += 2
+				continue
+			}
+			return nil, fmt.Errorf("unmatched '}' at %d, use '}}' for a literal one", pos)
+		}
+		if ch != '{' {
+			plain.WriteByte(ch)
+			pos++
+			continue
+		}
+		if pos+1 < len(text) && text[pos+1] == '{' {
+			plain.WriteByte('{')
+			pos += 2
+			continue
+		}
+
+		end := strings.IndexByte(text[pos:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed '{' at %d", pos)
+		}
+		body := strings.TrimSpace(text[pos+1 : pos+end])
+		if strings.ContainsRune(body, '{') {
+			return nil, fmt.Errorf("unclosed '{' at %d", pos)
+		}
+		flush()
+
+		switch {
+		case body == "else":
+			if len(stack) == 0 || stack[len(stack)-1].inElse {
+				return nil, fmt.Errorf("unexpected {else} at %d", pos)
+			}
+			stack[len(stack)-1].inElse = true
+		case body == "end":
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("unexpected {end} at %d", pos)
+			}
+			cond := stack[len(stack)-1].cond
+			stack = stack[:len(stack)-1]
+			appendNode(cond)
+		case strings.HasPrefix(body, "if "):
+			cond, err := parseCondition(strings.TrimSpace(body[2:]))
+			if err != nil {
+				return nil, fmt.Errorf("%v at %d", err, pos)
+			}
+			stack = append(stack, &frame{cond: cond})
+		default:
+			node, err := parseVar(body)
+			if err != nil {
+				return nil, fmt.Errorf("%v at %d", err, pos)
+			}
+			appendNode(node)
+		}
+		pos += end + 1
+	}
+
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("{if} without {end}")
+	}
+	flush()
+	return template(root), nil
+}
+
+func lookupVar(name string) (templateValue, error) {
+	value, ok := templateVars[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil, fmt.Errorf("unknown variable {%s}", strings.TrimSpace(name))
+	}
+	return value, nil
+}
+
+func parseVar(body string) (templateNode, error) {
+	name, defaultVal, hasDefault := strings.Cut(body, "|")
+	value, err := lookupVar(name)
+	if err != nil {
+		return nil, err
+	}
+	return varNode{value: value, defaultVal: defaultVal, hasDefault: hasDefault}, nil
+}
+
+func parseCondition(expr string) (*condNode, error) {
+	if strings.HasPrefix(expr, "!") {
+		value, err := lookupVar(expr[1:])
+		return &condNode{left: value, op: "!"}, err
+	}
+
+	for _, op := range []string{">=", "<=", "==", "!=", ">", "<"} {
+		if left, right, ok := strings.Cut(expr, op); ok {
+			value, err := lookupVar(left)
+			return &condNode{left: value, op: op, right: strings.TrimSpace(right)}, err
+		}
+	}
+
+	value, err := lookupVar(expr)
+	return &condNode{left: value}, err
+}
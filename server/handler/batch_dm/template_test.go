@@ -0,0 +1,57 @@
+package batch_dm
+
+import (
+	"testing"
+
+	"github.com/zerozwt/octant/server/db"
+)
+
+func TestTemplate(t *testing.T) {
+	buildCtx := &BuildCtx{
+		StreamerName: "octant",
+		Event:        &db.RewardEvent{RoomID: 100, EventName: "anniversary"},
+		InfoMap:      map[int64]*db.DDInfo{1: {UID: 1, UserName: "alice"}, 2: {UID: 2, UserName: ""}},
+		LevelMap:     map[int64]int{2: 3},
+	}
+	alice := &db.RewardUser{UID: 1, Columns: `{"gift":[{"SendTime":2,"GiftName":"b","GiftPrice":100,"GiftCount":3},` +
+		`{"SendTime":1,"GiftName":"a","GiftPrice":1000,"GiftCount":1},{"SendTime":3,"GiftName":"a","GiftPrice":1000,"GiftCount":2}],` +
+		`"member":[{"GuardLevel":3,"Count":1},{"GuardLevel":2,"Count":1}]}`}
+	bob := &db.RewardUser{UID: 2}
+
+	cases := []struct {
+		template string
+		record   *db.RewardUser
+		ans      string
+	}{
+		{"hi {name}, {streamer}@{room} {{{event}}}", alice, "hi alice, octant@100 {anniversary}"},
+		{"{NAME}/{name|friend}", bob, "/friend"},
+		{"{gift_count} {gift_value} {gift_names}", alice, "6 3300 a、b"},
+		{"{gift_count|none} {gift_names|none}", bob, "none none"},
+		{"{guard_level} {guard_name} {guard_count}", alice, "2 提督 2"},
+		{"{guard_name}", bob, "舰长"},
+		{"{if guard_level}guard{else}fan{end}", bob, "guard"},
+		{"{if !sc_count}no sc{end}", alice, "no sc"},
+		{"{if gift_value >= 3300}big{if guard_level == 2} 提督{end}{else}small{end}", alice, "big 提督"},
+		{"{if gift_value > 3300}big{else}small{end}", alice, "small"},
+		{"{if name != alice}other{else}same{end}", alice, "same"},
+	}
+
+	for _, item := range cases {
+		builder, err := MakeBuilder(item.template)
+		if err != nil {
+			t.Errorf("parse %s failed: %v", item.template, err)
+			continue
+		}
+		ret, err := builder.BuildContent(nil, item.record, buildCtx)
+		if err != nil || ret != item.ans {
+			t.Errorf("render %s got %q err %v ans %q", item.template, ret, err, item.ans)
+		}
+	}
+
+	for _, template := range []string{"{foo}", "{name", "name}", "{if name}x", "{else}", "{end}", "{if foo}x{end}",
+		"{if name}x{else}y{else}z{end}", "{na{me}"} {
+		if _, err := MakeBuilder(template); err == nil {
+			t.Errorf("%s should be invalid", template)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package batch_dm
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	dm "github.com/zerozwt/BLiveDanmaku"
+	"github.com/zerozwt/octant/server/bs"
+	"github.com/zerozwt/swe"
+)
+
+var ErrTransportNotFound error = fmt.Errorf("transport not found")
+
+// transport tasks use if they do not choose one
+const DM_TRANSPORT_BILIBILI = "bilibili"
+
+// Message is a direct message to deliver
+type Message struct {
+	TaskID   int64
+	DeviceID string
+	Sender   *bs.DMSenderInfo
+	Receiver int64
+	Content  string
+}
+
+// Transport delivers direct messages. Answers are in codes of bilibili, so that failures
+// are classified the same way whichever transport a task uses.
+type Transport interface {
+	Send(ctx *swe.Context, msg *Message) (*dm.SendDirectMsgRsp, error)
+}
+
+var transportLock sync.RWMutex
+var transports map[string]Transport = map[string]Transport{
+	DM_TRANSPORT_BILIBILI: bilibiliTransport{},
+}
+
+// RegisterTransport makes a transport selectable by tasks with name
+func RegisterTransport(name string, transport Transport) {
+	transportLock.Lock()
+	defer transportLock.Unlock()
+	transports[name] = transport
+}
+
+func getTransport(name string) (Transport, error) {
+	if len(name) == 0 {
+		name = DM_TRANSPORT_BILIBILI
+	}
+	transportLock.RLock()
+	defer transportLock.RUnlock()
+	transport, ok := transports[name]
+	if !ok {
+		return nil, ErrTransportNotFound
+	}
+	return transport, nil
+}
+
+func HasTransport(name string) bool {
+	_, err := getTransport(name)
+	return err == nil
+}
+
+func TransportNames() []string {
+	transportLock.RLock()
+	defer transportLock.RUnlock()
+	ret := make([]string, 0, len(transports))
+	for name := range transports {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// bilibiliTransport sends with the sender's cookies through the api of bilibili
+type bilibiliTransport struct{}
+
+func (t bilibiliTransport) Send(ctx *swe.Context, msg *Message) (*dm.SendDirectMsgRsp, error) {
+	return dm.SendDirectMsg(msg.Sender.UID, msg.Receiver, msg.Content, msg.DeviceID, msg.Sender.SessData, msg.Sender.JCT)
+}
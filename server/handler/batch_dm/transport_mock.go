@@ -0,0 +1,99 @@
+package batch_dm
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	dm "github.com/zerozwt/BLiveDanmaku"
+	"github.com/zerozwt/swe"
+)
+
+// messages kept in memory by a mock transport
+const DM_MOCK_RECENT = 100
+
+type MockMessage struct {
+	Time      int64  `json:"time"`
+	TaskID    int64  `json:"task_id"`
+	SenderUID int64  `json:"sender_uid"`
+	Receiver  int64  `json:"receiver_uid"`
+	Content   string `json:"content"`
+	Code      int    `json:"code"`
+}
+
+// MockTransport delivers nothing, messages are appended to a file as json lines if it is set,
+// and the recent ones are kept for GET /admin/dm/mock. Receivers in codes get the code as
+// answer, so that failures can be played in tests and staging.
+type MockTransport struct {
+	file  string
+	codes map[int64]int
+
+	lock   sync.Mutex
+	recent []MockMessage
+}
+
+func NewMockTransport(file string, codes map[int64]int) *MockTransport {
+	return &MockTransport{file: file, codes: codes}
+}
+
+func (t *MockTransport) Send(ctx *swe.Context, msg *Message) (*dm.SendDirectMsgRsp, error) {
+	record := MockMessage{
+		Time:      time.Now().Unix(),
+		TaskID:    msg.TaskID,
+		SenderUID: msg.Sender.UID,
+		Receiver:  msg.Receiver,
+		Content:   msg.Content,
+		Code:      t.codes[msg.Receiver],
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if len(t.file) > 0 {
+		json := jsoniter.ConfigCompatibleWithStandardLibrary
+		line, _ := json.Marshal(record)
+		file, err := os.OpenFile(t.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		_, err = file.Write(append(line, '\n'))
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t.recent = append(t.recent, record)
+	if len(t.recent) > DM_MOCK_RECENT {
+		t.recent = t.recent[len(t.recent)-DM_MOCK_RECENT:]
+	}
+
+	rsp := &dm.SendDirectMsgRsp{Code: record.Code, Message: "mock"}
+	rsp.Data.Content = msg.Content
+	return rsp, nil
+}
+
+// Recent returns messages sent lately, the latest first
+func (t *MockTransport) Recent() []MockMessage {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	ret := make([]MockMessage, 0, len(t.recent))
+	for idx := len(t.recent) - 1; idx >= 0; idx-- {
+		ret = append(ret, t.recent[idx])
+	}
+	return ret
+}
+
+// MockMessages returns recent messages of the mock transport registered with name
+func MockMessages(name string) ([]MockMessage, bool) {
+	transport, err := getTransport(name)
+	if err != nil {
+		return nil, false
+	}
+	mock, ok := transport.(*MockTransport)
+	if !ok {
+		return nil, false
+	}
+	return mock.Recent(), true
+}
@@ -0,0 +1,59 @@
+package batch_dm
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zerozwt/octant/server/bs"
+)
+
+func TestMockTransport(t *testing.T) {
+	mock := NewMockTransport("", map[int64]int{3: -509})
+	sender := &bs.DMSenderInfo{UID: 1}
+
+	for _, uid := range []int64{2, 3} {
+		if _, err := mock.Send(nil, &Message{TaskID: 10, Sender: sender, Receiver: uid, Content: "hi"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recent := mock.Recent()
+	if len(recent) != 2 || recent[0].Receiver != 3 || recent[0].Code != -509 || recent[1].Code != 0 {
+		t.Fatalf("unexpected recent messages %+v", recent)
+	}
+}
+
+func TestWebhookTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(r.Header.Get("X-Octant-Timestamp") + "."))
+		mac.Write(body)
+		if hex.EncodeToString(mac.Sum(nil)) != r.Header.Get("X-Octant-Signature") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"code":-412,"message":"too fast"}`))
+	}))
+	defer server.Close()
+
+	msg := &Message{Sender: &bs.DMSenderInfo{UID: 1}, Receiver: 2, Content: "hi"}
+
+	rsp, err := NewWebhookTransport(server.URL, "secret", time.Second).Send(nil, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isRateLimited(rsp) {
+		t.Errorf("unexpected answer %+v", rsp)
+	}
+
+	if _, err = NewWebhookTransport(server.URL, "wrong", time.Second).Send(nil, msg); err == nil {
+		t.Error("request with wrong signature should fail")
+	}
+}
@@ -0,0 +1,79 @@
+package batch_dm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	dm "github.com/zerozwt/BLiveDanmaku"
+	"github.com/zerozwt/octant/server/utils"
+	"github.com/zerozwt/swe"
+)
+
+// WebhookTransport posts messages to a bot of the team as json:
+//
+//	{"task_id": 1, "sender_uid": 2, "receiver_uid": 3, "content": "...", "time": 1700000000}
+//
+// The bot answers {"code": 0, "message": ""} with codes of bilibili, an empty body means
+// delivered. With a secret, requests carry X-Octant-Timestamp and X-Octant-Signature, which
+// is hex of hmac-sha256 over timestamp + "." + body.
+type WebhookTransport struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func NewWebhookTransport(url, secret string, timeout time.Duration) *WebhookTransport {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &WebhookTransport{url: url, secret: secret, client: &http.Client{Timeout: timeout}}
+}
+
+func (t *WebhookTransport) Send(ctx *swe.Context, msg *Message) (*dm.SendDirectMsgRsp, error) {
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	ts := time.Now().Unix()
+	body, _ := json.Marshal(map[string]any{
+		"task_id":      msg.TaskID,
+		"sender_uid":   msg.Sender.UID,
+		"receiver_uid": msg.Receiver,
+		"content":      msg.Content,
+		"time":         ts,
+	})
+
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(t.secret) > 0 {
+		req.Header.Set("X-Octant-Timestamp", fmt.Sprint(ts))
+		req.Header.Set("X-Octant-Signature", utils.SignPayload(t.secret, ts, body))
+	}
+
+	rsp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rsp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("webhook answered http status %d", rsp.StatusCode)
+	}
+
+	ret := &dm.SendDirectMsgRsp{}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return ret, nil
+	}
+	if err = json.Unmarshal(data, ret); err != nil {
+		return nil, fmt.Errorf("decode webhook answer failed: %v", err)
+	}
+	return ret, nil
+}
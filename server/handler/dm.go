@@ -1,9 +1,15 @@
 package handler
 
 import (
+	"bytes"
+	"encoding/csv"
+	"errors"
 	"fmt"
+	"net/http"
+	"strconv"
 	"time"
 
+	dm "github.com/zerozwt/BLiveDanmaku"
 	"github.com/zerozwt/octant/server/bs"
 	"github.com/zerozwt/octant/server/db"
 	"github.com/zerozwt/octant/server/handler/batch_dm"
@@ -16,8 +22,21 @@ func init() {
 	registerHandler(POST, "/dm/task", dmsg.create, session.CheckStreamer)
 	registerHandler(GET, "/dm/tasks", dmsg.page, session.CheckStreamer)
 	registerHandler(GET, "/dm/task/detail", dmsg.detail, session.CheckStreamer)
+	registerHandler(GET, "/dm/task/recipients", dmsg.details, session.CheckStreamer)
+	registerRawHandler(GET, "/dm/task/recipients/dl", dmsg.download, session.CheckStreamer)
+	registerHandler(POST, "/dm/task/followup", dmsg.followUp, session.CheckStreamer)
 	registerHandler(POST, "/dm/sender", dmsg.setSender, session.CheckStreamer)
 	registerHandler(POST, "/dm/siwtch", dmsg.setState, session.CheckStreamer)
+	registerHandler(POST, "/dm/schedule", dmsg.setSchedule, session.CheckStreamer)
+	registerHandler(GET, "/dm/template/vars", dmsg.templateVars, session.CheckStreamer)
+	registerHandler(POST, "/dm/preview", dmsg.preview, session.CheckStreamer)
+	registerHandler(POST, "/dm/test", dmsg.testSend, session.CheckStreamer)
+	registerHandler(GET, "/dm/transports", dmsg.transports, session.CheckStreamer)
+
+	registerHandler(GET, "/dm/accounts", dmsg.accounts, session.CheckStreamer)
+	registerHandler(POST, "/dm/account/add", dmsg.addAccount, session.CheckStreamer)
+	registerHandler(POST, "/dm/account/enable", dmsg.enableAccount, session.CheckStreamer)
+	registerHandler(POST, "/dm/account/delete", dmsg.deleteAccount, session.CheckStreamer)
 }
 
 type dmHandler struct{}
@@ -28,26 +47,87 @@ func (ins dmHandler) create(ctx *swe.Context, req *bs.DMCreateReq) (*bs.Nothing,
 	logger := swe.CtxLogger(ctx)
 	st, _ := session.GetStreamerSession(ctx)
 
+	builder, err := batch_dm.MakeBuilder(req.Content)
+	if err != nil {
+		logger.Error("parse dm template failed: %v", err)
+		return nil, swe.Error(EC_DM_TEMPLATE_INVALID, err)
+	}
+	if !batch_dm.HasTransport(req.Transport) {
+		logger.Error("dm transport %s not found", req.Transport)
+		return nil, swe.Error(EC_DM_TRANSPORT_NOT_FOUND, batch_dm.ErrTransportNotFound)
+	}
+
+	records, buildCtx, sweErr := ins.recipients(ctx, st, req.EventID, req.TLS)
+	if sweErr != nil {
+		return nil, sweErr
+	}
+
+	task := db.DMTask{
+		ID:           utils.GenerateID(),
+		RoomID:       st.RoomID,
+		EventID:      req.EventID,
+		TaskName:     req.Name,
+		MsgType:      1,
+		Content:      req.Content,
+		BatchMax:     req.BatchMax,
+		Status:       db.DM_TASK_STATUS_PAUSED,
+		IntervalMin:  req.IntervalMin,
+		IntervalMax:  req.IntervalMax,
+		SenderPolicy: req.Policy,
+		Transport:    req.Transport,
+		StartTime:    req.Schedule.StartTs(),
+		WindowStart:  req.Schedule.Window().Start,
+		WindowEnd:    req.Schedule.Window().End,
+		CreateTime:   time.Now().Unix(),
+	}
+
+	// generate DM details
+	details := make([]db.DMDetail, 0, len(records))
+	for _, item := range records {
+		content, err := builder.BuildContent(ctx, &item, buildCtx)
+		if err != nil {
+			logger.Error("generate msg content for uid %d failed: %v", item.UID, err)
+		} else {
+			details = append(details, db.DMDetail{
+				TaskID:      task.ID,
+				RecieverUID: item.UID,
+				Content:     content,
+				Status:      db.DM_DETAIL_STATUS_NOT_SEND,
+			})
+		}
+	}
+
+	if err := ins.saveTask(ctx, &task, details, req.RunTask, &req.Sender); err != nil {
+		return nil, err
+	}
+
+	return &bs.Nothing{}, nil
+}
+
+// recipients loads users of an event and the context to render messages to them
+func (ins dmHandler) recipients(ctx *swe.Context, st *session.StreamerSession, eventID int64, tls bool) ([]db.RewardUser, *batch_dm.BuildCtx, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+
 	// load event
-	event, err := db.GetRewardEventDAL().GetByRoomID(ctx, req.EventID, st.RoomID)
+	event, err := db.GetRewardEventDAL().GetByRoomID(ctx, eventID, st.RoomID)
 	if err != nil {
-		logger.Error("query db for event %d error %v", req.EventID, err)
-		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+		logger.Error("query db for event %d error %v", eventID, err)
+		return nil, nil, swe.Error(EC_GENERIC_DB_FAIL, err)
 	}
 	if event == nil {
-		logger.Error("query db for event %d room id %d not found", req.EventID, st.RoomID)
-		return nil, swe.Error(EC_GENERIC_DB_FAIL, fmt.Errorf("event not found"))
+		logger.Error("query db for event %d room id %d not found", eventID, st.RoomID)
+		return nil, nil, swe.Error(EC_GENERIC_DB_FAIL, fmt.Errorf("event not found"))
 	}
 	if event.Status == db.EVENT_READY {
-		logger.Error("query db for event %d room id %d not ready", req.EventID, st.RoomID)
-		return nil, swe.Error(EC_GENERIC_DB_FAIL, fmt.Errorf("event not ready"))
+		logger.Error("query db for event %d room id %d not ready", eventID, st.RoomID)
+		return nil, nil, swe.Error(EC_GENERIC_DB_FAIL, fmt.Errorf("event not ready"))
 	}
 
 	// load event records
-	records, err := db.GetRewardEventDAL().Users(ctx, req.EventID)
+	records, err := db.GetRewardEventDAL().Users(ctx, eventID)
 	if err != nil {
-		logger.Error("query users for event %d error %v", req.EventID, err)
-		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+		logger.Error("query users for event %d error %v", eventID, err)
+		return nil, nil, swe.Error(EC_GENERIC_DB_FAIL, err)
 	}
 
 	uids := make([]int64, 0, len(records))
@@ -58,79 +138,63 @@ func (ins dmHandler) create(ctx *swe.Context, req *bs.DMCreateReq) (*bs.Nothing,
 	// load dd info
 	userMap, err := db.GetDDInfoDAL().BatchGet(ctx, uids)
 	if err != nil {
-		logger.Error("query dd info for event %d error %v", req.EventID, err)
-		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+		logger.Error("query dd info for event %d error %v", eventID, err)
+		return nil, nil, swe.Error(EC_GENERIC_DB_FAIL, err)
 	}
 
 	// build content converter
 	proto := "http://"
-	if req.TLS {
+	if tls {
 		proto = "https://"
 	}
-	builder := batch_dm.MakeBuilder(req.Content)
-	buildCtx := batch_dm.BuildCtx{
+	buildCtx := &batch_dm.BuildCtx{
 		StreamerName: st.StreamerName,
 		Event:        event,
 		InviteLink:   proto + ctx.Request.Host + "/dd/access?access_code=",
 		InfoMap:      userMap,
 	}
 
+	return records, buildCtx, nil
+}
+
+// saveTask writes task and its details to db, then starts the task if needed
+func (ins dmHandler) saveTask(ctx *swe.Context, task *db.DMTask, details []db.DMDetail, run bool, sender *bs.DMSenderInfo) swe.SweError {
+	logger := swe.CtxLogger(ctx)
+
 	// save task to db
-	task := db.DMTask{
-		ID:          utils.GenerateID(),
-		RoomID:      st.RoomID,
-		EventID:     event.ID,
-		TaskName:    req.Name,
-		MsgType:     1,
-		Content:     req.Content,
-		BatchMax:    req.BatchMax,
-		Status:      db.DM_TASK_STATUS_PAUSED,
-		IntervalMin: req.IntervalMin,
-		IntervalMax: req.IntervalMax,
-		CreateTime:  time.Now().Unix(),
-	}
-
-	if err := db.GetDirectMsgDAL().Put(ctx, &task); err != nil {
+	if err := db.GetDirectMsgDAL().Put(ctx, task); err != nil {
 		logger.Error("save task info error %v", err)
-		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+		return swe.Error(EC_GENERIC_DB_FAIL, err)
 	}
 
-	// generate DM details
-	details := make([]db.DMDetail, 0, len(userMap))
-	for _, item := range records {
-		content, err := builder.BuildContent(ctx, &item, &buildCtx)
-		if err != nil {
-			logger.Error("generate msg content for uid %d failed: %v", item.UID, err)
-		} else {
-			details = append(details, db.DMDetail{
-				TaskID:      task.ID,
-				RecieverUID: item.UID,
-				Content:     content,
-				Status:      db.DM_DETAIL_STATUS_NOT_SEND,
-			})
-		}
-	}
-
-	// write to db
+	// write details to db
 	if err := db.GetDirectMsgDAL().BatchCreateDetails(ctx, details); err != nil {
 		logger.Error("save task details error %v", err)
-		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+		return swe.Error(EC_GENERIC_DB_FAIL, err)
 	}
 
-	// start dm job
-	if req.RunTask {
-		if err = batch_dm.GetManager().SetSenderInfo(task.ID, &req.Sender); err != nil {
-			logger.Error("set sender info for task %d failed: %v", task.ID, err)
-		} else {
-			if err = batch_dm.GetManager().StartTask(ctx, task.ID); err != nil {
-				logger.Error("start dm task %d failed: %v", task.ID, err)
-			} else {
-				logger.Info("start dm task %d", task.ID)
-			}
-		}
+	if run {
+		ins.startSaved(ctx, task, sender)
 	}
+	return nil
+}
 
-	return &bs.Nothing{}, nil
+// startSaved starts a task just saved, a task failed to start is left paused. Tasks sending with pool
+// need no sender of their own.
+func (ins dmHandler) startSaved(ctx *swe.Context, task *db.DMTask, sender *bs.DMSenderInfo) {
+	logger := swe.CtxLogger(ctx)
+	if task.SenderPolicy != db.DM_POLICY_SINGLE {
+		sender = nil
+	}
+	if err := ins.setTaskSender(ctx, task.ID, sender); err != nil {
+		logger.Error("set sender info for task %d failed: %v", task.ID, err)
+		return
+	}
+	if err := batch_dm.GetManager().StartTask(ctx, task.ID, db.DM_SEND_UNSENT); err != nil {
+		logger.Error("start dm task %d failed: %v", task.ID, err)
+		return
+	}
+	logger.Info("start dm task %d", task.ID)
 }
 
 func (ins dmHandler) page(ctx *swe.Context, req *bs.PageReq) (*bs.PageRsp, swe.SweError) {
@@ -161,6 +225,17 @@ func (ins dmHandler) page(ctx *swe.Context, req *bs.PageReq) (*bs.PageRsp, swe.S
 		eventMap[item.ID] = item
 	}
 
+	// query senders
+	tids := make([]int64, 0, len(tasks))
+	for _, item := range tasks {
+		tids = append(tids, item.ID)
+	}
+	senders, err := db.GetDMSenderDAL().BatchGet(ctx, tids)
+	if err != nil {
+		logger.Error("query senders for tasks of room %d error %v", st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+
 	for _, item := range tasks {
 		tmp := bs.DMTaskListItem{
 			ID:          item.ID,
@@ -170,14 +245,18 @@ func (ins dmHandler) page(ctx *swe.Context, req *bs.PageReq) (*bs.PageRsp, swe.S
 			IntervalMin: item.IntervalMin,
 			IntervalMax: item.IntervalMax,
 			Status:      item.Status,
+			Sender:      ins.senderStatus(senders[item.ID]),
 		}
-		event, ok := eventMap[item.EventID]
-		if !ok {
-			logger.Error("task %d event %d not found", item.ID, item.EventID)
-			continue
+		// guard reminders are not bound to any event
+		if item.EventID != 0 {
+			event, ok := eventMap[item.EventID]
+			if !ok {
+				logger.Error("task %d event %d not found", item.ID, item.EventID)
+				continue
+			}
+			tmp.Event.ID = event.ID
+			tmp.Event.Name = event.EventName
 		}
-		tmp.Event.ID = event.ID
-		tmp.Event.Name = event.EventName
 		rsp.List = append(rsp.List, tmp)
 	}
 
@@ -206,6 +285,17 @@ func (ins dmHandler) detail(ctx *swe.Context, req *bs.IDReq) (*bs.DMTaskDetail,
 		IntervalMin: task.IntervalMin,
 		IntervalMax: task.IntervalMax,
 		Status:      task.Status,
+		SendMode:    task.SendMode,
+		Policy:      task.SenderPolicy,
+		Transport:   task.Transport,
+		ParentID:    task.ParentID,
+	}
+	if task.StartTime > 0 {
+		ret.StartTime = utils.TimeToLocalString(task.StartTime)
+	}
+	if task.WindowStart != task.WindowEnd {
+		ret.WindowStart = fmt.Sprintf("%02d%02d", task.WindowStart/60, task.WindowStart%60)
+		ret.WindowEnd = fmt.Sprintf("%02d%02d", task.WindowEnd/60, task.WindowEnd%60)
 	}
 
 	stats, err := db.GetDirectMsgDAL().Stats(ctx, req.ID)
@@ -216,12 +306,177 @@ func (ins dmHandler) detail(ctx *swe.Context, req *bs.IDReq) (*bs.DMTaskDetail,
 
 	ret.Total = stats.Fail + stats.Done + stats.NotSend
 	ret.Fail = stats.Fail
+	ret.Retryable = stats.Retryable
 	ret.Succ = stats.Done
 
+	sender, err := db.GetDMSenderDAL().Get(ctx, req.ID)
+	if err != nil {
+		logger.Error("query sender for task %d error %v", req.ID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	ret.Sender = ins.senderStatus(sender)
+
 	return &ret, nil
 }
 
-func (ins dmHandler) setSender(ctx *swe.Context, req *bs.DMSetSenderReq) (*bs.Nothing, swe.SweError) {
+// details lists recipients of a task with their delivery state
+func (ins dmHandler) details(ctx *swe.Context, req *bs.DMDetailListReq) (*bs.PageRsp, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	task, err := db.GetDirectMsgDAL().GetByRoomID(ctx, req.TaskID, st.RoomID)
+	if err != nil {
+		logger.Error("query task %d for room %d error %v", req.TaskID, st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	if task == nil {
+		logger.Error("query task %d for room %d not found", req.TaskID, st.RoomID)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, fmt.Errorf("task not found"))
+	}
+
+	count, details, err := db.GetDirectMsgDAL().PageDetails(ctx, req.TaskID, req.Status, (req.Page-1)*req.Size, req.Size)
+	if err != nil {
+		logger.Error("query details for task %d error %v", req.TaskID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+
+	items, err := ins.detailItems(ctx, details)
+	if err != nil {
+		logger.Error("query dd info for task %d error %v", req.TaskID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+
+	rsp := bs.PageRsp{Count: count, List: []any{}}
+	for _, item := range items {
+		rsp.List = append(rsp.List, item)
+	}
+	return &rsp, nil
+}
+
+// detailItems converts details to list items with user names known
+func (ins dmHandler) detailItems(ctx *swe.Context, details []db.DMDetail) ([]bs.DMDetailItem, error) {
+	uids := make([]int64, 0, len(details))
+	for _, item := range details {
+		uids = append(uids, item.RecieverUID)
+	}
+	userMap, err := db.GetDDInfoDAL().BatchGet(ctx, uids)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]bs.DMDetailItem, 0, len(details))
+	for _, item := range details {
+		tmp := bs.DMDetailItem{
+			UID:        item.RecieverUID,
+			Content:    item.Content,
+			Status:     item.Status,
+			Attempts:   item.Attempts,
+			FailReason: item.FailReason,
+		}
+		if info, ok := userMap[item.RecieverUID]; ok {
+			tmp.Name = info.UserName
+		}
+		if item.SendTime > 0 {
+			tmp.SendTime = utils.TimeToLocalString(item.SendTime)
+		}
+		if item.Status == db.DM_DETAIL_STATUS_FAIL {
+			tmp.FailKind = item.FailKind
+			if item.FailKind == db.DM_FAIL_TRANSIENT && item.Attempts < db.DM_DETAIL_MAX_ATTEMPTS && item.NextRetry > 0 {
+				tmp.NextRetry = utils.TimeToLocalString(item.NextRetry)
+			}
+		}
+		ret = append(ret, tmp)
+	}
+	return ret, nil
+}
+
+// download exports recipients of a task with their delivery state as csv
+func (ins dmHandler) download(ctx *swe.Context) {
+	logger := swe.CtxLogger(ctx)
+	req := bs.DMDetailDownloadReq{}
+	if err := swe.DecodeForm(ctx.Request, &req); err != nil {
+		ctx.Response.WriteHeader(http.StatusBadRequest)
+		ctx.Response.Write([]byte(err.Error()))
+		return
+	}
+	if err := req.Validate(ctx); err != nil {
+		ctx.Response.WriteHeader(http.StatusBadRequest)
+		ctx.Response.Write([]byte(err.Error()))
+		return
+	}
+	st, _ := session.GetStreamerSession(ctx)
+
+	task, err := db.GetDirectMsgDAL().GetByRoomID(ctx, req.TaskID, st.RoomID)
+	if err != nil {
+		logger.Error("query task %d for room %d error %v", req.TaskID, st.RoomID, err)
+		http.NotFound(ctx.Response, ctx.Request)
+		return
+	}
+	if task == nil {
+		logger.Error("query task %d for room %d not found", req.TaskID, st.RoomID)
+		http.NotFound(ctx.Response, ctx.Request)
+		return
+	}
+
+	_, details, err := db.GetDirectMsgDAL().PageDetails(ctx, req.TaskID, req.Status, 0, 0)
+	if err != nil {
+		logger.Error("query details for task %d error %v", req.TaskID, err)
+		ctx.Response.WriteHeader(http.StatusInternalServerError)
+		ctx.Response.Write([]byte(err.Error()))
+		return
+	}
+	items, err := ins.detailItems(ctx, details)
+	if err != nil {
+		logger.Error("query dd info for task %d error %v", req.TaskID, err)
+		ctx.Response.WriteHeader(http.StatusInternalServerError)
+		ctx.Response.Write([]byte(err.Error()))
+		return
+	}
+
+	// generate csv lines
+	statusNames := map[int]string{
+		db.DM_DETAIL_STATUS_NOT_SEND: "未发送",
+		db.DM_DETAIL_STATUS_FAIL:     "失败",
+		db.DM_DETAIL_STATUS_DONE:     "成功",
+	}
+	failKindNames := map[int]string{
+		db.DM_FAIL_TRANSIENT: "可重试",
+		db.DM_FAIL_PERMANENT: "不可重试",
+	}
+	lines := [][]string{{"B站UID", "用户昵称", "发送状态", "发送时间", "尝试次数", "失败类型", "失败原因", "下次重试", "私信内容"}}
+	for _, item := range items {
+		lines = append(lines, []string{
+			strconv.FormatInt(item.UID, 10),
+			item.Name,
+			statusNames[item.Status],
+			item.SendTime,
+			strconv.Itoa(item.Attempts),
+			failKindNames[item.FailKind],
+			item.FailReason,
+			item.NextRetry,
+			item.Content,
+		})
+	}
+
+	csvData := bytes.Buffer{}
+	csvData.Write([]byte{0xEF, 0xBB, 0xBF}) // UTF8 BOM
+	err = csv.NewWriter(&csvData).WriteAll(lines)
+	if err != nil {
+		logger.Error("write csv data failed: %v", err)
+		ctx.Response.WriteHeader(http.StatusInternalServerError)
+		ctx.Response.Write([]byte(err.Error()))
+		return
+	}
+
+	// set header & write csv data
+	fileName := filterFileName(st.StreamerName + "_" + task.TaskName)
+	ctx.Response.Header().Set("Content-Type", "application/octet-stream")
+	ctx.Response.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.csv"`, fileName))
+	ctx.Response.Write(csvData.Bytes())
+}
+
+// followUp creates a task sending the messages of a stopped task again to its failed or unsent recipients
+func (ins dmHandler) followUp(ctx *swe.Context, req *bs.DMFollowUpReq) (*bs.DMFollowUpRsp, swe.SweError) {
 	logger := swe.CtxLogger(ctx)
 	st, _ := session.GetStreamerSession(ctx)
 
@@ -232,17 +487,124 @@ func (ins dmHandler) setSender(ctx *swe.Context, req *bs.DMSetSenderReq) (*bs.No
 	}
 	if task == nil {
 		logger.Error("query task %d for room %d not found", req.TaskID, st.RoomID)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, fmt.Errorf("task not found"))
+	}
+	old, err := db.GetDirectMsgDAL().DetailsByStatus(ctx, req.TaskID, req.Statuses, req.Permanent)
+	if err != nil {
+		logger.Error("query details for task %d error %v", req.TaskID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	if len(old) == 0 {
+		logger.Error("dm task %d has no recipient in status %v", req.TaskID, req.Statuses)
+		return nil, swe.Error(EC_DM_NO_RECIPIENT, fmt.Errorf("no recipient to follow up"))
+	}
+
+	name := req.Name
+	if len(name) == 0 {
+		name = task.TaskName + " (补发)"
+	}
+	followUp := db.DMTask{
+		ID:           utils.GenerateID(),
+		RoomID:       st.RoomID,
+		EventID:      task.EventID,
+		TaskName:     name,
+		MsgType:      task.MsgType,
+		Content:      task.Content,
+		BatchMax:     task.BatchMax,
+		Status:       db.DM_TASK_STATUS_PAUSED,
+		IntervalMin:  task.IntervalMin,
+		IntervalMax:  task.IntervalMax,
+		SenderPolicy: req.Policy,
+		Transport:    task.Transport,
+		StartTime:    req.Schedule.StartTs(),
+		WindowStart:  req.Schedule.Window().Start,
+		WindowEnd:    req.Schedule.Window().End,
+		ParentID:     task.ID,
+		CreateTime:   time.Now().Unix(),
+	}
+
+	// messages rendered for the task followed up are sent as they are
+	details := make([]db.DMDetail, 0, len(old))
+	for _, item := range old {
+		details = append(details, db.DMDetail{
+			TaskID:      followUp.ID,
+			RecieverUID: item.RecieverUID,
+			Content:     item.Content,
+			Status:      db.DM_DETAIL_STATUS_NOT_SEND,
+		})
+	}
+
+	// a retry of the task is dropped along with saving the follow-up
+	ok, err := db.GetDirectMsgDAL().CreateFollowUp(ctx, &followUp, details)
+	if err != nil {
+		logger.Error("save follow-up of task %d error %v", req.TaskID, err)
 		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
 	}
+	if !ok {
+		logger.Error("follow up dm task %d not stopped", req.TaskID)
+		return nil, swe.Error(EC_DM_TASK_BUSY, fmt.Errorf("task not stopped"))
+	}
+	if req.RunTask {
+		ins.startSaved(ctx, &followUp, &req.Sender)
+	}
+
+	return &bs.DMFollowUpRsp{ID: followUp.ID, Total: len(details)}, nil
+}
+
+func (ins dmHandler) senderStatus(sender *db.DMSender) *bs.DMSenderStatus {
+	if sender == nil {
+		return nil
+	}
+	ret := &bs.DMSenderStatus{
+		UID:    sender.SenderUID,
+		Status: sender.Status,
+		Reason: sender.Reason,
+	}
+	if sender.ExpireTime > 0 {
+		ret.ExpireTime = utils.TimeToLocalString(sender.ExpireTime)
+		ret.Expired = sender.ExpireTime <= time.Now().Unix()
+	}
+	return ret
+}
+
+// setTaskSender sets the sender of task, nothing to set if sender is nil
+func (ins dmHandler) setTaskSender(ctx *swe.Context, id int64, sender *bs.DMSenderInfo) error {
+	if sender == nil {
+		return nil
+	}
+	return batch_dm.GetManager().SetSenderInfo(ctx, id, sender)
+}
 
-	err = batch_dm.GetManager().SetSenderInfo(req.TaskID, &req.Sender)
+func (ins dmHandler) setSender(ctx *swe.Context, req *bs.DMSetSenderReq) (*bs.Nothing, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	task, err := db.GetDirectMsgDAL().GetByRoomID(ctx, req.TaskID, st.RoomID)
 	if err != nil {
+		logger.Error("query task %d for room %d error %v", req.TaskID, st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	if task == nil {
+		logger.Error("query task %d for room %d not found", req.TaskID, st.RoomID)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+
+	if err = db.GetDirectMsgDAL().SetSenderPolicy(ctx, req.TaskID, req.Policy); err != nil {
+		logger.Error("set sender policy for dm task %d failed: %v", req.TaskID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+
+	sender := &req.Sender
+	if req.Policy != db.DM_POLICY_SINGLE {
+		sender = nil
+	}
+	if err = ins.setTaskSender(ctx, req.TaskID, sender); err != nil {
 		logger.Error("set sender info for dm task %d failed: %v", req.TaskID, err)
 		return nil, swe.Error(EC_DM_SET_SENDER_FAIL, err)
 	}
 
 	if req.RunTask {
-		err = batch_dm.GetManager().StartTask(ctx, req.TaskID)
+		err = batch_dm.GetManager().StartTask(ctx, req.TaskID, req.Mode)
 		if err != nil {
 			logger.Error("start dm task %d failed: %v", req.TaskID, err)
 			return nil, swe.Error(EC_DM_START_FAIL, err)
@@ -267,9 +629,13 @@ func (ins dmHandler) setState(ctx *swe.Context, req *bs.DMSwitchReq) (*bs.Nothin
 	}
 
 	if req.RunTask {
-		err = batch_dm.GetManager().StartTask(ctx, req.TaskID)
-	} else {
-		err = batch_dm.GetManager().StopTask(req.TaskID)
+		err = batch_dm.GetManager().StartTask(ctx, req.TaskID, req.Mode)
+	} else if err = batch_dm.GetManager().StopTask(req.TaskID); err == nil {
+		// a stopped task is not retried or waiting for its window until started again
+		err = db.GetDirectMsgDAL().SetRetryTask(ctx, req.TaskID, 0)
+		if err == nil && task.Status == db.DM_TASK_STATUS_WAITING {
+			err = db.GetDirectMsgDAL().UpdateStatus(ctx, req.TaskID, db.DM_TASK_STATUS_PAUSED)
+		}
 	}
 	if err != nil {
 		logger.Error("change dm task %d status failed: %v", req.TaskID, err)
@@ -278,3 +644,227 @@ func (ins dmHandler) setState(ctx *swe.Context, req *bs.DMSwitchReq) (*bs.Nothin
 
 	return &bs.Nothing{}, nil
 }
+
+func (ins dmHandler) transports(ctx *swe.Context, req *bs.Nothing) (*bs.DMTransportsRsp, swe.SweError) {
+	return &bs.DMTransportsRsp{Transports: batch_dm.TransportNames()}, nil
+}
+
+func (ins dmHandler) templateVars(ctx *swe.Context, req *bs.Nothing) (*bs.DMTemplateVarsRsp, swe.SweError) {
+	return &bs.DMTemplateVarsRsp{Vars: batch_dm.TemplateVars()}, nil
+}
+
+// preview renders the template for the first recipients of an event, and lists recipients it fails for
+func (ins dmHandler) preview(ctx *swe.Context, req *bs.DMPreviewReq) (*bs.DMPreviewRsp, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	builder, err := batch_dm.MakeBuilder(req.Content)
+	if err != nil {
+		logger.Error("parse dm template failed: %v", err)
+		return nil, swe.Error(EC_DM_TEMPLATE_INVALID, err)
+	}
+
+	records, buildCtx, sweErr := ins.recipients(ctx, st, req.EventID, req.TLS)
+	if sweErr != nil {
+		return nil, sweErr
+	}
+
+	samples := req.Samples
+	if samples == 0 {
+		samples = 5
+	}
+
+	ret := &bs.DMPreviewRsp{Total: len(records), Samples: []bs.DMPreviewItem{}, Failures: []bs.DMPreviewFailure{}}
+	for idx := range records {
+		item := &records[idx]
+		content, err := builder.BuildContent(ctx, item, buildCtx)
+		if err != nil {
+			ret.Failed++
+			// failures are counted for all, but only the first ones are listed
+			if len(ret.Failures) < 100 {
+				ret.Failures = append(ret.Failures, bs.DMPreviewFailure{UID: item.UID, Name: item.UserName, Reason: err.Error()})
+			}
+			continue
+		}
+		if len(ret.Samples) < samples {
+			ret.Samples = append(ret.Samples, bs.DMPreviewItem{UID: item.UID, Name: item.UserName, Content: content})
+		}
+	}
+
+	return ret, nil
+}
+
+// testSend sends the message of a recipient to the streamer's own account with the sender to launch with
+func (ins dmHandler) testSend(ctx *swe.Context, req *bs.DMTestSendReq) (*bs.DMTestSendRsp, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	builder, err := batch_dm.MakeBuilder(req.Content)
+	if err != nil {
+		logger.Error("parse dm template failed: %v", err)
+		return nil, swe.Error(EC_DM_TEMPLATE_INVALID, err)
+	}
+
+	records, buildCtx, sweErr := ins.recipients(ctx, st, req.EventID, req.TLS)
+	if sweErr != nil {
+		return nil, sweErr
+	}
+
+	var record *db.RewardUser
+	for idx := range records {
+		if req.UID == 0 || records[idx].UID == req.UID {
+			record = &records[idx]
+			break
+		}
+	}
+	if record == nil {
+		logger.Error("recipient %d not found in event %d", req.UID, req.EventID)
+		return nil, swe.Error(EC_DM_TEST_SEND_FAIL, fmt.Errorf("recipient not found"))
+	}
+
+	content, err := builder.BuildContent(ctx, record, buildCtx)
+	if err != nil {
+		logger.Error("generate msg content for uid %d failed: %v", record.UID, err)
+		return nil, swe.Error(EC_DM_TEMPLATE_INVALID, err)
+	}
+
+	// messages through bilibili go to the streamer's own account, other transports send to the sender itself
+	receiver := int64(0)
+	if len(req.Transport) == 0 || req.Transport == batch_dm.DM_TRANSPORT_BILIBILI {
+		info, err := dm.GetRoomInfo(st.RoomID)
+		if err != nil {
+			logger.Error("get room info for live room %d failed: %v", st.RoomID, err)
+			return nil, swe.Error(EC_DM_TEST_SEND_FAIL, err)
+		}
+		receiver = info.Base.Uid
+	}
+
+	task := &db.DMTask{RoomID: st.RoomID, SenderPolicy: req.Policy, Transport: req.Transport}
+	senderUID, receiver, err := batch_dm.GetManager().TestSend(ctx, task, &req.Sender, receiver, content)
+	if errors.Is(err, batch_dm.ErrAccountBusy) {
+		logger.Error("test send dm of room %d with account %d: %v", st.RoomID, senderUID, err)
+		return nil, swe.Error(EC_DM_ACCOUNT_BUSY, err)
+	}
+	if err != nil {
+		logger.Error("test send dm of room %d to %d failed: %v", st.RoomID, receiver, err)
+		return nil, swe.Error(EC_DM_TEST_SEND_FAIL, err)
+	}
+
+	return &bs.DMTestSendRsp{SenderUID: senderUID, ReceiverUID: receiver, Content: content}, nil
+}
+
+// setSchedule changes when task sends, a running task picks it up before its next message
+func (ins dmHandler) setSchedule(ctx *swe.Context, req *bs.DMSetScheduleReq) (*bs.Nothing, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	task, err := db.GetDirectMsgDAL().GetByRoomID(ctx, req.TaskID, st.RoomID)
+	if err != nil {
+		logger.Error("query task %d for room %d error %v", req.TaskID, st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	if task == nil {
+		logger.Error("query task %d for room %d not found", req.TaskID, st.RoomID)
+		return nil, swe.Error(EC_DM_TASK_NOT_FOUND, fmt.Errorf("task %d not found", req.TaskID))
+	}
+
+	window := req.Schedule.Window()
+	if err = db.GetDirectMsgDAL().SetSchedule(ctx, req.TaskID, req.Schedule.StartTs(), window.Start, window.End); err != nil {
+		logger.Error("set schedule for dm task %d error %v", req.TaskID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+
+	// a waiting task is started again to wait for the new schedule
+	if task.Status == db.DM_TASK_STATUS_WAITING {
+		if err = batch_dm.GetManager().StartTask(ctx, req.TaskID, 0); err != nil {
+			logger.Error("restart dm task %d failed: %v", req.TaskID, err)
+			return nil, swe.Error(EC_DM_START_FAIL, err)
+		}
+	}
+
+	return &bs.Nothing{}, nil
+}
+
+func (ins dmHandler) accounts(ctx *swe.Context, req *bs.Nothing) (*bs.PageRsp, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	accounts, err := db.GetDMAccountDAL().List(ctx, st.RoomID)
+	if err != nil {
+		logger.Error("query dm accounts for room %d error %v", st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+
+	rsp := bs.PageRsp{Count: len(accounts), List: []any{}}
+	for _, item := range accounts {
+		tmp := bs.DMAccountItem{
+			ID:        item.ID,
+			UID:       item.UID,
+			Interval:  item.Interval,
+			Status:    item.Status,
+			FailCount: item.FailCount,
+			Reason:    item.Reason,
+		}
+		if item.ExpireTime > 0 {
+			tmp.ExpireTime = utils.TimeToLocalString(item.ExpireTime)
+			tmp.Expired = item.ExpireTime <= time.Now().Unix()
+		}
+		if item.LastUsed > 0 {
+			tmp.LastUsed = utils.TimeToLocalString(item.LastUsed)
+		}
+		rsp.List = append(rsp.List, tmp)
+	}
+
+	return &rsp, nil
+}
+
+func (ins dmHandler) addAccount(ctx *swe.Context, req *bs.DMAccountAddReq) (*bs.Nothing, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	if err := batch_dm.GetManager().AddAccount(ctx, st.RoomID, &req.Sender, req.Interval); err != nil {
+		logger.Error("add dm account %d for room %d failed: %v", req.Sender.UID, st.RoomID, err)
+		return nil, swe.Error(EC_DM_SET_SENDER_FAIL, err)
+	}
+
+	return &bs.Nothing{}, nil
+}
+
+// enableAccount puts a removed account back into pool, or takes one out
+func (ins dmHandler) enableAccount(ctx *swe.Context, req *bs.DMAccountEnableReq) (*bs.Nothing, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	status, reason := db.DM_ACCOUNT_ACTIVE, ""
+	if !req.Enable {
+		status, reason = db.DM_ACCOUNT_REMOVED, "removed by streamer"
+	}
+	ok, err := db.GetDMAccountDAL().SetStatus(ctx, st.RoomID, req.ID, status, reason, time.Now().Unix())
+	if err != nil {
+		logger.Error("set status of dm account %d for room %d error %v", req.ID, st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	if !ok {
+		logger.Error("dm account %d for room %d not found", req.ID, st.RoomID)
+		return nil, swe.Error(EC_DM_ACCOUNT_NOT_FOUND, fmt.Errorf("account not found"))
+	}
+
+	return &bs.Nothing{}, nil
+}
+
+func (ins dmHandler) deleteAccount(ctx *swe.Context, req *bs.IDReq) (*bs.Nothing, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	ok, err := db.GetDMAccountDAL().Delete(ctx, st.RoomID, req.ID)
+	if err != nil {
+		logger.Error("delete dm account %d for room %d error %v", req.ID, st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	if !ok {
+		logger.Error("dm account %d for room %d not found", req.ID, st.RoomID)
+		return nil, swe.Error(EC_DM_ACCOUNT_NOT_FOUND, fmt.Errorf("account not found"))
+	}
+
+	return &bs.Nothing{}, nil
+}
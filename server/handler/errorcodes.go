@@ -7,13 +7,19 @@ const (
 	EC_ADMIN_ROOM_INFO_FAIL      = 1002
 	EC_ADMIN_KEYGEN_FAIL         = 1003
 	EC_ADMIN_DUPLICATED_STREAMER = 1004
+	EC_ADMIN_TASK_NOT_FOUND      = 1005
+	EC_ADMIN_TASK_STATE_INVALID  = 1006
+	EC_ADMIN_CRON_NOT_FOUND      = 1007
 
 	EC_ST_NO_ACCOUNT         = 2001
 	EC_ST_PASSWORD_INCORRECT = 2002
 	EC_ST_DECODE_PUB_FAIL    = 2003
 	EC_ST_BAD_TIMESTAMP      = 2004
+	EC_ST_RANGE_TOO_LARGE    = 2005
 
 	EC_EVT_COND_DECODE_FAIL = 3001
+	EC_EVT_NOT_FAILED       = 3002
+	EC_EVT_CALC_PENDING     = 3003
 
 	EC_DD_CODE_NOT_FOUND     = 4001
 	EC_DD_PASSWORD_INCORRECT = 4002
@@ -21,6 +27,22 @@ const (
 	EC_DD_ADDR_ENC_FAIL      = 4004
 	EC_DD_SET_ADDR_FAIL      = 4005
 
-	EC_DM_SET_SENDER_FAIL = 5001
-	EC_DM_START_FAIL      = 5002
+	EC_DM_SET_SENDER_FAIL     = 5001
+	EC_DM_START_FAIL          = 5002
+	EC_DM_ACCOUNT_NOT_FOUND   = 5003
+	EC_DM_TEMPLATE_INVALID    = 5004
+	EC_DM_TEST_SEND_FAIL      = 5005
+	EC_DM_TRANSPORT_NOT_FOUND = 5006
+	EC_DM_TASK_BUSY           = 5007
+	EC_DM_NO_RECIPIENT        = 5008
+	EC_DM_ACCOUNT_BUSY        = 5009
+	EC_DM_TASK_NOT_FOUND      = 5010
+
+	EC_HOOK_NOT_FOUND        = 6001
+	EC_HOOK_TEMPLATE_INVALID = 6002
+	EC_HOOK_DELIVERY_PENDING = 6003
+
+	EC_OVERLAY_NOT_FOUND = 7001
+
+	EC_INGEST_SAVE_FAIL = 8001
 )
@@ -5,7 +5,6 @@ import (
 	"encoding/csv"
 	"fmt"
 	"net/http"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -27,13 +26,20 @@ func init() {
 	registerHandler(GET, "/event/detail", event.detail, session.CheckStreamer)
 	registerHandler(POST, "/event/delete", event.delete, session.CheckStreamer)
 
+	registerHandler(POST, "/event/retry", event.retry, session.CheckStreamer)
+
 	async_task.RegisterHandler(asyncTaskCalculateEventList, event.calculate)
+	async_task.SetRetryPolicy(asyncTaskCalculateEventList, async_task.RetryPolicy{MaxAttempts: 3, Backoff: 60, MaxBackoff: 600})
+	// calculations are heavy on db, do not let many events ending together run at once
+	async_task.SetConcurrency(asyncTaskCalculateEventList, 2)
 
 	registerHandler(POST, "/event/user/list", event.userList, session.CheckStreamer)
 	registerHandler(POST, "/event/user/block", event.blockUser, session.CheckStreamer)
 	registerHandler(POST, "/event/user/unblock", event.unblockUser, session.CheckStreamer)
 
 	registerRawHandler(GET, "/event/user/dl", event.download, session.CheckStreamer)
+	registerHandler(GET, "/event/user/addr_check", event.addrCheck, session.CheckStreamer)
+	registerHandler(GET, "/event/export/formats", event.exportFormats, session.CheckStreamer)
 }
 
 type eventHandler struct{}
@@ -55,11 +61,12 @@ func (ins eventHandler) list(ctx *swe.Context, req *bs.PageReq) (*bs.PageRsp, sw
 	ret := &bs.PageRsp{Count: count, List: []any{}}
 	for _, item := range list {
 		ret.List = append(ret.List, map[string]any{
-			"id":      item.ID,
-			"name":    item.EventName,
-			"content": item.RewardContent,
-			"hidden":  item.Hidden != 0,
-			"status":  item.Status,
+			"id":       item.ID,
+			"name":     item.EventName,
+			"content":  item.RewardContent,
+			"hidden":   item.Hidden != 0,
+			"status":   item.Status,
+			"progress": ins.progress(&item),
 		})
 	}
 
@@ -103,7 +110,7 @@ func (ins eventHandler) calculate(ctx *swe.Context, taskCtx async_task.TaskConte
 	evtID, err := strconv.ParseInt(taskCtx.Param(), 10, 64)
 	if err != nil {
 		logger.Error("parse event id failed: %v param: %s", err, taskCtx.Param())
-		return err
+		return async_task.Permanent(err)
 	}
 
 	logger.Info("start calculating event list for event %d", evtID)
@@ -116,155 +123,175 @@ func (ins eventHandler) calculate(ctx *swe.Context, taskCtx async_task.TaskConte
 	}
 	if event == nil {
 		logger.Error("find event %d from db failed: not found", evtID)
-		return fmt.Errorf("event not found")
+		return async_task.Permanent(fmt.Errorf("event not found"))
 	}
-	err = db.GetRewardEventDAL().SetStatus(ctx, evtID, db.EVENT_CALCULATING)
+	err = db.GetRewardEventDAL().StartCalc(ctx, evtID, time.Now().Unix())
 	if err != nil {
 		logger.Error("set event status to calculating failed: %v", err)
 		return err
 	}
 
+	// fail keeps the reason on the event so that it can be shown, the event is marked error to be retried
+	// from the UI only if the task is not retried any more
+	fail := func(err error, format string, args ...any) error {
+		reason := fmt.Sprintf(format, args...) + ": " + err.Error()
+		logger.Error("event %d: %s", evtID, reason)
+		mark := db.GetRewardEventDAL().RetryCalc
+		if async_task.IsPermanent(err) || taskCtx.LastAttempt() {
+			mark = db.GetRewardEventDAL().FailCalc
+		}
+		if err := mark(ctx, evtID, reason, time.Now().Unix()); err != nil {
+			logger.Error("set event status to error failed: %v", err)
+		}
+		return err
+	}
+	progress := func(phase int, done, total int64) {
+		if err := db.GetRewardEventDAL().SetProgress(ctx, evtID, phase, done, total); err != nil {
+			logger.Error("set progress for event %d failed: %v", evtID, err)
+		}
+	}
+
 	// decode condition
 	cond := bs.EventCondition{}
 	json := jsoniter.ConfigCompatibleWithStandardLibrary
 	err = json.UnmarshalFromString(event.Conditions, &cond)
 	if err != nil {
-		db.GetRewardEventDAL().SetStatus(ctx, evtID, db.EVENT_ERROR)
-		logger.Error("decode condition failed: %v", err)
-		return err
+		return fail(async_task.Permanent(err), "decode condition failed")
 	}
 	err = cond.Validate(ctx)
 	if err != nil {
-		db.GetRewardEventDAL().SetStatus(ctx, evtID, db.EVENT_ERROR)
-		logger.Error("validate condition failed: %v", err)
-		return err
+		return fail(async_task.Permanent(err), "validate condition failed")
 	}
 
-	logger.Info("loading needed data for event %d", evtID)
+	logger.Info("calculating list for event %d", evtID)
 
-	// load needed data
+	// records are streamed user by user, memory is bounded by a batch of users
 	timeRange := bs.ConditionTimeRange{Range: map[string]bs.TimeRange{}}
 	cond.CalculateRange(&timeRange)
-	users := map[int64]*event_calc.UserData{}
+	stream := event_calc.NewStream(event.RoomID, timeRange.Range)
+	total, err := stream.Total(ctx)
+	if err != nil {
+		return fail(err, "count users failed")
+	}
+	progress(db.EVENT_PHASE_LOADING, 0, total)
 
-	if tr, ok := timeRange.Range["gift"]; ok {
-		rec, err := db.GetGiftDAL().Range(ctx, event.RoomID, tr.Start(), tr.End())
-		if err != nil {
-			db.GetRewardEventDAL().SetStatus(ctx, evtID, db.EVENT_ERROR)
-			logger.Error("load gift record failed: %v", err)
-			return err
-		}
-		logger.Info("%d records of gift loaded", len(rec))
-		for _, item := range rec {
-			if _, ok := users[item.SenderUID]; !ok {
-				users[item.SenderUID] = event_calc.NewEventUser(item.SenderUID)
-			}
-			users[item.SenderUID].Gift = append(users[item.SenderUID].Gift, item)
-		}
+	// delete older list record
+	if err = db.GetRewardEventDAL().ClearUsers(ctx, evtID); err != nil {
+		return fail(err, "clear old list failed")
 	}
-	if tr, ok := timeRange.Range["sc"]; ok {
-		rec, err := db.GetSCDal().Range(ctx, event.RoomID, tr.Start(), tr.End())
+
+	filter := event_calc.BuildFilter(&cond)
+	nowTs := time.Now().Unix()
+	count := 0
+	for {
+		// the instance taking over the task calculates from scratch, stop writing into its list
+		if taskCtx.LeaseLost() {
+			logger.Error("event %d: lease of calculation lost, give up", evtID)
+			return async_task.ErrLeaseLost
+		}
+		users, err := stream.Next(ctx)
 		if err != nil {
-			db.GetRewardEventDAL().SetStatus(ctx, evtID, db.EVENT_ERROR)
-			logger.Error("load sc record failed: %v", err)
-			return err
+			return fail(err, "load records failed")
 		}
-		logger.Info("%d records of super chat loaded", len(rec))
-		for _, item := range rec {
-			if _, ok := users[item.SenderUID]; !ok {
-				users[item.SenderUID] = event_calc.NewEventUser(item.SenderUID)
-			}
-			users[item.SenderUID].SC = append(users[item.SenderUID].SC, item)
+		if len(users) == 0 {
+			break
 		}
-	}
-	if tr, ok := timeRange.Range["member"]; ok {
-		rec, err := db.GetMemberDal().Range(ctx, event.RoomID, tr.Start(), tr.End())
-		if err != nil {
-			db.GetRewardEventDAL().SetStatus(ctx, evtID, db.EVENT_ERROR)
-			logger.Error("load membership record failed: %v", err)
-			return err
+
+		// filter sender
+		progress(db.EVENT_PHASE_FILTERING, stream.Done()-int64(len(users)), total)
+		data := []db.RewardUser{}
+		dd := []db.DDInfo{}
+		for _, user := range users {
+			strip := event_calc.NewEventUserStrip()
+			if !filter.OK(user, strip) {
+				continue
+			}
+			user.Strip(strip)
+			data = append(data, db.RewardUser{
+				EventID:  evtID,
+				UID:      user.UID,
+				UserName: user.Name,
+				Time:     user.SendTs,
+				Columns:  user.Column(),
+			})
+			dd = append(dd, db.DDInfo{
+				UID:        user.UID,
+				UserName:   user.Name,
+				AccessCode: db.GetDDInfoDAL().GenerateAccessCode(nowTs, evtID, user.UID),
+			})
 		}
-		logger.Info("%d records of membership loaded", len(rec))
-		for _, item := range rec {
-			if _, ok := users[item.SenderUID]; !ok {
-				users[item.SenderUID] = event_calc.NewEventUser(item.SenderUID)
+
+		// insert new list record & create dd accounts
+		if len(data) > 0 {
+			progress(db.EVENT_PHASE_WRITING, stream.Done()-int64(len(users)), total)
+			if err = db.GetRewardEventDAL().PutUsers(ctx, data); err != nil {
+				return fail(err, "write new list failed")
+			}
+			if err = db.GetDDInfoDAL().BatchCreate(ctx, dd); err != nil {
+				return fail(err, "create dd accounts failed")
 			}
-			users[item.SenderUID].Member = append(users[item.SenderUID].Member, item)
 		}
+		count += len(data)
+
+		progress(db.EVENT_PHASE_LOADING, stream.Done(), total)
 	}
 
-	logger.Info("filtering data for event %d", evtID)
+	logger.Info("%d of %d users after filter, event %d", count, total, evtID)
 
-	// filter sender
-	filter := event_calc.BuildFilter(&cond)
-	tmp := users
-	users = map[int64]*event_calc.UserData{}
-	for uid, data := range tmp {
-		strip := event_calc.NewEventUserStrip()
-		if filter.OK(data, strip) {
-			users[uid] = data.Strip(strip)
-		}
+	if taskCtx.LeaseLost() {
+		logger.Error("event %d: lease of calculation lost, give up", evtID)
+		return async_task.ErrLeaseLost
 	}
 
-	logger.Info("%d users after filter, event %d", len(users), evtID)
-
-	// delete older list record
-	if err = db.GetRewardEventDAL().ClearUsers(ctx, evtID); err != nil {
-		db.GetRewardEventDAL().SetStatus(ctx, evtID, db.EVENT_ERROR)
-		logger.Error("clear old list for event %d failed: %v", evtID, err)
+	// set status to ready
+	if err = db.GetRewardEventDAL().FinishCalc(ctx, evtID, time.Now().Unix()); err != nil {
+		logger.Error("set event status to ready failed: %v ", err)
 		return err
 	}
 
-	// insert new list record
-	uids := make([]int64, 0, len(users))
-	for uid := range users {
-		uids = append(uids, uid)
-	}
-	sort.Slice(uids, func(i, j int) bool {
-		return users[uids[i]].SendTs < users[uids[j]].SendTs
-	})
-
-	data := make([]db.RewardUser, 0, len(users))
-	for _, uid := range uids {
-		data = append(data, db.RewardUser{
-			EventID:  evtID,
-			UID:      uid,
-			UserName: users[uid].Name,
-			Time:     users[uid].SendTs,
-			Columns:  users[uid].Column(),
-		})
-	}
+	logger.Info("list calculation for event %d done", evtID)
+	return nil
+}
 
-	if err = db.GetRewardEventDAL().PutUsers(ctx, data); err != nil {
-		db.GetRewardEventDAL().SetStatus(ctx, evtID, db.EVENT_ERROR)
-		logger.Error("write new list for event %d failed: %v", evtID, err)
-		return err
+// retry schedules list calculation again for events failed to calculate
+func (ins eventHandler) retry(ctx *swe.Context, req *bs.IDReq) (*bs.Nothing, swe.SweError) {
+	st, _ := session.GetStreamerSession(ctx)
+	event, err := db.GetRewardEventDAL().GetByRoomID(ctx, req.ID, st.RoomID)
+	if err != nil {
+		swe.CtxLogger(ctx).Error("query db for event %d error %v", req.ID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
 	}
-
-	// create dd accounts
-	nowTs := time.Now().Unix()
-	dd := make([]db.DDInfo, 0, len(users))
-	for _, uid := range uids {
-		dd = append(dd, db.DDInfo{
-			UID:        uid,
-			UserName:   users[uid].Name,
-			AccessCode: db.GetDDInfoDAL().GenerateAccessCode(nowTs, evtID, uid),
-		})
+	if event == nil {
+		swe.CtxLogger(ctx).Error("query db for event %d room id %d not found", req.ID, st.RoomID)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, fmt.Errorf("event not found"))
 	}
-	if err = db.GetDDInfoDAL().BatchCreate(ctx, dd); err != nil {
-		db.GetRewardEventDAL().SetStatus(ctx, evtID, db.EVENT_ERROR)
-		logger.Error("create dd accounts for event %d failed: %v", evtID, err)
-		return err
+	// events left retrying by a task died for good, e.g. with its instance, can be retried too
+	if event.Status != db.EVENT_ERROR && event.Status != db.EVENT_RETRYING {
+		swe.CtxLogger(ctx).Error("retry event %d with status %d", req.ID, event.Status)
+		return nil, swe.Error(EC_EVT_NOT_FAILED, fmt.Errorf("event not failed"))
+	}
+	// a calculation still queued or running, e.g. one left by a lost lease, would race with a new one
+	pending, err := db.GetAsyncTaskDAL().Unfinished(ctx, asyncTaskCalculateEventList, fmt.Sprint(event.ID))
+	if err != nil {
+		swe.CtxLogger(ctx).Error("query calculation tasks for event %d error %v", req.ID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	if pending {
+		swe.CtxLogger(ctx).Error("retry event %d with calculation task pending", req.ID)
+		return nil, swe.Error(EC_EVT_CALC_PENDING, fmt.Errorf("calculation of event is pending"))
 	}
 
-	// set status to ready
-	if err = db.GetRewardEventDAL().SetStatus(ctx, evtID, db.EVENT_READY); err != nil {
-		logger.Error("set event status to ready failed: %v ", err)
-		return err
+	if err = db.GetRewardEventDAL().SetStatus(ctx, req.ID, db.EVENT_IDLE); err != nil {
+		swe.CtxLogger(ctx).Error("reset status for event %d error %v", req.ID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	err = async_task.GetScheduler().AddTask(ctx, asyncTaskCalculateEventList, fmt.Sprint(event.ID), time.Now().Unix(), nil)
+	if err != nil {
+		swe.CtxLogger(ctx).Error("create async task error %v", err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
 	}
 
-	logger.Info("list calculation for event %d done", evtID)
-	return nil
+	return &bs.Nothing{}, nil
 }
 
 func (ins eventHandler) modify(ctx *swe.Context, req *bs.EventModifyReq) (*bs.Nothing, swe.SweError) {
@@ -295,6 +322,7 @@ func (ins eventHandler) detail(ctx *swe.Context, req *bs.IDReq) (*bs.EventDetail
 		Status: event.Status,
 		Hidden: event.Hidden != 0,
 	}
+	ret.Progress = ins.progress(event)
 
 	json := jsoniter.ConfigCompatibleWithStandardLibrary
 	err = json.UnmarshalFromString(event.Conditions, &ret.Condition)
@@ -306,6 +334,22 @@ func (ins eventHandler) detail(ctx *swe.Context, req *bs.IDReq) (*bs.EventDetail
 	return ret, nil
 }
 
+func (ins eventHandler) progress(event *db.RewardEvent) bs.EventProgress {
+	ret := bs.EventProgress{
+		Phase: event.CalcPhase,
+		Done:  event.CalcDone,
+		Total: event.CalcTotal,
+		Error: event.CalcError,
+	}
+	if event.CalcStart > 0 {
+		ret.StartTime = utils.TimeToLocalString(event.CalcStart)
+	}
+	if event.CalcEnd > 0 {
+		ret.EndTime = utils.TimeToLocalString(event.CalcEnd)
+	}
+	return ret
+}
+
 func (ins eventHandler) delete(ctx *swe.Context, req *bs.IDReq) (*bs.Nothing, swe.SweError) {
 	st, _ := session.GetStreamerSession(ctx)
 	rows, err := db.GetRewardEventDAL().Delete(ctx, req.ID, st.RoomID)
@@ -341,11 +385,22 @@ func (ins eventHandler) userList(ctx *swe.Context, req *bs.EventUserListReq) (*b
 	}
 	ret := &bs.PageRsp{Count: count, List: []any{}}
 
+	uids := make([]int64, 0, len(users))
+	for _, item := range users {
+		uids = append(uids, item.UID)
+	}
+	names, err := loadNameHistory(ctx, uids)
+	if err != nil {
+		swe.CtxLogger(ctx).Error("query name history for event %d error %v", req.EventID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+
 	for _, item := range users {
 		eu, _ := event_calc.EventUserfromDB(&item)
 		user := bs.EventUserListItem{
 			UID:   item.UID,
 			Name:  item.UserName,
+			Names: names[item.UID],
 			Time:  utils.TimeToCSTString(item.Time),
 			Cols:  map[string]any{},
 			Block: item.Blocked != 0,
@@ -439,7 +494,7 @@ func (ins eventHandler) unblockUser(ctx *swe.Context, req *bs.EventUIDReq) (*bs.
 
 func (ins eventHandler) download(ctx *swe.Context) {
 	logger := swe.CtxLogger(ctx)
-	req := bs.IDReq{}
+	req := bs.EventDownloadReq{}
 	if err := swe.DecodeForm(ctx.Request, &req); err != nil {
 		ctx.Response.WriteHeader(http.StatusBadRequest)
 		ctx.Response.Write([]byte(err.Error()))
@@ -466,53 +521,13 @@ func (ins eventHandler) download(ctx *swe.Context) {
 		return
 	}
 
-	// get users
-	users, err := db.GetRewardEventDAL().Users(ctx, req.ID)
+	// load users & decrypt user address
+	userDatas, addrs, err := ins.loadUsersWithAddr(ctx, event)
 	if err != nil {
-		logger.Error("load users for event %d failed: %v", req.ID, err)
 		ctx.Response.WriteHeader(http.StatusInternalServerError)
 		ctx.Response.Write([]byte(err.Error()))
 		return
 	}
-
-	// get user public keys
-	uids := make([]int64, 0, len(users))
-	for _, item := range users {
-		if item.Blocked != 0 {
-			continue
-		}
-		uids = append(uids, item.UID)
-	}
-	keyMap, err := db.GetDDInfoDAL().GetPublicKeys(ctx, uids)
-	if err != nil {
-		logger.Error("query public keys for users in event %d failed: %v", req.ID, err)
-		ctx.Response.WriteHeader(http.StatusInternalServerError)
-		ctx.Response.Write([]byte(err.Error()))
-		return
-	}
-
-	// convert users & decrypt user address
-	userDatas := make([]*event_calc.UserData, 0, len(users))
-	addrs := event_calc.AddrMap{}
-	for _, item := range users {
-		if item.Blocked != 0 {
-			continue
-		}
-		data, err := event_calc.EventUserfromDB(&item)
-		if err != nil {
-			logger.Error("convert user %d for event %d failed: %v, skip user ...", item.UID, req.ID, err)
-			continue
-		}
-		userDatas = append(userDatas, data)
-		if key, ok := keyMap[item.UID]; ok {
-			addr, err := utils.DecryptUserAddress(ctx, key, item.AddressInfo)
-			if err != nil {
-				logger.Error("decrypt address info for user %d in event %d failed: %v", item.UID, req.ID, err)
-			} else {
-				addrs[item.UID] = addr
-			}
-		}
-	}
 	ctx.Put(event_calc.CTX_KEY_ADDR, addrs)
 
 	// decode condition
@@ -533,8 +548,31 @@ func (ins eventHandler) download(ctx *swe.Context) {
 		return
 	}
 
+	// choose columns, courier formats only contain users with address
+	pickers := event_calc.BuildPickers(ctx, &cond)
+	fileName := filterFileName(strings.Join([]string{st.StreamerName, event.EventName}, "_"))
+	if len(req.Format) > 0 {
+		tmp, ok := event_calc.BuildCourierPickers(ctx, req.Format, event.RewardContent)
+		if !ok {
+			logger.Error("unknown export format %s", req.Format)
+			ctx.Response.WriteHeader(http.StatusBadRequest)
+			ctx.Response.Write([]byte(`unknown export format`))
+			return
+		}
+		pickers = tmp
+		fileName = filterFileName(strings.Join([]string{st.StreamerName, event.EventName, req.Format}, "_"))
+
+		withAddr := make([]*event_calc.UserData, 0, len(userDatas))
+		for _, item := range userDatas {
+			if _, ok := addrs[item.UID]; ok {
+				withAddr = append(withAddr, item)
+			}
+		}
+		userDatas = withAddr
+	}
+
 	// generate csv lines
-	lines := event_calc.Table(ctx, userDatas, event_calc.BuildPickers(ctx, &cond))
+	lines := event_calc.Table(ctx, userDatas, pickers)
 	csvData := bytes.Buffer{}
 	csvData.Write([]byte{0xEF, 0xBB, 0xBF}) // UTF8 BOM
 	err = csv.NewWriter(&csvData).WriteAll(lines)
@@ -545,15 +583,118 @@ func (ins eventHandler) download(ctx *swe.Context) {
 		return
 	}
 
-	// generate file name
-	fileName := filterFileName(strings.Join([]string{st.StreamerName, event.EventName}, "_"))
-
 	// set header & write csv data
 	ctx.Response.Header().Set("Content-Type", "application/octet-stream")
 	ctx.Response.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.csv"`, fileName))
 	ctx.Response.Write(csvData.Bytes())
 }
 
+func (ins eventHandler) addrCheck(ctx *swe.Context, req *bs.IDReq) (*bs.PageRsp, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	event, err := db.GetRewardEventDAL().GetByRoomID(ctx, req.ID, st.RoomID)
+	if err != nil {
+		logger.Error("query event %d error %v", req.ID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	if event == nil {
+		logger.Error("query event %d not exist", req.ID)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, fmt.Errorf("event not found"))
+	}
+
+	userDatas, addrs, err := ins.loadUsersWithAddr(ctx, event)
+	if err != nil {
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+
+	// only users with malformed address are listed
+	ret := &bs.PageRsp{List: []any{}}
+	for _, user := range userDatas {
+		addr, ok := addrs[user.UID]
+		if !ok {
+			continue
+		}
+		problems := utils.CheckShippingAddress(addr)
+		if len(problems) == 0 {
+			continue
+		}
+		parsed := utils.ParseChineseAddress(addr.Addr)
+		ret.List = append(ret.List, bs.EventAddrCheckItem{
+			UID:      user.UID,
+			Name:     user.Name,
+			Province: parsed.Province,
+			City:     parsed.City,
+			District: parsed.District,
+			Detail:   parsed.Detail,
+			Problems: problems,
+		})
+	}
+	ret.Count = len(ret.List)
+
+	return ret, nil
+}
+
+func (ins eventHandler) exportFormats(ctx *swe.Context, req *bs.Nothing) (*bs.PageRsp, swe.SweError) {
+	ret := &bs.PageRsp{List: []any{}}
+	for _, item := range event_calc.CourierFormats() {
+		ret.List = append(ret.List, item)
+	}
+	ret.Count = len(ret.List)
+	return ret, nil
+}
+
+// loadUsersWithAddr loads unblocked users of event and decrypts their address info with streamer's key
+func (ins eventHandler) loadUsersWithAddr(ctx *swe.Context, event *db.RewardEvent) ([]*event_calc.UserData, event_calc.AddrMap, error) {
+	logger := swe.CtxLogger(ctx)
+
+	// get users
+	users, err := db.GetRewardEventDAL().Users(ctx, event.ID)
+	if err != nil {
+		logger.Error("load users for event %d failed: %v", event.ID, err)
+		return nil, nil, err
+	}
+
+	// get user public keys
+	uids := make([]int64, 0, len(users))
+	for _, item := range users {
+		if item.Blocked != 0 {
+			continue
+		}
+		uids = append(uids, item.UID)
+	}
+	keyMap, err := db.GetDDInfoDAL().GetPublicKeys(ctx, uids)
+	if err != nil {
+		logger.Error("query public keys for users in event %d failed: %v", event.ID, err)
+		return nil, nil, err
+	}
+
+	// convert users & decrypt user address
+	userDatas := make([]*event_calc.UserData, 0, len(users))
+	addrs := event_calc.AddrMap{}
+	for _, item := range users {
+		if item.Blocked != 0 {
+			continue
+		}
+		data, err := event_calc.EventUserfromDB(&item)
+		if err != nil {
+			logger.Error("convert user %d for event %d failed: %v, skip user ...", item.UID, event.ID, err)
+			continue
+		}
+		userDatas = append(userDatas, data)
+		if key, ok := keyMap[item.UID]; ok && len(item.AddressInfo) > 0 {
+			addr, err := utils.DecryptUserAddress(ctx, key, item.AddressInfo)
+			if err != nil {
+				logger.Error("decrypt address info for user %d in event %d failed: %v", item.UID, event.ID, err)
+			} else {
+				addrs[item.UID] = addr
+			}
+		}
+	}
+
+	return userDatas, addrs, nil
+}
+
 func filterFileName(value string) string {
 	ret := strings.Builder{}
 
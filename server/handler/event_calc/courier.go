@@ -0,0 +1,193 @@
+package event_calc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zerozwt/octant/server/utils"
+	"github.com/zerozwt/swe"
+)
+
+func userAddress(ctx *swe.Context, user *UserData) (*utils.RewardUserAddress, bool) {
+	addr, ok := swe.CtxValue[AddrMap](ctx, CTX_KEY_ADDR)
+	if !ok {
+		return nil, false
+	}
+	item, ok := addr[user.UID]
+	return item, ok
+}
+
+func userParsedAddress(ctx *swe.Context, user *UserData) utils.ChineseAddress {
+	if item, ok := userAddress(ctx, user); ok {
+		return utils.ParseChineseAddress(item.Addr)
+	}
+	return utils.ChineseAddress{}
+}
+
+// -----------------------------------------------------------------
+
+// orderIDPicker is uidPicker without the leading quote, courier systems take it literally
+type orderIDPicker struct{}
+
+func (p orderIDPicker) Pick(ctx *swe.Context, user *UserData) string { return fmt.Sprint(user.UID) }
+func (p orderIDPicker) Header(ctx *swe.Context) string               { return "订单号" }
+
+type recvMobilePicker struct{}
+
+func (p recvMobilePicker) Pick(ctx *swe.Context, user *UserData) string {
+	if item, ok := userAddress(ctx, user); ok {
+		return utils.NormalizeMobile(item.Phone)
+	}
+	return ""
+}
+
+func (p recvMobilePicker) Header(ctx *swe.Context) string { return "收件人手机" }
+
+type recvProvincePicker struct{}
+
+func (p recvProvincePicker) Pick(ctx *swe.Context, user *UserData) string {
+	return userParsedAddress(ctx, user).Province
+}
+
+func (p recvProvincePicker) Header(ctx *swe.Context) string { return "省" }
+
+type recvCityPicker struct{}
+
+func (p recvCityPicker) Pick(ctx *swe.Context, user *UserData) string {
+	return userParsedAddress(ctx, user).City
+}
+
+func (p recvCityPicker) Header(ctx *swe.Context) string { return "市" }
+
+type recvDistrictPicker struct{}
+
+func (p recvDistrictPicker) Pick(ctx *swe.Context, user *UserData) string {
+	return userParsedAddress(ctx, user).District
+}
+
+func (p recvDistrictPicker) Header(ctx *swe.Context) string { return "区/县" }
+
+type recvDetailPicker struct{}
+
+func (p recvDetailPicker) Pick(ctx *swe.Context, user *UserData) string {
+	return userParsedAddress(ctx, user).Detail
+}
+
+func (p recvDetailPicker) Header(ctx *swe.Context) string { return "详细地址" }
+
+type addrCheckPicker struct{}
+
+func (p addrCheckPicker) Pick(ctx *swe.Context, user *UserData) string {
+	item, ok := userAddress(ctx, user)
+	if !ok {
+		return "未填写地址"
+	}
+	return strings.Join(utils.CheckShippingAddress(item), "；")
+}
+
+func (p addrCheckPicker) Header(ctx *swe.Context) string { return "地址校验" }
+
+// renamePicker shows the value of another picker under a courier specific header
+type renamePicker struct {
+	picker Picker
+	header string
+}
+
+func (p renamePicker) Pick(ctx *swe.Context, user *UserData) string { return p.picker.Pick(ctx, user) }
+func (p renamePicker) Header(ctx *swe.Context) string               { return p.header }
+
+type constPicker struct {
+	value  string
+	header string
+}
+
+func (p constPicker) Pick(ctx *swe.Context, user *UserData) string { return p.value }
+func (p constPicker) Header(ctx *swe.Context) string               { return p.header }
+
+// -----------------------------------------------------------------
+
+// courierPresets are column layouts of the bulk import templates provided by couriers,
+// goods is filled into the column describing what's in the parcel
+var courierPresets map[string]func(goods string) []Picker = map[string]func(goods string) []Picker{
+	"sf": func(goods string) []Picker {
+		return []Picker{
+			renamePicker{orderIDPicker{}, "用户订单号"},
+			renamePicker{recvNamePicker{}, "收件人姓名"},
+			renamePicker{recvMobilePicker{}, "收件人手机"},
+			renamePicker{recvProvincePicker{}, "收件省"},
+			renamePicker{recvCityPicker{}, "收件市"},
+			renamePicker{recvDistrictPicker{}, "收件区/县"},
+			renamePicker{recvDetailPicker{}, "收件详细地址"},
+			constPicker{goods, "托寄物内容"},
+			constPicker{"1", "托寄物数量"},
+		}
+	},
+	"zto": func(goods string) []Picker {
+		return []Picker{
+			renamePicker{orderIDPicker{}, "订单号"},
+			renamePicker{recvNamePicker{}, "收件人"},
+			renamePicker{recvMobilePicker{}, "收件人手机"},
+			renamePicker{recvProvincePicker{}, "收件省份"},
+			renamePicker{recvCityPicker{}, "收件城市"},
+			renamePicker{recvDistrictPicker{}, "收件区县"},
+			renamePicker{recvDetailPicker{}, "收件详细地址"},
+			constPicker{goods, "物品名称"},
+			constPicker{"1", "件数"},
+		}
+	},
+	"yto": func(goods string) []Picker {
+		return []Picker{
+			renamePicker{orderIDPicker{}, "客户订单号"},
+			renamePicker{recvNamePicker{}, "收件人姓名"},
+			renamePicker{recvMobilePicker{}, "收件人电话"},
+			renamePicker{recvProvincePicker{}, "收件人省"},
+			renamePicker{recvCityPicker{}, "收件人市"},
+			renamePicker{recvDistrictPicker{}, "收件人区"},
+			renamePicker{recvDetailPicker{}, "收件人详细地址"},
+			constPicker{goods, "物品名称"},
+		}
+	},
+	"jd": func(goods string) []Picker {
+		return []Picker{
+			renamePicker{orderIDPicker{}, "商家订单号"},
+			renamePicker{recvNamePicker{}, "收件人姓名"},
+			renamePicker{recvMobilePicker{}, "收件人手机"},
+			renamePicker{recvProvincePicker{}, "收件人省"},
+			renamePicker{recvCityPicker{}, "收件人市"},
+			renamePicker{recvDistrictPicker{}, "收件人区/县"},
+			renamePicker{recvDetailPicker{}, "收件人详细地址"},
+			constPicker{goods, "托寄物"},
+			constPicker{"1", "包裹数"},
+		}
+	},
+	"ems": func(goods string) []Picker {
+		return []Picker{
+			renamePicker{orderIDPicker{}, "订单号"},
+			renamePicker{recvNamePicker{}, "收件人姓名"},
+			renamePicker{recvMobilePicker{}, "收件人手机"},
+			renamePicker{recvProvincePicker{}, "收件省"},
+			renamePicker{recvCityPicker{}, "收件市"},
+			renamePicker{recvDistrictPicker{}, "收件区县"},
+			renamePicker{recvDetailPicker{}, "收件地址"},
+			constPicker{goods, "内件名称"},
+		}
+	},
+}
+
+func CourierFormats() []string {
+	ret := make([]string, 0, len(courierPresets))
+	for name := range courierPresets {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func BuildCourierPickers(ctx *swe.Context, format, goods string) ([]Picker, bool) {
+	preset, ok := courierPresets[strings.ToLower(format)]
+	if !ok {
+		return nil, false
+	}
+	return preset(goods), true
+}
@@ -0,0 +1,99 @@
+package event_calc
+
+import (
+	"math"
+
+	"github.com/zerozwt/octant/server/bs"
+	"github.com/zerozwt/octant/server/db"
+	"github.com/zerozwt/swe"
+)
+
+// users loaded at a time, records in memory are limited to what these users paid in the time range
+const STREAM_BATCH_USERS = 500
+
+// Stream loads paid records user by user in batches ordered by uid, so that
+// calculating events of big rooms over long time ranges does not need all records in memory
+type Stream struct {
+	roomID int64
+	ranges map[string]bs.TimeRange
+	after  int64
+	done   int64
+}
+
+func NewStream(roomID int64, ranges map[string]bs.TimeRange) *Stream {
+	return &Stream{
+		roomID: roomID,
+		ranges: ranges,
+		after:  math.MinInt64,
+	}
+}
+
+func (s *Stream) payRanges() []db.PayRange {
+	ret := make([]db.PayRange, 0, len(s.ranges))
+	for kind, tr := range s.ranges {
+		ret = append(ret, db.PayRange{Kind: kind, Begin: tr.Start(), End: tr.End()})
+	}
+	return ret
+}
+
+// Total counts users to be loaded
+func (s *Stream) Total(ctx *swe.Context) (int64, error) {
+	return db.GetFanDAL().CountPayers(ctx, s.roomID, s.payRanges())
+}
+
+// Done returns the number of users loaded so far
+func (s *Stream) Done() int64 { return s.done }
+
+// Next loads the next batch of users with all their records in time ranges, empty when finished
+func (s *Stream) Next(ctx *swe.Context) ([]*UserData, error) {
+	uids, err := db.GetFanDAL().NextPayers(ctx, s.roomID, s.payRanges(), s.after, STREAM_BATCH_USERS)
+	if err != nil || len(uids) == 0 {
+		return nil, err
+	}
+	uidFrom, uidTo := uids[0], uids[len(uids)-1]
+
+	users := make(map[int64]*UserData, len(uids))
+	ret := make([]*UserData, 0, len(uids))
+	for _, uid := range uids {
+		users[uid] = NewEventUser(uid)
+		ret = append(ret, users[uid])
+	}
+
+	if tr, ok := s.ranges["gift"]; ok {
+		rec, err := db.GetGiftDAL().UsersRange(ctx, s.roomID, tr.Start(), tr.End(), uidFrom, uidTo)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range rec {
+			if user, ok := users[item.SenderUID]; ok {
+				user.Gift = append(user.Gift, item)
+			}
+		}
+	}
+	if tr, ok := s.ranges["sc"]; ok {
+		rec, err := db.GetSCDal().UsersRange(ctx, s.roomID, tr.Start(), tr.End(), uidFrom, uidTo)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range rec {
+			if user, ok := users[item.SenderUID]; ok {
+				user.SC = append(user.SC, item)
+			}
+		}
+	}
+	if tr, ok := s.ranges["member"]; ok {
+		rec, err := db.GetMemberDal().UsersRange(ctx, s.roomID, tr.Start(), tr.End(), uidFrom, uidTo)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range rec {
+			if user, ok := users[item.SenderUID]; ok {
+				user.Member = append(user.Member, item)
+			}
+		}
+	}
+
+	s.after = uidTo
+	s.done += int64(len(uids))
+	return ret, nil
+}
@@ -54,28 +54,31 @@ func (user *UserData) Strip(strip *UserStrip) *UserData {
 		user.SC = stripArray(user.SC, strip.sc)
 		user.Member = stripArray(user.Member, strip.member)
 
+		// name is taken from the latest record, fans may rename during the event
+		nameTs := int64(0)
 		for _, item := range user.Gift {
-			if user.SendTs == 0 || user.SendTs > item.SendTime {
-				user.SendTs = item.SendTime
-			}
-			user.Name = item.SenderName
+			user.touch(item.SendTime, item.SenderName, &nameTs)
 		}
 		for _, item := range user.SC {
-			if user.SendTs == 0 || user.SendTs > item.SendTime {
-				user.SendTs = item.SendTime
-			}
-			user.Name = item.SenderName
+			user.touch(item.SendTime, item.SenderName, &nameTs)
 		}
 		for _, item := range user.Member {
-			if user.SendTs == 0 || user.SendTs > item.SendTime {
-				user.SendTs = item.SendTime
-			}
-			user.Name = item.SenderName
+			user.touch(item.SendTime, item.SenderName, &nameTs)
 		}
 	}
 	return user
 }
 
+func (user *UserData) touch(ts int64, name string, nameTs *int64) {
+	if user.SendTs == 0 || user.SendTs > ts {
+		user.SendTs = ts
+	}
+	if ts >= *nameTs {
+		user.Name = name
+		*nameTs = ts
+	}
+}
+
 func (user *UserData) Column() string {
 	json := jsoniter.ConfigCompatibleWithStandardLibrary
 	data := map[string]any{}
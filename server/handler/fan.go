@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/zerozwt/octant/server/bs"
+	"github.com/zerozwt/octant/server/db"
+	"github.com/zerozwt/octant/server/session"
+	"github.com/zerozwt/octant/server/utils"
+	"github.com/zerozwt/swe"
+)
+
+func init() {
+	registerHandler(POST, "/fan/profile", fan.profile, session.CheckStreamer)
+}
+
+type fanHandler struct{}
+
+var fan fanHandler
+
+func (ins fanHandler) profile(ctx *swe.Context, req *bs.FanProfileReq) (*bs.FanProfileRsp, swe.SweError) {
+	if err := req.ParseTimeRange(); err != nil {
+		return nil, swe.Error(EC_ST_BAD_TIMESTAMP, err)
+	}
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	ret := &bs.FanProfileRsp{
+		UID:    req.UID,
+		Guards: []bs.FanGuardItem{},
+		Names:  []bs.NameHistoryItem{},
+		Events: []bs.FanEventItem{},
+	}
+
+	// pay summaries
+	lifetime, firstTs, lastTs, err := ins.summary(ctx, st.RoomID, req.UID, 0, time.Now().Unix())
+	if err != nil {
+		logger.Error("query pay summary for uid %d error %v", req.UID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	ret.Lifetime = lifetime
+	if firstTs > 0 {
+		ret.FirstSeen = utils.TimeToLocalString(firstTs)
+		ret.LastSeen = utils.TimeToLocalString(lastTs)
+	}
+
+	if req.HasPeriod() {
+		period, _, _, err := ins.summary(ctx, st.RoomID, req.UID, req.StartTs(), req.EndTs())
+		if err != nil {
+			logger.Error("query period pay summary for uid %d error %v", req.UID, err)
+			return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+		}
+		ret.Period = &period
+	}
+
+	// guard history
+	guards, err := db.GetMemberDal().UserRecords(ctx, st.RoomID, req.UID)
+	if err != nil {
+		logger.Error("query guard records for uid %d error %v", req.UID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	for _, item := range guards {
+		ret.Guards = append(ret.Guards, bs.FanGuardItem{
+			Time:  utils.TimeToLocalString(item.SendTime),
+			Level: item.GuardLevel,
+			Count: item.Count,
+		})
+	}
+
+	// name history, same as search & event lists
+	history, err := db.GetUserNameDAL().BatchHistory(ctx, []int64{req.UID})
+	if err != nil {
+		logger.Error("query name history for uid %d error %v", req.UID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	for _, item := range history[req.UID] {
+		ret.Names = append(ret.Names, bs.NameHistoryItem{
+			Name:      item.Name,
+			FirstSeen: utils.TimeToLocalString(item.FirstTs),
+			LastSeen:  utils.TimeToLocalString(item.LastTs),
+		})
+	}
+	if len(ret.Names) > 0 {
+		// ordered by last used time desc
+		ret.Name = ret.Names[0].Name
+	}
+
+	// events won & fulfillment
+	events, err := db.GetRewardEventDAL().UserRecordsByRoom(ctx, req.UID, st.RoomID)
+	if err != nil {
+		logger.Error("query event records for uid %d error %v", req.UID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	eids := make([]int64, 0, len(events))
+	for _, item := range events {
+		eids = append(eids, item.EventID)
+	}
+	dms, err := db.GetDirectMsgDAL().UserDetailsByEvents(ctx, req.UID, eids)
+	if err != nil {
+		logger.Error("query dm records for uid %d error %v", req.UID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	dmMap := map[int64][]bs.FanDMItem{}
+	for _, item := range dms {
+		dm := bs.FanDMItem{TaskID: item.TaskID, Status: item.Status}
+		if item.SendTime > 0 {
+			dm.Time = utils.TimeToLocalString(item.SendTime)
+		}
+		dmMap[item.EventID] = append(dmMap[item.EventID], dm)
+	}
+
+	for _, item := range events {
+		evt := bs.FanEventItem{
+			ID:     item.EventID,
+			Name:   item.EventName,
+			Reward: item.Reward,
+			Time:   utils.TimeToLocalString(item.Time),
+			Block:  item.Blocked != 0,
+			Addr:   item.HasAddr != 0,
+			DMs:    dmMap[item.EventID],
+		}
+		if evt.DMs == nil {
+			evt.DMs = []bs.FanDMItem{}
+		}
+		ret.Events = append(ret.Events, evt)
+	}
+
+	return ret, nil
+}
+
+// summary aggregates paid records of uid in [tsBegin, tsEnd], also returns first & last paid time
+func (ins fanHandler) summary(ctx *swe.Context, roomID, uid, tsBegin, tsEnd int64) (bs.FanPaySummary, int64, int64, error) {
+	ret := bs.FanPaySummary{}
+
+	gift, err := db.GetGiftDAL().UserSummary(ctx, roomID, uid, tsBegin, tsEnd)
+	if err != nil {
+		return ret, 0, 0, err
+	}
+	sc, err := db.GetSCDal().UserSummary(ctx, roomID, uid, tsBegin, tsEnd)
+	if err != nil {
+		return ret, 0, 0, err
+	}
+	guard, err := db.GetMemberDal().UserSummary(ctx, roomID, uid, tsBegin, tsEnd)
+	if err != nil {
+		return ret, 0, 0, err
+	}
+
+	ret.GiftCount, ret.GiftValue = gift.Count, gift.Value
+	ret.SCCount, ret.SCValue = sc.Count, sc.Value
+	ret.GuardCount, ret.GuardMonths = guard.Count, guard.Value
+
+	firstTs, lastTs := int64(0), int64(0)
+	for _, item := range []db.PaySummary{gift, sc, guard} {
+		if item.Count == 0 {
+			continue
+		}
+		if firstTs == 0 || item.FirstTs < firstTs {
+			firstTs = item.FirstTs
+		}
+		if item.LastTs > lastTs {
+			lastTs = item.LastTs
+		}
+	}
+
+	return ret, firstTs, lastTs, nil
+}
+
+// loadNameHistory returns names used by uids, every uid in uids has a non-nil list
+func loadNameHistory(ctx *swe.Context, uids []int64) (map[int64][]bs.NameHistoryItem, error) {
+	history, err := db.GetUserNameDAL().BatchHistory(ctx, uids)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[int64][]bs.NameHistoryItem, len(uids))
+	for _, uid := range uids {
+		list := []bs.NameHistoryItem{}
+		for _, item := range history[uid] {
+			list = append(list, bs.NameHistoryItem{
+				Name:      item.Name,
+				FirstSeen: utils.TimeToLocalString(item.FirstTs),
+				LastSeen:  utils.TimeToLocalString(item.LastTs),
+			})
+		}
+		ret[uid] = list
+	}
+	return ret, nil
+}
@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zerozwt/octant/server/bs"
+	"github.com/zerozwt/octant/server/db"
+	"github.com/zerozwt/octant/server/handler/batch_dm"
+	"github.com/zerozwt/octant/server/handler/guard_roster"
+	"github.com/zerozwt/octant/server/session"
+	"github.com/zerozwt/octant/server/utils"
+	"github.com/zerozwt/swe"
+)
+
+func init() {
+	registerHandler(POST, "/guard/roster", guard.roster, session.CheckStreamer)
+	registerHandler(POST, "/guard/remind", guard.remind, session.CheckStreamer)
+}
+
+type guardHandler struct{}
+
+var guard guardHandler
+
+func (ins guardHandler) roster(ctx *swe.Context, req *bs.GuardRosterReq) (*bs.PageRsp, swe.SweError) {
+	st, _ := session.GetStreamerSession(ctx)
+	now := time.Now().Unix()
+
+	guards, err := ins.load(ctx, st.RoomID, now)
+	if err != nil {
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	guards = ins.filter(guards, now, req.UID, req.Name, req.GuardLevel, req.ExpireDays)
+
+	ret := &bs.PageRsp{Count: len(guards), List: []any{}}
+	for idx := (req.Page - 1) * req.Size; idx < len(guards) && idx < req.Page*req.Size; idx++ {
+		item := guards[idx]
+		ret.List = append(ret.List, bs.GuardRosterItem{
+			UID:         item.UID,
+			Name:        item.Name,
+			Level:       item.Level,
+			ExpireTime:  utils.TimeToLocalString(item.ExpireTs),
+			FinalTime:   utils.TimeToLocalString(item.FinalTs),
+			RemainDays:  int((item.ExpireTs - now) / 86400),
+			FirstTime:   utils.TimeToLocalString(item.FirstTs),
+			TotalMonths: item.TotalMonths,
+		})
+	}
+
+	return ret, nil
+}
+
+func (ins guardHandler) remind(ctx *swe.Context, req *bs.GuardRemindReq) (*bs.Nothing, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+	now := time.Now().Unix()
+
+	builder, err := batch_dm.MakeBuilder(req.Content)
+	if err != nil {
+		logger.Error("parse dm template failed: %v", err)
+		return nil, swe.Error(EC_DM_TEMPLATE_INVALID, err)
+	}
+	if !batch_dm.HasTransport(req.Transport) {
+		logger.Error("dm transport %s not found", req.Transport)
+		return nil, swe.Error(EC_DM_TRANSPORT_NOT_FOUND, batch_dm.ErrTransportNotFound)
+	}
+
+	guards, err := ins.load(ctx, st.RoomID, now)
+	if err != nil {
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	guards = ins.filter(guards, now, 0, "", req.GuardLevel, req.ExpireDays)
+
+	// guards are not bound to any event, build a context from roster instead
+	buildCtx := batch_dm.BuildCtx{
+		StreamerName: st.StreamerName,
+		Event:        &db.RewardEvent{RoomID: st.RoomID, EventName: req.Name},
+		InfoMap:      map[int64]*db.DDInfo{},
+		ExpireMap:    map[int64]int64{},
+		LevelMap:     map[int64]int{},
+	}
+	for _, item := range guards {
+		buildCtx.InfoMap[item.UID] = &db.DDInfo{UID: item.UID, UserName: item.Name}
+		buildCtx.ExpireMap[item.UID] = item.ExpireTs
+		buildCtx.LevelMap[item.UID] = item.Level
+	}
+
+	task := db.DMTask{
+		ID:           utils.GenerateID(),
+		RoomID:       st.RoomID,
+		TaskName:     req.Name,
+		MsgType:      1,
+		Content:      req.Content,
+		BatchMax:     req.BatchMax,
+		Status:       db.DM_TASK_STATUS_PAUSED,
+		IntervalMin:  req.IntervalMin,
+		IntervalMax:  req.IntervalMax,
+		SenderPolicy: req.Policy,
+		Transport:    req.Transport,
+		StartTime:    req.Schedule.StartTs(),
+		WindowStart:  req.Schedule.Window().Start,
+		WindowEnd:    req.Schedule.Window().End,
+		CreateTime:   now,
+	}
+
+	details := make([]db.DMDetail, 0, len(guards))
+	for _, item := range guards {
+		record := db.RewardUser{UID: item.UID, UserName: item.Name}
+		content, err := builder.BuildContent(ctx, &record, &buildCtx)
+		if err != nil {
+			logger.Error("generate msg content for uid %d failed: %v", item.UID, err)
+			continue
+		}
+		details = append(details, db.DMDetail{
+			TaskID:      task.ID,
+			RecieverUID: item.UID,
+			Content:     content,
+			Status:      db.DM_DETAIL_STATUS_NOT_SEND,
+		})
+	}
+
+	if len(details) == 0 {
+		logger.Error("no guard of room %d to remind", st.RoomID)
+		return nil, swe.Error(EC_DM_NO_RECIPIENT, fmt.Errorf("no guard to remind"))
+	}
+
+	logger.Info("create guard remind task %d for %d guards", task.ID, len(details))
+	if err := dmsg.saveTask(ctx, &task, details, req.RunTask, &req.Sender); err != nil {
+		return nil, err
+	}
+
+	return &bs.Nothing{}, nil
+}
+
+func (ins guardHandler) load(ctx *swe.Context, roomID, now int64) ([]*guard_roster.Guard, error) {
+	uids, err := db.GetMemberDal().PossibleGuards(ctx, roomID, now, guard_roster.MONTH_SECONDS)
+	if err != nil {
+		swe.CtxLogger(ctx).Error("query possible guards for room %d error %v", roomID, err)
+		return nil, err
+	}
+	records, err := db.GetMemberDal().UsersRecords(ctx, roomID, uids, now)
+	if err != nil {
+		swe.CtxLogger(ctx).Error("load guard records for room %d error %v", roomID, err)
+		return nil, err
+	}
+	return guard_roster.Build(records, now), nil
+}
+
+func (ins guardHandler) filter(guards []*guard_roster.Guard, now, uid int64, name string, levels []int, expireDays int) []*guard_roster.Guard {
+	levelMask := 0
+	for _, level := range levels {
+		levelMask |= 1 << level
+	}
+
+	ret := make([]*guard_roster.Guard, 0, len(guards))
+	for _, item := range guards {
+		if uid > 0 && item.UID != uid {
+			continue
+		}
+		if len(name) > 0 && !strings.Contains(item.Name, name) {
+			continue
+		}
+		if levelMask != 0 && (1<<item.Level)&levelMask == 0 {
+			continue
+		}
+		if expireDays > 0 && item.ExpireTs > now+int64(expireDays)*86400 {
+			continue
+		}
+		ret = append(ret, item)
+	}
+	return ret
+}
@@ -0,0 +1,113 @@
+package guard_roster
+
+import (
+	"sort"
+
+	"github.com/zerozwt/octant/server/db"
+)
+
+const (
+	GUARD_LEVEL_ZONGDU    = 1
+	GUARD_LEVEL_TIDU      = 2
+	GUARD_LEVEL_JIANZHANG = 3
+
+	MONTH_SECONDS = 30 * 24 * 3600
+)
+
+type Guard struct {
+	UID         int64
+	Name        string
+	Level       int
+	ExpireTs    int64 // when current level ends
+	FinalTs     int64 // when all stacked guard time ends
+	FirstTs     int64
+	TotalMonths int
+
+	// remaining seconds of each level at time last
+	remain [GUARD_LEVEL_JIANZHANG + 1]int64
+	last   int64
+}
+
+// advance consumes guard time till ts, only the highest level in effect is consumed,
+// lower levels are paused until higher levels run out
+func (g *Guard) advance(ts int64) {
+	elapsed := ts - g.last
+	if elapsed <= 0 {
+		return
+	}
+	g.last = ts
+
+	for level := GUARD_LEVEL_ZONGDU; level <= GUARD_LEVEL_JIANZHANG && elapsed > 0; level++ {
+		if g.remain[level] <= 0 {
+			continue
+		}
+		if g.remain[level] >= elapsed {
+			g.remain[level] -= elapsed
+			return
+		}
+		elapsed -= g.remain[level]
+		g.remain[level] = 0
+	}
+}
+
+func (g *Guard) buy(rec *db.MembershipRecord) {
+	if rec.GuardLevel < GUARD_LEVEL_ZONGDU || rec.GuardLevel > GUARD_LEVEL_JIANZHANG || rec.Count <= 0 {
+		return
+	}
+	if g.FirstTs == 0 {
+		g.FirstTs = rec.SendTime
+	}
+	g.advance(rec.SendTime)
+	g.remain[rec.GuardLevel] += int64(rec.Count) * MONTH_SECONDS
+	g.TotalMonths += rec.Count
+	g.Name = rec.SenderName
+}
+
+func (g *Guard) settle(now int64) bool {
+	g.advance(now)
+	g.Level = 0
+	g.FinalTs = now
+	for level := GUARD_LEVEL_ZONGDU; level <= GUARD_LEVEL_JIANZHANG; level++ {
+		if g.remain[level] <= 0 {
+			continue
+		}
+		if g.Level == 0 {
+			g.Level = level
+			g.ExpireTs = now + g.remain[level]
+		}
+		g.FinalTs += g.remain[level]
+	}
+	return g.Level != 0
+}
+
+// Build calculates current guards at now from purchase records ordered by send time,
+// result is ordered by expire time of current level
+func Build(records []*db.MembershipRecord, now int64) []*Guard {
+	guards := map[int64]*Guard{}
+	for _, rec := range records {
+		if rec.SendTime > now {
+			continue
+		}
+		g, ok := guards[rec.SenderUID]
+		if !ok {
+			g = &Guard{UID: rec.SenderUID}
+			guards[rec.SenderUID] = g
+		}
+		g.buy(rec)
+	}
+
+	ret := make([]*Guard, 0, len(guards))
+	for _, g := range guards {
+		if g.settle(now) {
+			ret = append(ret, g)
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].ExpireTs != ret[j].ExpireTs {
+			return ret[i].ExpireTs < ret[j].ExpireTs
+		}
+		return ret[i].UID < ret[j].UID
+	})
+	return ret
+}
@@ -0,0 +1,38 @@
+package guard_roster
+
+import (
+	"testing"
+
+	"github.com/zerozwt/octant/server/db"
+)
+
+func TestBuild(t *testing.T) {
+	day := int64(86400)
+	records := []*db.MembershipRecord{
+		// uid 1: 1 month 舰长, renewed with 2 more months before expire
+		{SenderUID: 1, SenderName: "a", SendTime: 0, GuardLevel: 3, Count: 1},
+		{SenderUID: 1, SenderName: "a2", SendTime: 10 * day, GuardLevel: 3, Count: 2},
+		// uid 2: 舰长 upgraded to 提督 after 10 days, 舰长 is paused while 提督 in effect
+		{SenderUID: 2, SenderName: "b", SendTime: 0, GuardLevel: 3, Count: 1},
+		{SenderUID: 2, SenderName: "b", SendTime: 10 * day, GuardLevel: 2, Count: 1},
+		// uid 3: expired
+		{SenderUID: 3, SenderName: "c", SendTime: 0, GuardLevel: 3, Count: 1},
+	}
+
+	now := 35 * day
+	guards := Build(records, now)
+	if len(guards) != 2 {
+		t.Fatalf("guard count %d ans 2", len(guards))
+	}
+
+	ans := map[int64]Guard{
+		1: {Name: "a2", Level: 3, ExpireTs: 90 * day, FinalTs: 90 * day, TotalMonths: 3},
+		2: {Name: "b", Level: 2, ExpireTs: 40 * day, FinalTs: 60 * day, TotalMonths: 2},
+	}
+	for _, g := range guards {
+		a := ans[g.UID]
+		if g.Name != a.Name || g.Level != a.Level || g.ExpireTs != a.ExpireTs || g.FinalTs != a.FinalTs || g.TotalMonths != a.TotalMonths {
+			t.Errorf("uid %d got %+v ans %+v", g.UID, *g, a)
+		}
+	}
+}
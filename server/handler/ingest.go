@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zerozwt/octant/server/bs"
+	"github.com/zerozwt/octant/server/ingest"
+	"github.com/zerozwt/swe"
+)
+
+func init() {
+	// called by remote collectors, see ingest.Shipper
+	registerHandler(POST, "/ingest/records", ingestAPI.records, ingestAPI.checkSignature)
+}
+
+type ingestHandler struct{}
+
+var ingestAPI ingestHandler
+
+// batches larger than this are refused before the signature is checked
+const INGEST_MAX_BODY = 8 << 20
+
+// checkSignature reads the body of a batch to check its signature, and puts it back for the handler
+func (ins ingestHandler) checkSignature(ctx *swe.Context) {
+	logger := swe.CtxLogger(ctx)
+
+	body, err := io.ReadAll(http.MaxBytesReader(ctx.Response, ctx.Request.Body, INGEST_MAX_BODY))
+	if err != nil {
+		logger.Error("read ingest batch failed: %v", err)
+		ctx.Response.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	ts, _ := strconv.ParseInt(ctx.Request.Header.Get("X-Octant-Timestamp"), 10, 64)
+	if err = ingest.Verify(ts, ctx.Request.Header.Get("X-Octant-Signature"), body, time.Now().Unix()); err != nil {
+		logger.Error("refuse ingest batch from %s: %v", ctx.Request.RemoteAddr, err)
+		ctx.Response.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+	ctx.Next()
+}
+
+// records saves a batch of records, records saved before are skipped. A batch failed in the middle is
+// shipped again by its collector, the records saved then are skipped.
+func (ins ingestHandler) records(ctx *swe.Context, req *bs.IngestReq) (*bs.IngestRsp, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+
+	rsp := &bs.IngestRsp{}
+	for idx := range req.Records {
+		rec := &req.Records[idx]
+		saved, err := ingest.SaveShipped(ctx, rec)
+		if err != nil {
+			logger.Error("save shipped %s record %d of room %d failed: %v", rec.Kind, rec.ID, rec.RoomID, err)
+			return nil, swe.Error(EC_INGEST_SAVE_FAIL, err)
+		}
+		if saved {
+			rsp.Saved++
+		} else {
+			rsp.Skipped++
+		}
+	}
+
+	logger.Info("ingest batch saved %d records, skipped %d", rsp.Saved, rsp.Skipped)
+	return rsp, nil
+}
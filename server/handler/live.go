@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/zerozwt/octant/server/bs"
+	"github.com/zerozwt/octant/server/live"
+	"github.com/zerozwt/octant/server/session"
+	"github.com/zerozwt/swe"
+)
+
+func init() {
+	registerRawHandler(GET, "/live/stream", liveStream.stream, session.CheckStreamer)
+}
+
+type liveHandler struct{}
+
+var liveStream liveHandler
+
+// comments are sent to idle streams this often, so that proxies do not close them
+const LIVE_HEARTBEAT = 15 * time.Second
+
+// stream pushes records collected from the streamer's room as server-sent events
+func (ins liveHandler) stream(ctx *swe.Context) {
+	req := bs.LiveStreamReq{}
+	if err := swe.DecodeForm(ctx.Request, &req); err != nil {
+		ctx.Response.WriteHeader(http.StatusBadRequest)
+		ctx.Response.Write([]byte(err.Error()))
+		return
+	}
+	if err := req.Validate(ctx); err != nil {
+		ctx.Response.WriteHeader(http.StatusBadRequest)
+		ctx.Response.Write([]byte(err.Error()))
+		return
+	}
+	st, _ := session.GetStreamerSession(ctx)
+
+	ins.serve(ctx, st.RoomID, req.TypeList(), req.LastID)
+}
+
+// serve streams events of room in types, all types if empty, until the client leaves. Events after
+// Last-Event-ID or lastID are sent first if they are still kept by hub.
+func (ins liveHandler) serve(ctx *swe.Context, roomID int64, types []string, lastID int64) {
+	logger := swe.CtxLogger(ctx)
+
+	flusher, ok := ctx.Response.(http.Flusher)
+	if !ok {
+		logger.Error("response writer can not flush")
+		ctx.Response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if value := ctx.Request.Header.Get("Last-Event-ID"); len(value) > 0 {
+		lastID, _ = strconv.ParseInt(value, 10, 64)
+	}
+	wanted := map[string]bool{}
+	for _, item := range types {
+		wanted[item] = true
+	}
+
+	header := ctx.Response.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	ctx.Response.WriteHeader(http.StatusOK)
+	fmt.Fprint(ctx.Response, "retry: 3000\n\n")
+	flusher.Flush()
+
+	stream := live.GetHub().Subscribe(roomID, lastID)
+	defer live.GetHub().Close(stream)
+	logger.Info("live stream of room %d opened", roomID)
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	ticker := time.NewTicker(LIVE_HEARTBEAT)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Request.Context().Done():
+			logger.Info("live stream of room %d closed by client", roomID)
+			return
+		case ev, ok := <-stream.Events():
+			if !ok {
+				// too slow to read, the client catches up after reconnecting
+				logger.Warn("live stream of room %d closed by hub", roomID)
+				return
+			}
+			if len(wanted) > 0 && !wanted[ev.Type] {
+				continue
+			}
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(ctx.Response, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+		case <-ticker.C:
+			fmt.Fprint(ctx.Response, ": ping\n\n")
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,323 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/zerozwt/octant/server/bs"
+	"github.com/zerozwt/octant/server/db"
+	"github.com/zerozwt/octant/server/handler/overlay_page"
+	"github.com/zerozwt/octant/server/live"
+	"github.com/zerozwt/octant/server/session"
+	"github.com/zerozwt/octant/server/utils"
+	"github.com/zerozwt/swe"
+)
+
+func init() {
+	registerHandler(GET, "/overlay/list", overlay.list, session.CheckStreamer)
+	registerHandler(POST, "/overlay/add", overlay.add, session.CheckStreamer)
+	registerHandler(POST, "/overlay/modify", overlay.modify, session.CheckStreamer)
+	registerHandler(POST, "/overlay/delete", overlay.delete, session.CheckStreamer)
+	registerHandler(POST, "/overlay/token/reset", overlay.resetToken, session.CheckStreamer)
+	registerHandler(POST, "/overlay/goal/reset", overlay.resetGoal, session.CheckStreamer)
+
+	// opened by OBS with the token of an overlay instead of a streamer login
+	registerRawHandler(GET, "/overlay/view", overlay.view, overlay.checkToken)
+	registerRawHandler(GET, "/overlay/stream", overlay.stream, overlay.checkToken)
+	registerHandler(GET, "/overlay/data", overlay.data, overlay.checkToken)
+}
+
+type overlayHandler struct{}
+
+var overlay overlayHandler
+
+const (
+	ctxOverlayKey = "ctx_o_overlay"
+	// sc sent this long before a wall is opened are shown on it
+	OVERLAY_SC_WALL_SECONDS = 86400
+)
+
+func (ins overlayHandler) toItem(item *db.Overlay) bs.OverlayItem {
+	return bs.OverlayItem{
+		ID:         item.ID,
+		Kind:       item.Kind,
+		Name:       item.Name,
+		Title:      item.Title,
+		MinSC:      item.MinSC,
+		MaxItems:   item.MaxItems,
+		GoalUnit:   item.GoalUnit,
+		GoalTarget: item.GoalTarget,
+		GiftIDs:    overlay_page.ParseGiftIDs(item.GiftIDs),
+		Path:       API_PREFIX + "/overlay/view?token=" + item.Token,
+		StartTime:  utils.TimeToLocalString(item.StartTime),
+		CreateTime: utils.TimeToLocalString(item.CreateTime),
+		UpdateTime: utils.TimeToLocalString(item.UpdateTime),
+	}
+}
+
+func (ins overlayHandler) fromReq(roomID int64, req *bs.OverlayReq) *db.Overlay {
+	ret := &db.Overlay{
+		ID:         req.ID,
+		RoomID:     roomID,
+		Kind:       req.Kind,
+		Name:       req.Name,
+		Title:      req.Title,
+		UpdateTime: time.Now().Unix(),
+	}
+	if req.Kind == db.OVERLAY_KIND_SC_WALL {
+		ret.MinSC = req.MinSC
+		ret.MaxItems = req.MaxItems
+		if ret.MaxItems == 0 {
+			ret.MaxItems = bs.OVERLAY_MAX_ITEMS_DEFAULT
+		}
+	} else {
+		ret.GoalUnit = req.GoalUnit
+		ret.GoalTarget = req.GoalTarget
+		ret.GiftIDs = overlay_page.JoinGiftIDs(req.GiftIDs)
+	}
+	return ret
+}
+
+func (ins overlayHandler) list(ctx *swe.Context, req *bs.Nothing) (*bs.PageRsp, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	items, err := db.GetOverlayDAL().List(ctx, st.RoomID)
+	if err != nil {
+		logger.Error("query overlays for room %d error %v", st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+
+	rsp := bs.PageRsp{Count: len(items), List: []any{}}
+	for idx := range items {
+		rsp.List = append(rsp.List, ins.toItem(&items[idx]))
+	}
+
+	return &rsp, nil
+}
+
+func (ins overlayHandler) add(ctx *swe.Context, req *bs.OverlayReq) (*bs.Nothing, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	item := ins.fromReq(st.RoomID, req)
+	item.ID = utils.GenerateID()
+	item.Token = utils.RandomToken()
+	item.StartTime = item.UpdateTime
+	item.CreateTime = item.UpdateTime
+
+	if err := db.GetOverlayDAL().Put(ctx, item); err != nil {
+		logger.Error("save overlay for room %d error %v", st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+
+	return &bs.Nothing{}, nil
+}
+
+func (ins overlayHandler) modify(ctx *swe.Context, req *bs.OverlayReq) (*bs.Nothing, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	old, err := db.GetOverlayDAL().GetByRoomID(ctx, req.ID, st.RoomID)
+	if err != nil {
+		logger.Error("query overlay %d for room %d error %v", req.ID, st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	if old == nil || old.Kind != req.Kind {
+		logger.Error("%s overlay %d for room %d not found", req.Kind, req.ID, st.RoomID)
+		return nil, swe.Error(EC_OVERLAY_NOT_FOUND, fmt.Errorf("overlay not found"))
+	}
+
+	if _, err = db.GetOverlayDAL().Update(ctx, ins.fromReq(st.RoomID, req)); err != nil {
+		logger.Error("update overlay %d for room %d error %v", req.ID, st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+
+	return &bs.Nothing{}, nil
+}
+
+func (ins overlayHandler) delete(ctx *swe.Context, req *bs.IDReq) (*bs.Nothing, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	ok, err := db.GetOverlayDAL().Delete(ctx, st.RoomID, req.ID)
+	if err != nil {
+		logger.Error("delete overlay %d for room %d error %v", req.ID, st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	if !ok {
+		logger.Error("overlay %d for room %d not found", req.ID, st.RoomID)
+		return nil, swe.Error(EC_OVERLAY_NOT_FOUND, fmt.Errorf("overlay not found"))
+	}
+
+	return &bs.Nothing{}, nil
+}
+
+// resetToken gives an overlay a new url, pages opened with the old one stop updating once reloaded
+func (ins overlayHandler) resetToken(ctx *swe.Context, req *bs.IDReq) (*bs.Nothing, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	ok, err := db.GetOverlayDAL().SetToken(ctx, st.RoomID, req.ID, utils.RandomToken(), time.Now().Unix())
+	if err != nil {
+		logger.Error("reset token of overlay %d for room %d error %v", req.ID, st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	if !ok {
+		logger.Error("overlay %d for room %d not found", req.ID, st.RoomID)
+		return nil, swe.Error(EC_OVERLAY_NOT_FOUND, fmt.Errorf("overlay not found"))
+	}
+
+	return &bs.Nothing{}, nil
+}
+
+// resetGoal counts gifts of a goal overlay from now on
+func (ins overlayHandler) resetGoal(ctx *swe.Context, req *bs.IDReq) (*bs.Nothing, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	item, err := db.GetOverlayDAL().GetByRoomID(ctx, req.ID, st.RoomID)
+	if err != nil {
+		logger.Error("query overlay %d for room %d error %v", req.ID, st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	if item == nil || item.Kind != db.OVERLAY_KIND_GOAL {
+		logger.Error("goal overlay %d for room %d not found", req.ID, st.RoomID)
+		return nil, swe.Error(EC_OVERLAY_NOT_FOUND, fmt.Errorf("overlay not found"))
+	}
+
+	if _, err = db.GetOverlayDAL().SetStartTime(ctx, st.RoomID, req.ID, time.Now().Unix()); err != nil {
+		logger.Error("reset goal overlay %d for room %d error %v", req.ID, st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+
+	return &bs.Nothing{}, nil
+}
+
+// checkToken loads the overlay of token in query, the token is all it takes to read the overlay
+func (ins overlayHandler) checkToken(ctx *swe.Context) {
+	logger := swe.CtxLogger(ctx)
+
+	token := ctx.Request.URL.Query().Get("token")
+	if len(token) == 0 {
+		ctx.Response.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	item, err := db.GetOverlayDAL().GetByToken(ctx, token)
+	if err != nil {
+		logger.Error("query overlay by token error %v", err)
+		ctx.Response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if item == nil {
+		logger.Warn("overlay of token not found")
+		ctx.Response.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	ctx.Put(ctxOverlayKey, item)
+	ctx.Next()
+}
+
+func (ins overlayHandler) current(ctx *swe.Context) *db.Overlay {
+	ret, _ := swe.CtxValue[*db.Overlay](ctx, ctxOverlayKey)
+	return ret
+}
+
+// goal sums gifts since the overlay starts, complete hours are read from rollups and only the partial
+// hours at both ends from raw gift records
+func (ins overlayHandler) goal(ctx *swe.Context, item *db.Overlay) (*bs.OverlayGoalData, error) {
+	giftIDs := overlay_page.ParseGiftIDs(item.GiftIDs)
+	first := utils.HourStart(item.StartTime)
+	if first < item.StartTime {
+		first += 3600
+	}
+	current := utils.HourStart(time.Now().Unix())
+	if first >= current {
+		count, value, err := db.GetGiftDAL().Sum(ctx, item.RoomID, item.StartTime, db.ROLLUP_TS_MAX, giftIDs)
+		if err != nil {
+			return nil, err
+		}
+		return overlay_page.Goal(item, count, value), nil
+	}
+
+	count, value, err := db.GetRollupDAL().GiftSum(ctx, item.RoomID, first, current, giftIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range [][2]int64{{item.StartTime, first}, {current, db.ROLLUP_TS_MAX}} {
+		partCount, partValue, err := db.GetGiftDAL().Sum(ctx, item.RoomID, part[0], part[1], giftIDs)
+		if err != nil {
+			return nil, err
+		}
+		count, value = count+partCount, value+partValue
+	}
+	return overlay_page.Goal(item, count, value), nil
+}
+
+// view renders the page of an overlay with what it shows at the moment, it keeps updated by stream
+func (ins overlayHandler) view(ctx *swe.Context) {
+	logger := swe.CtxLogger(ctx)
+	item := ins.current(ctx)
+
+	page := &overlay_page.Page{
+		Kind:     item.Kind,
+		Title:    item.Title,
+		MinSC:    item.MinSC,
+		MaxItems: item.MaxItems,
+	}
+
+	if item.Kind == db.OVERLAY_KIND_SC_WALL {
+		list, err := db.GetSCDal().Latest(ctx, item.RoomID, time.Now().Unix()-OVERLAY_SC_WALL_SECONDS,
+			item.MinSC, item.MaxItems)
+		if err != nil {
+			logger.Error("query sc of room %d for overlay %d error %v", item.RoomID, item.ID, err)
+			ctx.Response.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		for _, sc := range list {
+			page.SCs = append(page.SCs, overlay_page.SC{Name: sc.SenderName, Price: sc.Price, Content: sc.Content})
+		}
+	} else {
+		data, err := ins.goal(ctx, item)
+		if err != nil {
+			logger.Error("query goal of overlay %d error %v", item.ID, err)
+			ctx.Response.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		page.Goal = data
+	}
+
+	ctx.Response.Header().Set("Content-Type", "text/html; charset=utf-8")
+	ctx.Response.Header().Set("Cache-Control", "no-store")
+	if err := overlay_page.Render(ctx.Response, page); err != nil {
+		logger.Error("render overlay %d failed: %v", item.ID, err)
+	}
+}
+
+// stream pushes events the page of an overlay updates itself with
+func (ins overlayHandler) stream(ctx *swe.Context) {
+	item := ins.current(ctx)
+	types := []string{live.EVENT_GIFT}
+	if item.Kind == db.OVERLAY_KIND_SC_WALL {
+		types = []string{live.EVENT_SC}
+	}
+	liveStream.serve(ctx, item.RoomID, types, 0)
+}
+
+// data is the progress of a goal overlay
+func (ins overlayHandler) data(ctx *swe.Context, req *bs.Nothing) (*bs.OverlayGoalData, swe.SweError) {
+	item := ins.current(ctx)
+	if item.Kind != db.OVERLAY_KIND_GOAL {
+		return nil, swe.Error(EC_OVERLAY_NOT_FOUND, fmt.Errorf("overlay %d is not a goal", item.ID))
+	}
+
+	ret, err := ins.goal(ctx, item)
+	if err != nil {
+		swe.CtxLogger(ctx).Error("query goal of overlay %d error %v", item.ID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	return ret, nil
+}
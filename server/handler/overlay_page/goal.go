@@ -0,0 +1,54 @@
+package overlay_page
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/zerozwt/octant/server/bs"
+	"github.com/zerozwt/octant/server/db"
+)
+
+// Goal is the progress of a goal overlay, with count gifts worth value gold coins sent since it started
+func Goal(item *db.Overlay, count, value int64) *bs.OverlayGoalData {
+	ret := &bs.OverlayGoalData{
+		Title:  item.Title,
+		Unit:   item.GoalUnit,
+		Target: item.GoalTarget,
+	}
+
+	if item.GoalUnit == db.OVERLAY_GOAL_VALUE {
+		ret.Current = value
+		ret.Text = "¥" + yuan(value) + " / ¥" + yuan(item.GoalTarget)
+	} else {
+		ret.Current = count
+		ret.Text = strconv.FormatInt(count, 10) + " / " + strconv.FormatInt(item.GoalTarget, 10)
+	}
+	if ret.Target > 0 {
+		ret.Percent = math.Floor(float64(ret.Current)*1000/float64(ret.Target)) / 10
+	}
+	return ret
+}
+
+func yuan(value int64) string {
+	return strconv.FormatFloat(float64(value)/1000, 'f', -1, 64)
+}
+
+// ParseGiftIDs reads gift ids of a goal overlay, ids not valid are skipped
+func ParseGiftIDs(value string) []int64 {
+	ret := []int64{}
+	for _, item := range strings.Split(value, ",") {
+		if id, err := strconv.ParseInt(item, 10, 64); err == nil && id > 0 {
+			ret = append(ret, id)
+		}
+	}
+	return ret
+}
+
+func JoinGiftIDs(ids []int64) string {
+	tmp := make([]string, 0, len(ids))
+	for _, id := range ids {
+		tmp = append(tmp, strconv.FormatInt(id, 10))
+	}
+	return strings.Join(tmp, ",")
+}
@@ -0,0 +1,55 @@
+package overlay_page
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zerozwt/octant/server/db"
+)
+
+func TestGoal(t *testing.T) {
+	item := &db.Overlay{GoalUnit: db.OVERLAY_GOAL_VALUE, GoalTarget: 100000}
+	ret := Goal(item, 3, 12500)
+	if ret.Current != 12500 || ret.Percent != 12.5 || ret.Text != "¥12.5 / ¥100" {
+		t.Errorf("value goal got %+v", ret)
+	}
+
+	item = &db.Overlay{GoalUnit: db.OVERLAY_GOAL_COUNT, GoalTarget: 3}
+	ret = Goal(item, 4, 12500)
+	if ret.Current != 4 || ret.Percent != 133.3 || ret.Text != "4 / 3" {
+		t.Errorf("count goal got %+v", ret)
+	}
+}
+
+func TestGiftIDs(t *testing.T) {
+	if ret := ParseGiftIDs(""); len(ret) != 0 {
+		t.Errorf("empty gift ids got %v", ret)
+	}
+	if ret := ParseGiftIDs(JoinGiftIDs([]int64{31036, 1})); !reflect.DeepEqual(ret, []int64{31036, 1}) {
+		t.Errorf("gift ids got %v", ret)
+	}
+	if ret := ParseGiftIDs("1,x,,-2,3"); !reflect.DeepEqual(ret, []int64{1, 3}) {
+		t.Errorf("gift ids with invalid items got %v", ret)
+	}
+}
+
+func TestRender(t *testing.T) {
+	out := strings.Builder{}
+	page := &Page{Kind: db.OVERLAY_KIND_SC_WALL, MaxItems: 10, SCs: []SC{{Name: "<b>x</b>", Price: 30, Content: "</script>"}}}
+	if err := Render(&out, page); err != nil {
+		t.Fatalf("render sc wall failed: %v", err)
+	}
+	if strings.Contains(out.String(), "<b>x</b>") || strings.Contains(out.String(), "</script>\n<script>") {
+		t.Errorf("sc wall not escaped: %s", out.String())
+	}
+
+	out.Reset()
+	page = &Page{Kind: db.OVERLAY_KIND_GOAL, Goal: Goal(&db.Overlay{GoalUnit: db.OVERLAY_GOAL_COUNT, GoalTarget: 10}, 5, 0)}
+	if err := Render(&out, page); err != nil {
+		t.Fatalf("render goal failed: %v", err)
+	}
+	if !strings.Contains(out.String(), `"percent":50`) {
+		t.Errorf("goal data not in page: %s", out.String())
+	}
+}
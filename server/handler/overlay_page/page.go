@@ -0,0 +1,122 @@
+package overlay_page
+
+import (
+	"html/template"
+	"io"
+
+	"github.com/zerozwt/octant/server/bs"
+)
+
+// SC is an sc shown on the wall when the page is opened
+type SC struct {
+	Name    string
+	Price   int64 // CNY
+	Content string
+}
+
+// Page is what an overlay shows when opened, the page keeps itself updated by the stream of its overlay
+type Page struct {
+	Kind     string // db.OVERLAY_KIND_*
+	Title    string
+	MinSC    int64
+	MaxItems int
+	SCs      []SC // newest first
+	Goal     *bs.OverlayGoalData
+}
+
+func Render(w io.Writer, page *Page) error {
+	return pageTemplate.Execute(w, page)
+}
+
+var pageTemplate *template.Template = template.Must(template.New("overlay").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+html, body { margin: 0; padding: 0; background: transparent; overflow: hidden; }
+body { font-family: "Microsoft YaHei", "PingFang SC", sans-serif; color: #fff; text-shadow: 0 1px 2px rgba(0, 0, 0, 0.6); }
+h1 { margin: 8px; font-size: 22px; }
+.sc { margin: 8px; border-radius: 6px; overflow: hidden; background: #2a60b2; }
+.sc .head { padding: 4px 10px; background: rgba(0, 0, 0, 0.2); font-size: 14px; }
+.sc .price { float: right; font-weight: bold; }
+.sc .content { padding: 6px 10px; font-size: 18px; word-break: break-all; }
+.goal { margin: 8px; }
+.goal .bar { height: 28px; border-radius: 14px; background: rgba(0, 0, 0, 0.4); overflow: hidden; }
+.goal .fill { height: 100%; width: 0; background: linear-gradient(90deg, #f7b733, #fc4a1a); transition: width 0.5s; }
+.goal .text { margin-top: 4px; font-size: 18px; text-align: right; }
+</style>
+</head>
+<body>
+{{if .Title}}<h1>{{.Title}}</h1>{{end}}
+{{if eq .Kind "sc_wall"}}
+<div id="wall">
+{{range .SCs}}<div class="sc"><div class="head">{{.Name}}<span class="price">¥{{.Price}}</span></div><div class="content">{{.Content}}</div></div>
+{{end}}
+</div>
+<script>
+const minSC = {{.MinSC}};
+const maxItems = {{.MaxItems}};
+const wall = document.getElementById("wall");
+
+function element(cls, text) {
+	const ret = document.createElement(cls === "price" ? "span" : "div");
+	ret.className = cls;
+	ret.textContent = text;
+	return ret;
+}
+
+const source = new EventSource("stream" + location.search);
+source.addEventListener("sc", (e) => {
+	const ev = JSON.parse(e.data);
+	if (ev.value < minSC) {
+		return;
+	}
+	const head = element("head", ev.name);
+	head.appendChild(element("price", "¥" + ev.value));
+	const item = element("sc", "");
+	item.appendChild(head);
+	item.appendChild(element("content", ev.message));
+	wall.prepend(item);
+	while (wall.children.length > maxItems) {
+		wall.lastElementChild.remove();
+	}
+});
+</script>
+{{else}}
+<div class="goal">
+<div class="bar"><div class="fill" id="fill"></div></div>
+<div class="text" id="text"></div>
+</div>
+<script>
+const fill = document.getElementById("fill");
+const text = document.getElementById("text");
+
+function show(data) {
+	fill.style.width = Math.min(data.percent, 100) + "%";
+	text.textContent = data.text;
+}
+show({{.Goal}});
+
+// progress is counted by the server, so that it is the same for every page and survives restarts
+function refresh() {
+	fetch("data" + location.search).then((rsp) => rsp.json()).then((rsp) => {
+		if (rsp.code === 0) {
+			show(rsp.data);
+		}
+	}).catch(() => {});
+}
+
+let timer = null;
+const source = new EventSource("stream" + location.search);
+source.addEventListener("gift", () => {
+	if (timer === null) {
+		timer = setTimeout(() => { timer = null; refresh(); }, 1000);
+	}
+});
+setInterval(refresh, 60000);
+</script>
+{{end}}
+</body>
+</html>
+`))
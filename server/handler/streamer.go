@@ -106,6 +106,7 @@ func (ins streamerHandler) simpleSearch(ctx *swe.Context, req *bs.SimpleSearchRe
 	}
 	ret := bs.PageRsp{List: []any{}}
 	st, _ := session.GetStreamerSession(ctx)
+	items := []bs.SimpleSearchItem{}
 
 	if req.IsGift() {
 		count, list, err := db.GetGiftDAL().Page(ctx, st.RoomID, req.StartTs(), req.EndTs(),
@@ -125,7 +126,7 @@ func (ins streamerHandler) simpleSearch(ctx *swe.Context, req *bs.SimpleSearchRe
 			item.Gift.Name = rec.GiftName
 			item.Gift.Price = rec.GiftPrice
 			item.Gift.Count = rec.GiftCount
-			ret.List = append(ret.List, item)
+			items = append(items, item)
 		}
 	} else if req.IsMember() {
 		count, list, err := db.GetMemberDal().Page(ctx, st.RoomID, req.StartTs(), req.EndTs(),
@@ -143,7 +144,7 @@ func (ins streamerHandler) simpleSearch(ctx *swe.Context, req *bs.SimpleSearchRe
 			}
 			item.Member.Level = rec.GuardLevel
 			item.Member.Count = rec.Count
-			ret.List = append(ret.List, item)
+			items = append(items, item)
 		}
 	} else if req.IsSuperChat() {
 		count, list, err := db.GetSCDal().Page(ctx, st.RoomID, req.StartTs(), req.EndTs(),
@@ -163,10 +164,24 @@ func (ins streamerHandler) simpleSearch(ctx *swe.Context, req *bs.SimpleSearchRe
 			item.SuperChat.Content = rec.Content
 			item.SuperChat.BgColor = rec.BgColor
 			item.SuperChat.FontColor = rec.FontColor
-			ret.List = append(ret.List, item)
+			items = append(items, item)
 		}
 	}
 
+	uids := make([]int64, 0, len(items))
+	for _, item := range items {
+		uids = append(uids, item.UID)
+	}
+	names, err := loadNameHistory(ctx, uids)
+	if err != nil {
+		swe.CtxLogger(ctx).Error("query name history error %v", err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	for _, item := range items {
+		item.Names = names[item.UID]
+		ret.List = append(ret.List, item)
+	}
+
 	return &ret, nil
 }
 
@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zerozwt/octant/server/bs"
+	"github.com/zerozwt/octant/server/db"
+	"github.com/zerozwt/octant/server/session"
+	"github.com/zerozwt/octant/server/utils"
+	"github.com/zerozwt/octant/server/webhook"
+	"github.com/zerozwt/swe"
+)
+
+func init() {
+	registerHandler(GET, "/webhook/list", hook.list, session.CheckStreamer)
+	registerHandler(POST, "/webhook/add", hook.add, session.CheckStreamer)
+	registerHandler(POST, "/webhook/modify", hook.modify, session.CheckStreamer)
+	registerHandler(POST, "/webhook/delete", hook.delete, session.CheckStreamer)
+	registerHandler(POST, "/webhook/test", hook.test, session.CheckStreamer)
+	registerHandler(GET, "/webhook/template/vars", hook.templateVars, session.CheckStreamer)
+
+	registerHandler(GET, "/webhook/deliveries", hook.deliveries, session.CheckStreamer)
+	registerHandler(POST, "/webhook/redeliver", hook.redeliver, session.CheckStreamer)
+}
+
+type webhookHandler struct{}
+
+var hook webhookHandler
+
+func (ins webhookHandler) list(ctx *swe.Context, req *bs.Nothing) (*bs.PageRsp, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	hooks, err := db.GetWebhookDAL().List(ctx, st.RoomID)
+	if err != nil {
+		logger.Error("query webhooks for room %d error %v", st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+
+	rsp := bs.PageRsp{Count: len(hooks), List: []any{}}
+	for _, item := range hooks {
+		rsp.List = append(rsp.List, bs.WebhookItem{
+			ID:         item.ID,
+			Name:       item.Name,
+			URL:        item.URL,
+			HasSecret:  len(item.Secret) > 0,
+			Events:     strings.Split(item.Events, ","),
+			MinSC:      item.MinSC,
+			MinGift:    item.MinGift,
+			GuardLevel: item.GuardLevel,
+			Template:   item.Template,
+			Enabled:    item.Enabled != 0,
+			CreateTime: utils.TimeToLocalString(item.CreateTime),
+			UpdateTime: utils.TimeToLocalString(item.UpdateTime),
+		})
+	}
+
+	return &rsp, nil
+}
+
+// fromReq converts req to a hook of room, and checks its template
+func (ins webhookHandler) fromReq(ctx *swe.Context, roomID int64, req *bs.WebhookReq) (*db.Webhook, swe.SweError) {
+	if err := webhook.CheckTemplate(req.Template); err != nil {
+		swe.CtxLogger(ctx).Error("check webhook template failed: %v", err)
+		return nil, swe.Error(EC_HOOK_TEMPLATE_INVALID, err)
+	}
+
+	ret := &db.Webhook{
+		ID:         req.ID,
+		RoomID:     roomID,
+		Name:       req.Name,
+		URL:        req.URL,
+		Secret:     req.Secret,
+		Events:     strings.Join(req.Events, ","),
+		MinSC:      req.MinSC,
+		MinGift:    req.MinGift,
+		GuardLevel: req.GuardLevel,
+		Template:   req.Template,
+		UpdateTime: time.Now().Unix(),
+	}
+	if req.Enabled {
+		ret.Enabled = 1
+	}
+	return ret, nil
+}
+
+func (ins webhookHandler) add(ctx *swe.Context, req *bs.WebhookReq) (*bs.Nothing, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	item, sweErr := ins.fromReq(ctx, st.RoomID, req)
+	if sweErr != nil {
+		return nil, sweErr
+	}
+	item.ID = utils.GenerateID()
+	item.CreateTime = item.UpdateTime
+
+	if err := db.GetWebhookDAL().Put(ctx, item); err != nil {
+		logger.Error("save webhook for room %d error %v", st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	webhook.Invalidate(st.RoomID)
+
+	return &bs.Nothing{}, nil
+}
+
+func (ins webhookHandler) modify(ctx *swe.Context, req *bs.WebhookReq) (*bs.Nothing, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	old, err := db.GetWebhookDAL().GetByRoomID(ctx, req.ID, st.RoomID)
+	if err != nil {
+		logger.Error("query webhook %d for room %d error %v", req.ID, st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	if old == nil {
+		logger.Error("webhook %d for room %d not found", req.ID, st.RoomID)
+		return nil, swe.Error(EC_HOOK_NOT_FOUND, fmt.Errorf("webhook not found"))
+	}
+
+	item, sweErr := ins.fromReq(ctx, st.RoomID, req)
+	if sweErr != nil {
+		return nil, sweErr
+	}
+	if len(item.Secret) == 0 {
+		item.Secret = old.Secret
+	}
+
+	if _, err = db.GetWebhookDAL().Update(ctx, item); err != nil {
+		logger.Error("update webhook %d for room %d error %v", req.ID, st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	webhook.Invalidate(st.RoomID)
+
+	return &bs.Nothing{}, nil
+}
+
+func (ins webhookHandler) delete(ctx *swe.Context, req *bs.IDReq) (*bs.Nothing, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	ok, err := db.GetWebhookDAL().Delete(ctx, st.RoomID, req.ID)
+	if err != nil {
+		logger.Error("delete webhook %d for room %d error %v", req.ID, st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	if !ok {
+		logger.Error("webhook %d for room %d not found", req.ID, st.RoomID)
+		return nil, swe.Error(EC_HOOK_NOT_FOUND, fmt.Errorf("webhook not found"))
+	}
+	webhook.Invalidate(st.RoomID)
+
+	return &bs.Nothing{}, nil
+}
+
+// test posts a sample event to a webhook at once, the result is returned but not logged
+func (ins webhookHandler) test(ctx *swe.Context, req *bs.IDReq) (*bs.WebhookTestRsp, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	item, err := db.GetWebhookDAL().GetByRoomID(ctx, req.ID, st.RoomID)
+	if err != nil {
+		logger.Error("query webhook %d for room %d error %v", req.ID, st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	if item == nil {
+		logger.Error("webhook %d for room %d not found", req.ID, st.RoomID)
+		return nil, swe.Error(EC_HOOK_NOT_FOUND, fmt.Errorf("webhook not found"))
+	}
+
+	payload, err := webhook.Render(item.Template, webhook.SampleEvent(st.RoomID, time.Now().Unix()))
+	if err != nil {
+		logger.Error("render test payload for webhook %d failed: %v", req.ID, err)
+		return nil, swe.Error(EC_HOOK_TEMPLATE_INVALID, err)
+	}
+
+	ret := &bs.WebhookTestRsp{Payload: string(payload)}
+	ret.StatusCode, err = webhook.Post(item, db.WEBHOOK_EVENT_TEST, 0, payload)
+	if err != nil {
+		logger.Warn("post test event to webhook %d failed: %v", req.ID, err)
+		ret.Error = err.Error()
+	}
+
+	return ret, nil
+}
+
+func (ins webhookHandler) templateVars(ctx *swe.Context, req *bs.Nothing) (*bs.WebhookTemplateVarsRsp, swe.SweError) {
+	return &bs.WebhookTemplateVarsRsp{Vars: webhook.TemplateVars()}, nil
+}
+
+func (ins webhookHandler) deliveries(ctx *swe.Context, req *bs.WebhookDeliveryListReq) (*bs.PageRsp, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	item, err := db.GetWebhookDAL().GetByRoomID(ctx, req.HookID, st.RoomID)
+	if err != nil {
+		logger.Error("query webhook %d for room %d error %v", req.HookID, st.RoomID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	if item == nil {
+		logger.Error("webhook %d for room %d not found", req.HookID, st.RoomID)
+		return nil, swe.Error(EC_HOOK_NOT_FOUND, fmt.Errorf("webhook not found"))
+	}
+
+	count, deliveries, err := db.GetWebhookDAL().PageDeliveries(ctx, req.HookID, req.Status,
+		(req.Page-1)*req.Size, req.Size)
+	if err != nil {
+		logger.Error("query deliveries of webhook %d error %v", req.HookID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+
+	rsp := bs.PageRsp{Count: count, List: []any{}}
+	for _, item := range deliveries {
+		rsp.List = append(rsp.List, bs.WebhookDeliveryItem{
+			ID:         item.ID,
+			Event:      item.Event,
+			Payload:    item.Payload,
+			Status:     item.Status,
+			Attempts:   item.Attempts,
+			StatusCode: item.StatusCode,
+			Error:      item.Error,
+			CreateTime: utils.TimeToLocalString(item.CreateTime),
+			UpdateTime: utils.TimeToLocalString(item.UpdateTime),
+		})
+	}
+
+	return &rsp, nil
+}
+
+// redeliver posts a delivered or failed payload again
+func (ins webhookHandler) redeliver(ctx *swe.Context, req *bs.IDReq) (*bs.Nothing, swe.SweError) {
+	logger := swe.CtxLogger(ctx)
+	st, _ := session.GetStreamerSession(ctx)
+
+	delivery, err := db.GetWebhookDAL().GetDelivery(ctx, req.ID)
+	if err != nil {
+		logger.Error("query webhook delivery %d error %v", req.ID, err)
+		return nil, swe.Error(EC_GENERIC_DB_FAIL, err)
+	}
+	if delivery == nil || delivery.RoomID != st.RoomID {
+		logger.Error("webhook delivery %d for room %d not found", req.ID, st.RoomID)
+		return nil, swe.Error(EC_HOOK_NOT_FOUND, fmt.Errorf("delivery not found"))
+	}
+	if delivery.Status == db.WEBHOOK_DELIVERY_PENDING {
+		logger.Error("webhook delivery %d is pending", req.ID)
+		return nil, swe.Error(EC_HOOK_DELIVERY_PENDING, fmt.Errorf("delivery is pending"))
+	}
+
+	if err = webhook.Redeliver(ctx, delivery); err != nil {
+		logger.Error("redeliver webhook delivery %d failed: %v", req.ID, err)
+		return nil, swe.Error(EC_HOOK_DELIVERY_PENDING, err)
+	}
+
+	return &bs.Nothing{}, nil
+}
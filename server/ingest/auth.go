@@ -0,0 +1,32 @@
+package ingest
+
+import (
+	"crypto/hmac"
+	"fmt"
+
+	"github.com/zerozwt/octant/server/utils"
+)
+
+// batches signed longer ago than this are refused, so that a captured request can not be replayed later
+const INGEST_MAX_SKEW = 300
+
+var coreSecret string
+
+// Accept lets core accept records shipped by remote collectors with secret, empty secret refuses all
+func Accept(secret string) {
+	coreSecret = secret
+}
+
+// Verify checks the signature of a batch, see utils.SignPayload
+func Verify(ts int64, signature string, body []byte, now int64) error {
+	if len(coreSecret) == 0 {
+		return fmt.Errorf("ingest not enabled")
+	}
+	if ts < now-INGEST_MAX_SKEW || ts > now+INGEST_MAX_SKEW {
+		return fmt.Errorf("timestamp %d out of range", ts)
+	}
+	if !hmac.Equal([]byte(signature), []byte(utils.SignPayload(coreSecret, ts, body))) {
+		return fmt.Errorf("bad signature")
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package ingest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zerozwt/octant/server/async_task"
+	"github.com/zerozwt/octant/server/bs"
+	"github.com/zerozwt/octant/server/db"
+	"github.com/zerozwt/octant/server/live"
+	"github.com/zerozwt/octant/server/webhook"
+	"github.com/zerozwt/swe"
+)
+
+const (
+	RECORD_SC    = "sc"
+	RECORD_GUARD = "guard"
+	RECORD_GIFT  = "gift"
+)
+
+// Sink takes records collected from live rooms
+type Sink interface {
+	Put(rec *bs.IngestRecord)
+}
+
+// DBSink saves records at once, for collectors with access to db
+type DBSink struct{}
+
+func (s DBSink) Put(rec *bs.IngestRecord) {
+	if err := Save(nil, rec); err != nil {
+		swe.CtxLogger(nil).Error("save %s record %d of room %d failed: %v, record: %+v", rec.Kind, rec.ID, rec.RoomID, err, *rec)
+	}
+}
+
+// Save writes a record to db with its rollup, then pushes it to live streams and webhooks of its room
+func Save(ctx *swe.Context, rec *bs.IngestRecord) error {
+	switch rec.Kind {
+	case RECORD_SC:
+		return saveSC(ctx, rec)
+	case RECORD_GUARD:
+		return saveGuard(ctx, rec)
+	case RECORD_GIFT:
+		return saveGift(ctx, rec)
+	}
+	return fmt.Errorf("unknown record kind %s", rec.Kind)
+}
+
+func publish(ctx *swe.Context, rec *bs.IngestRecord, ev *live.Event) {
+	ev.ID = rec.ID
+	ev.RoomID = rec.RoomID
+	live.Publish(ctx, ev)
+	webhook.Dispatch(ctx, ev)
+}
+
+func saveSC(ctx *swe.Context, rec *bs.IngestRecord) error {
+	defer names.seen(rec.UID, rec.Name, rec.Time)
+
+	err := db.GetSCDal().Insert(ctx, rec.RoomID, rec.Time, rec.UID, rec.Name, rec.Price, rec.Message,
+		rec.BgColor, rec.FontColor)
+	if err != nil {
+		return err
+	}
+	if err = db.GetRollupDAL().AddSC(ctx, rec.RoomID, rec.Time, rec.UID, rec.Price); err != nil {
+		swe.CtxLogger(ctx).Error("update sc rollup of room %d failed: %v", rec.RoomID, err)
+	}
+	publish(ctx, rec, &live.Event{
+		Type:    live.EVENT_SC,
+		UID:     rec.UID,
+		Name:    rec.Name,
+		Time:    rec.Time,
+		Message: rec.Message,
+		Count:   1,
+		Price:   rec.Price,
+		Value:   rec.Price,
+	})
+	return nil
+}
+
+func saveGuard(ctx *swe.Context, rec *bs.IngestRecord) error {
+	defer names.seen(rec.UID, rec.Name, rec.Time)
+
+	err := db.GetMemberDal().Insert(ctx, rec.RoomID, rec.Time, rec.UID, rec.Name, rec.Level, int(rec.Count))
+	if err != nil {
+		return err
+	}
+	if err = db.GetRollupDAL().AddGuard(ctx, rec.RoomID, rec.Time, rec.UID, rec.Level, int(rec.Count)); err != nil {
+		swe.CtxLogger(ctx).Error("update guard rollup of room %d failed: %v", rec.RoomID, err)
+	}
+	publish(ctx, rec, &live.Event{
+		Type:     live.EVENT_GUARD,
+		UID:      rec.UID,
+		Name:     rec.Name,
+		Time:     rec.Time,
+		Level:    rec.Level,
+		GiftName: rec.GiftName,
+		Count:    rec.Count,
+		Price:    db.GuardPrice(rec.Level),
+		Value:    db.GuardPrice(rec.Level) * rec.Count,
+	})
+	return nil
+}
+
+func saveGift(ctx *swe.Context, rec *bs.IngestRecord) error {
+	defer names.seen(rec.UID, rec.Name, rec.Time)
+	defer db.GetGiftDAL().UpdateGiftInfo(ctx, rec.GiftID, rec.GiftName, rec.Price)
+
+	gift := db.GiftRecord{
+		BatchID:    rec.BatchID,
+		RoomID:     rec.RoomID,
+		SendTime:   rec.Time,
+		SenderUID:  rec.UID,
+		SenderName: rec.Name,
+		GiftID:     rec.GiftID,
+		GiftName:   rec.GiftName,
+		GiftPrice:  rec.Price,
+		GiftCount:  rec.Count,
+	}
+	inserted, err := db.GetGiftDAL().Insert(ctx, &gift)
+	if err != nil {
+		return err
+	}
+	// a combo is counted once, in the hour its record is stored
+	if err = db.GetRollupDAL().AddGift(ctx, rec.RoomID, gift.SendTime, rec.UID, rec.GiftID,
+		rec.Count, rec.Price*rec.Count, inserted); err != nil {
+		swe.CtxLogger(ctx).Error("update gift rollup of room %d failed: %v", rec.RoomID, err)
+	}
+	// each message of a combo is an event on its own
+	publish(ctx, rec, &live.Event{
+		Type:     live.EVENT_GIFT,
+		UID:      rec.UID,
+		Name:     rec.Name,
+		Time:     rec.Time,
+		GiftName: rec.GiftName,
+		Count:    rec.Count,
+		Price:    rec.Price,
+		Value:    rec.Price * rec.Count,
+	})
+	return nil
+}
+
+const (
+	asyncTaskPruneClaims = "prune_ingest_claims"
+	// a collector retrying a batch longer than this may get its records saved twice
+	INGEST_CLAIM_KEEP_DAYS = 7
+)
+
+func init() {
+	async_task.RegisterHandler(asyncTaskPruneClaims, pruneTask)
+	async_task.RegisterCronJob(async_task.CronJob{
+		Name:    asyncTaskPruneClaims,
+		Spec:    "40 4 * * *",
+		Handler: asyncTaskPruneClaims,
+	})
+}
+
+// SaveShipped saves a record shipped by a remote collector unless it is saved already, returns false if so
+func SaveShipped(ctx *swe.Context, rec *bs.IngestRecord) (bool, error) {
+	ok, err := db.GetIngestDAL().Claim(ctx, rec.Key, time.Now().Unix())
+	if err != nil || !ok {
+		return false, err
+	}
+	if err = Save(ctx, rec); err != nil {
+		if dbErr := db.GetIngestDAL().Release(ctx, rec.Key); dbErr != nil {
+			swe.CtxLogger(ctx).Error("release claim %s of record %d failed: %v", rec.Key, rec.ID, dbErr)
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func pruneTask(ctx *swe.Context, taskCtx async_task.TaskContext) error {
+	count, err := db.GetIngestDAL().Prune(ctx, time.Now().Unix()-INGEST_CLAIM_KEEP_DAYS*86400)
+	if err != nil {
+		return err
+	}
+	swe.CtxLogger(ctx).Info("%d ingest claims pruned", count)
+	return nil
+}
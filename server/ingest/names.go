@@ -0,0 +1,67 @@
+package ingest
+
+import (
+	"sync"
+
+	"github.com/zerozwt/octant/server/db"
+	"github.com/zerozwt/swe"
+)
+
+// names within this interval are not written to db again unless changed
+const nameTouchInterval = 3600
+
+type nameTracker struct {
+	lock  sync.Mutex
+	last  map[int64]nameSeen
+	swept int64
+}
+
+type nameSeen struct {
+	name string
+	ts   int64
+}
+
+var names *nameTracker = &nameTracker{last: map[int64]nameSeen{}}
+
+// seen records the user name carried by a paid record into name history,
+// dd account name is refreshed once a different name shows up
+func (t *nameTracker) seen(uid int64, name string, ts int64) {
+	if uid <= 0 || len(name) == 0 {
+		return
+	}
+
+	t.lock.Lock()
+	prev, ok := t.last[uid]
+	if ok && prev.name == name && ts-prev.ts < nameTouchInterval {
+		t.lock.Unlock()
+		return
+	}
+	t.last[uid] = nameSeen{name: name, ts: ts}
+	t.sweep(ts)
+	t.lock.Unlock()
+
+	logger := swe.CtxLogger(nil)
+	if err := db.GetUserNameDAL().Touch(nil, uid, name, ts); err != nil {
+		logger.Error("update name history for uid %d failed: %v", uid, err)
+	}
+
+	if !ok || prev.name != name {
+		if err := db.GetDDInfoDAL().UpdateName(nil, uid, name); err != nil {
+			logger.Error("update dd name for uid %d failed: %v", uid, err)
+		}
+	}
+}
+
+// sweep drops users not seen within the interval once an interval, so that the map holds users seen lately
+// only. A user dropped is written to db again when seen next time.
+func (t *nameTracker) sweep(ts int64) {
+	if ts-t.swept < nameTouchInterval {
+		return
+	}
+	t.swept = ts
+	for uid, item := range t.last {
+		if ts-item.ts >= nameTouchInterval {
+			delete(t.last, uid)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package ingest
+
+import "testing"
+
+func TestNameTrackerSweep(t *testing.T) {
+	tracker := &nameTracker{last: map[int64]nameSeen{}}
+	tracker.last[1] = nameSeen{name: "a", ts: 100}
+	tracker.last[2] = nameSeen{name: "b", ts: 100 + nameTouchInterval}
+
+	tracker.sweep(100 + nameTouchInterval + 1)
+	if _, ok := tracker.last[1]; ok {
+		t.Error("user not seen within interval should be dropped")
+	}
+	if _, ok := tracker.last[2]; !ok {
+		t.Error("user seen lately should be kept")
+	}
+
+	tracker.last[1] = nameSeen{name: "a", ts: 100}
+	tracker.sweep(100 + nameTouchInterval + 2)
+	if _, ok := tracker.last[1]; !ok {
+		t.Error("sweep should run once an interval")
+	}
+}
@@ -0,0 +1,368 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/zerozwt/octant/server/bs"
+	"github.com/zerozwt/octant/server/utils"
+	"github.com/zerozwt/swe"
+)
+
+const (
+	INGEST_PATH = "/api/ingest/records"
+
+	SHIP_INTERVAL    = time.Second
+	SHIP_MAX_BACKOFF = 30 * time.Second
+	SHIP_TIMEOUT     = 10 * time.Second
+
+	SHIP_BATCH_DEFAULT  = 200
+	SHIP_BUFFER_DEFAULT = 100000
+)
+
+// errRejected is returned when core refuses a batch as invalid, shipping it again would not help
+var errRejected = errors.New("batch rejected by core")
+
+// Shipper is the sink of collectors without access to db. It ships records in batches to the ingest
+// api of core, and keeps them while core is unreachable. At most buffer records are kept, the oldest
+// are dropped beyond that. Records are appended to spool as they are put, spool is truncated once all
+// of them are shipped, so records survive a crash and are shipped after the next Start. Records shipped
+// already may be left in spool by a crash, core skips them by their keys.
+type Shipper struct {
+	url    string
+	secret string
+	spool  string
+	batch  int
+	buffer int
+	client *http.Client
+
+	lock    sync.Mutex
+	pending []*bs.IngestRecord
+	file    *os.File // spool opened for appending
+	spooled int      // lines in spool, including those of records shipped or dropped
+	wake    chan bool
+	stop    chan bool
+	done    chan bool
+}
+
+func NewShipper(coreURL, secret, spool string, batch, buffer int) *Shipper {
+	if batch <= 0 || batch > bs.INGEST_MAX_RECORDS {
+		batch = SHIP_BATCH_DEFAULT
+	}
+	if buffer <= 0 {
+		buffer = SHIP_BUFFER_DEFAULT
+	}
+	return &Shipper{
+		url:    strings.TrimSuffix(coreURL, "/") + INGEST_PATH,
+		secret: secret,
+		spool:  spool,
+		batch:  batch,
+		buffer: buffer,
+		client: &http.Client{Timeout: SHIP_TIMEOUT},
+		wake:   make(chan bool, 1),
+		stop:   make(chan bool),
+		done:   make(chan bool),
+	}
+}
+
+func (s *Shipper) Put(rec *bs.IngestRecord) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.pending = append(s.pending, rec)
+	s.append(rec)
+	if over := len(s.pending) - s.buffer; over > 0 {
+		swe.CtxLogger(nil).Error("%d records not shipped to core are dropped, buffer is full", over)
+		s.pending = s.pending[over:]
+		s.compact()
+	}
+	if len(s.pending) >= s.batch {
+		select {
+		case s.wake <- true:
+		default:
+		}
+	}
+}
+
+// Start loads records left in spool, and ships records until Stop
+func (s *Shipper) Start() error {
+	if err := s.load(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	err := s.rewrite()
+	s.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	go s.run()
+	return nil
+}
+
+// Stop ships what it can before leaving, the rest are kept in spool
+func (s *Shipper) Stop() {
+	close(s.stop)
+	<-s.done
+
+	logger := swe.CtxLogger(nil)
+	if err := s.flush(); err != nil {
+		logger.Error("ship records to core before stopping failed: %v", err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.spool) == 0 && len(s.pending) > 0 {
+		logger.Error("%d records lost, no spool file configured", len(s.pending))
+	}
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+}
+
+func (s *Shipper) run() {
+	defer close(s.done)
+	logger := swe.CtxLogger(nil)
+
+	wait := SHIP_INTERVAL
+	for {
+		timer := time.NewTimer(wait)
+		wake := s.wake
+		if wait > SHIP_INTERVAL {
+			// backing off, records piling up do not hurry it
+			wake = nil
+		}
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+
+		if err := s.flush(); err != nil {
+			wait *= 2
+			if wait > SHIP_MAX_BACKOFF {
+				wait = SHIP_MAX_BACKOFF
+			}
+			logger.Error("ship records to core failed: %v, retry after %v", err, wait)
+			continue
+		}
+		wait = SHIP_INTERVAL
+	}
+}
+
+// flush ships pending records batch by batch until all are shipped or one fails
+func (s *Shipper) flush() error {
+	for {
+		batch := s.peek()
+		if len(batch) == 0 {
+			return nil
+		}
+		err := s.ship(batch)
+		if errors.Is(err, errRejected) {
+			swe.CtxLogger(nil).Error("%d records rejected by core are dropped", len(batch))
+		} else if err != nil {
+			return err
+		}
+		s.remove(batch)
+	}
+}
+
+func (s *Shipper) peek() []*bs.IngestRecord {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	size := len(s.pending)
+	if size > s.batch {
+		size = s.batch
+	}
+	return append([]*bs.IngestRecord{}, s.pending[:size]...)
+}
+
+// remove drops records of batch still pending, some of them may be dropped by Put already
+func (s *Shipper) remove(batch []*bs.IngestRecord) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	last := batch[len(batch)-1]
+	for idx, item := range s.pending {
+		if item == last {
+			s.pending = s.pending[idx+1:]
+			s.compact()
+			return
+		}
+	}
+}
+
+func (s *Shipper) ship(batch []*bs.IngestRecord) error {
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	req := bs.IngestReq{Records: make([]bs.IngestRecord, 0, len(batch))}
+	for _, item := range batch {
+		req.Records = append(req.Records, *item)
+	}
+	body, err := json.Marshal(&req)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ts := time.Now().Unix()
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("User-Agent", "octant-collector")
+	httpReq.Header.Set("X-Octant-Timestamp", fmt.Sprint(ts))
+	httpReq.Header.Set("X-Octant-Signature", utils.SignPayload(s.secret, ts, body))
+
+	rsp, err := s.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(rsp.Body, 64*1024))
+	if err != nil {
+		return err
+	}
+
+	if rsp.StatusCode == http.StatusBadRequest {
+		return errRejected
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("core answered http status %d", rsp.StatusCode)
+	}
+	result := struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}{}
+	if err = json.Unmarshal(data, &result); err != nil {
+		return err
+	}
+	if result.Code != 0 {
+		return fmt.Errorf("core failed to save records: %d %s", result.Code, result.Msg)
+	}
+	return nil
+}
+
+// load reads records left in spool by the last run, the newest buffer records are pending
+func (s *Shipper) load() error {
+	if len(s.spool) == 0 {
+		return nil
+	}
+	file, err := os.Open(s.spool)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	logger := swe.CtxLogger(nil)
+	records := []*bs.IngestRecord{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		rec := &bs.IngestRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), rec); err != nil {
+			logger.Error("skip bad line in spool %s: %v", s.spool, err)
+			continue
+		}
+		records = append(records, rec)
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pending = append(records, s.pending...)
+	if over := len(s.pending) - s.buffer; over > 0 {
+		logger.Error("%d records loaded from spool %s are dropped, buffer is full", over, s.spool)
+		s.pending = s.pending[over:]
+	}
+	logger.Info("%d records loaded from spool %s", len(records), s.spool)
+	return nil
+}
+
+// append writes rec to the end of spool, called with lock held
+func (s *Shipper) append(rec *bs.IngestRecord) {
+	if s.file == nil {
+		return
+	}
+	data, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(rec)
+	if err == nil {
+		_, err = s.file.Write(append(data, '\n'))
+	}
+	if err != nil {
+		swe.CtxLogger(nil).Error("append record %d to spool %s failed: %v", rec.ID, s.spool, err)
+		return
+	}
+	s.spooled++
+}
+
+// compact truncates spool once nothing is pending, or rewrites it once most lines in it are of records
+// shipped or dropped, called with lock held
+func (s *Shipper) compact() {
+	if s.file == nil || s.spooled <= 2*len(s.pending) {
+		return
+	}
+	var err error
+	if len(s.pending) == 0 {
+		if err = s.file.Truncate(0); err == nil {
+			s.spooled = 0
+		}
+	} else {
+		err = s.rewrite()
+	}
+	if err != nil {
+		swe.CtxLogger(nil).Error("compact spool %s failed: %v", s.spool, err)
+	}
+}
+
+// rewrite replaces spool with pending records, one json a line, and opens it for appending. Called with
+// lock held.
+func (s *Shipper) rewrite() error {
+	if len(s.spool) == 0 {
+		return nil
+	}
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	buf := bytes.Buffer{}
+	for _, item := range s.pending {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	tmp := s.spool + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.spool); err != nil {
+		return err
+	}
+
+	if s.file != nil {
+		s.file.Close()
+	}
+	file, err := os.OpenFile(s.spool, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		s.file = nil
+		return err
+	}
+	s.file, s.spooled = file, len(s.pending)
+	return nil
+}
@@ -0,0 +1,116 @@
+package ingest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/zerozwt/octant/server/bs"
+)
+
+func TestShipper(t *testing.T) {
+	Accept("secret")
+	defer Accept("")
+
+	lock := sync.Mutex{}
+	fail := 1
+	got := []int64{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		ts, _ := strconv.ParseInt(r.Header.Get("X-Octant-Timestamp"), 10, 64)
+		if r.URL.Path != INGEST_PATH || Verify(ts, r.Header.Get("X-Octant-Signature"), body, time.Now().Unix()) != nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		if fail > 0 {
+			fail--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		req := bs.IngestReq{}
+		jsoniter.Unmarshal(body, &req)
+		for _, item := range req.Records {
+			got = append(got, item.ID)
+		}
+		w.Write([]byte(`{"code":0,"msg":"","data":{}}`))
+	}))
+	defer server.Close()
+
+	spool := filepath.Join(t.TempDir(), "spool")
+	s := NewShipper(server.URL+"/", "secret", spool, 2, 0)
+	if err := s.Start(); err != nil {
+		t.Fatalf("start shipper failed: %v", err)
+	}
+	for id := int64(1); id <= 5; id++ {
+		s.Put(&bs.IngestRecord{ID: id, Kind: RECORD_SC, RoomID: 1})
+	}
+
+	// the first request fails, records are shipped after backing off
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		lock.Lock()
+		size := len(got)
+		lock.Unlock()
+		if size == 5 {
+			break
+		}
+	}
+	s.Stop()
+
+	lock.Lock()
+	defer lock.Unlock()
+	if !reflect.DeepEqual(got, []int64{1, 2, 3, 4, 5}) {
+		t.Errorf("records shipped %v", got)
+	}
+	// spool is truncated once all records are shipped
+	if info, err := os.Stat(spool); err != nil || info.Size() != 0 {
+		t.Errorf("spool not truncated after shipped: %v %v", info, err)
+	}
+}
+
+func TestShipperSpool(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	coreURL := server.URL
+	server.Close()
+
+	spool := filepath.Join(t.TempDir(), "spool")
+	s := NewShipper(coreURL, "secret", spool, 0, 2)
+	if err := s.Start(); err != nil {
+		t.Fatalf("start shipper failed: %v", err)
+	}
+	for id := int64(1); id <= 3; id++ {
+		s.Put(&bs.IngestRecord{ID: id, Kind: RECORD_SC, RoomID: 1})
+	}
+
+	loaded := func() []int64 {
+		other := NewShipper(coreURL, "secret", spool, 0, 2)
+		if err := other.load(); err != nil {
+			t.Fatalf("load spool failed: %v", err)
+		}
+		ids := []int64{}
+		for _, item := range other.pending {
+			ids = append(ids, item.ID)
+		}
+		return ids
+	}
+
+	// records are in spool before Stop, so that they survive a crash. The oldest record is dropped
+	// when buffer is full.
+	if ids := loaded(); !reflect.DeepEqual(ids, []int64{2, 3}) {
+		t.Errorf("records loaded from spool before stop %v", ids)
+	}
+	s.Stop()
+	if ids := loaded(); !reflect.DeepEqual(ids, []int64{2, 3}) {
+		t.Errorf("records loaded from spool after stop %v", ids)
+	}
+}
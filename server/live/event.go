@@ -0,0 +1,55 @@
+package live
+
+import (
+	"strconv"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/zerozwt/octant/server/bridge"
+	"github.com/zerozwt/swe"
+)
+
+const (
+	EVENT_SC    = "sc"
+	EVENT_GUARD = "guard"
+	EVENT_GIFT  = "gift"
+)
+
+// Event is a paid record collected from a live room, pushed to live streams and webhooks of the room
+type Event struct {
+	ID       int64  `json:"id"`
+	Type     string `json:"type"` // EVENT_*
+	RoomID   int64  `json:"room_id"`
+	UID      int64  `json:"uid"`
+	Name     string `json:"name"`
+	Time     int64  `json:"time"`
+	Message  string `json:"message"`     // content of sc
+	Level    int    `json:"guard_level"` // 1 for 总督 to 3 for 舰长
+	GiftName string `json:"gift_name"`   // name of gift or guard
+	Count    int64  `json:"count"`       // gifts or guard months
+	Price    int64  `json:"price"`       // of one gift or month, CNY for sc & guard, gold coins for gift
+	Value    int64  `json:"value"`       // price * count
+}
+
+// Yuan is value of event in CNY
+func (ev *Event) Yuan() string {
+	if ev.Type == EVENT_GIFT {
+		return strconv.FormatFloat(float64(ev.Value)/1000, 'f', -1, 64)
+	}
+	return strconv.FormatInt(ev.Value, 10)
+}
+
+// Publish relays ev to core instances serving live streams of its room
+func Publish(ctx *swe.Context, ev *Event) {
+	br := bridge.GetBridge()
+	if br == nil {
+		return
+	}
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	data, err := json.Marshal(ev)
+	if err == nil {
+		err = br.PublishEvent(ev.RoomID, data)
+	}
+	if err != nil {
+		swe.CtxLogger(ctx).Error("publish %s event of room %d failed: %v", ev.Type, ev.RoomID, err)
+	}
+}
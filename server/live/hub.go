@@ -0,0 +1,135 @@
+package live
+
+import (
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/zerozwt/swe"
+)
+
+const (
+	// recent events of each room are kept for streams reconnected to catch up
+	HUB_RECENT_EVENTS = 100
+	// a stream with this many events not read is closed, its client catches up after reconnecting
+	STREAM_BUFFER = 64
+)
+
+// Stream gets events of a room as they are collected, until closed
+type Stream struct {
+	roomID int64
+	ch     chan *Event
+	closed bool
+}
+
+// Events is closed when the stream is closed by hub
+func (s *Stream) Events() <-chan *Event { return s.ch }
+
+type roomHub struct {
+	recent  []*Event
+	seen    map[int64]bool
+	streams map[*Stream]bool
+}
+
+// Hub fans events received from bridge out to live streams on this instance
+type Hub struct {
+	lock  sync.Mutex
+	rooms map[int64]*roomHub
+}
+
+var hub *Hub = &Hub{rooms: map[int64]*roomHub{}}
+
+func GetHub() *Hub { return hub }
+
+func (h *Hub) room(roomID int64) *roomHub {
+	ret, ok := h.rooms[roomID]
+	if !ok {
+		ret = &roomHub{seen: map[int64]bool{}, streams: map[*Stream]bool{}}
+		h.rooms[roomID] = ret
+	}
+	return ret
+}
+
+func (h *Hub) OnEvent(roomID int64, data []byte) {
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	ev := &Event{}
+	if err := json.Unmarshal(data, ev); err != nil {
+		swe.CtxLogger(nil).Error("decode live event of room %d failed: %v", roomID, err)
+		return
+	}
+	ev.RoomID = roomID
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	room := h.room(roomID)
+	if room.seen[ev.ID] {
+		return
+	}
+	room.seen[ev.ID] = true
+	room.recent = append(room.recent, ev)
+	if len(room.recent) > HUB_RECENT_EVENTS {
+		delete(room.seen, room.recent[0].ID)
+		room.recent = room.recent[1:]
+	}
+
+	for s := range room.streams {
+		select {
+		case s.ch <- ev:
+		default:
+			h.close(room, s)
+		}
+	}
+}
+
+// Subscribe opens a stream of room, events after lastID are sent first if they are still recent
+func (h *Hub) Subscribe(roomID, lastID int64) *Stream {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	room := h.room(roomID)
+	ret := &Stream{roomID: roomID, ch: make(chan *Event, STREAM_BUFFER)}
+	if lastID > 0 {
+		for idx, ev := range room.recent {
+			if ev.ID != lastID {
+				continue
+			}
+			missed := room.recent[idx+1:]
+			if len(missed) > STREAM_BUFFER {
+				missed = missed[len(missed)-STREAM_BUFFER:]
+			}
+			for _, item := range missed {
+				ret.ch <- item
+			}
+			break
+		}
+	}
+	room.streams[ret] = true
+	return ret
+}
+
+// Recent returns events of room kept in hub, oldest first
+func (h *Hub) Recent(roomID int64) []*Event {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if room, ok := h.rooms[roomID]; ok {
+		return append([]*Event{}, room.recent...)
+	}
+	return nil
+}
+
+// Close stops sending events to s
+func (h *Hub) Close(s *Stream) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if room, ok := h.rooms[s.roomID]; ok {
+		h.close(room, s)
+	}
+}
+
+func (h *Hub) close(room *roomHub, s *Stream) {
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+		delete(room.streams, s)
+	}
+}
@@ -0,0 +1,44 @@
+package live
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHub(t *testing.T) {
+	h := &Hub{rooms: map[int64]*roomHub{}}
+	send := func(id int64) { h.OnEvent(1, []byte(fmt.Sprintf(`{"id":%d,"type":"sc"}`, id))) }
+
+	send(1)
+	send(2)
+	s := h.Subscribe(1, 0)
+	send(3)
+	send(3) // relayed twice
+	send(4)
+
+	// events after 2 are sent first to a reconnected stream
+	r := h.Subscribe(1, 2)
+	for _, stream := range []*Stream{s, r} {
+		for _, id := range []int64{3, 4} {
+			if ev := <-stream.Events(); ev.ID != id || ev.RoomID != 1 {
+				t.Fatalf("got %+v ans %d", ev, id)
+			}
+		}
+	}
+
+	// a stream not read is closed after its buffer is full
+	h.Close(r)
+	for i := 0; i <= STREAM_BUFFER; i++ {
+		send(int64(i + 10))
+	}
+	count := 0
+	for range s.Events() {
+		count++
+	}
+	if count != STREAM_BUFFER {
+		t.Errorf("got %d events before closed", count)
+	}
+	if len(h.Recent(1)) != STREAM_BUFFER+5 {
+		t.Errorf("recent events %d", len(h.Recent(1)))
+	}
+}
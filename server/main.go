@@ -10,12 +10,17 @@ import (
 	"strings"
 	"syscall"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/zerozwt/octant/server/async_task"
 	"github.com/zerozwt/octant/server/bridge"
 	"github.com/zerozwt/octant/server/collector"
 	"github.com/zerozwt/octant/server/db"
 	"github.com/zerozwt/octant/server/handler"
+	"github.com/zerozwt/octant/server/handler/batch_dm"
+	"github.com/zerozwt/octant/server/ingest"
+	"github.com/zerozwt/octant/server/live"
+	"github.com/zerozwt/octant/server/utils"
 	"github.com/zerozwt/swe"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
@@ -25,7 +30,9 @@ func main() {
 
 	// load config
 	confFile := ""
+	rebuildRollup := false
 	flag.StringVar(&confFile, "conf", "", "config file")
+	flag.BoolVar(&rebuildRollup, "rebuild-rollup", false, "recompute rollups from raw records and exit")
 	flag.Parse()
 	if len(confFile) > 0 {
 		if err := LoadConfig(confFile); err != nil {
@@ -44,17 +51,37 @@ func main() {
 	logger = swe.CtxLogger(nil)
 	logger.Info("config %s loaded", confFile)
 
-	// init db
-	logger.Info("init db ...")
-	if err := InitDB(); err != nil {
-		logger.Error("init db failed: %v", err)
-		return
+	if loc := gConfig.Location(); loc != nil {
+		utils.SetLocalLocation(loc)
+		logger.Info("statistics timezone set to %s", loc)
 	}
+	utils.SetAllowedNetworks(gConfig.AllowedNetworks())
 
-	// setting admin password
-	if err := TrySetAdminPassword(); err != nil {
-		logger.Error("init admin password failed: %v", err)
-		return
+	// init db, collectors shipping records to core have no access to it
+	if gConfig.ShipsToCore() {
+		if rebuildRollup {
+			logger.Error("rollups can not be rebuilt by collectors shipping records to core")
+			return
+		}
+	} else {
+		logger.Info("init db ...")
+		if err := InitDB(); err != nil {
+			logger.Error("init db failed: %v", err)
+			return
+		}
+
+		if rebuildRollup {
+			if err := RebuildRollups(); err != nil {
+				logger.Error("rebuild rollups failed: %v", err)
+			}
+			return
+		}
+
+		// setting admin password
+		if err := TrySetAdminPassword(); err != nil {
+			logger.Error("init admin password failed: %v", err)
+			return
+		}
 	}
 
 	// init collector bridge
@@ -72,8 +99,25 @@ func main() {
 		}
 	}()
 
+	// instances serving api push live events relayed by bridge to streams
+	if gConfig.Service.Core {
+		collectorBridge.SetEventReceiver(live.GetHub())
+	}
+
 	// init collector if needed
 	if gConfig.Service.Collector {
+		if gConfig.ShipsToCore() {
+			shipper := ingest.NewShipper(gConfig.Ingest.CoreURL, gConfig.Ingest.Secret, gConfig.Ingest.Spool,
+				gConfig.Ingest.BatchSize, gConfig.Ingest.BufferSize)
+			if err := shipper.Start(); err != nil {
+				logger.Error("start shipping records to core failed: %v", err)
+				return
+			}
+			// stopped after collector, so that records collected till then are kept
+			defer shipper.Stop()
+			collector.GetCollector().SetSink(shipper)
+			logger.Info("records are shipped to core %s", gConfig.Ingest.CoreURL)
+		}
 		collectorBridge.SetReceiver(collector.GetCollector())
 		logger.Info("data collector started")
 	}
@@ -83,18 +127,40 @@ func main() {
 	engine := InitEngine()
 	if engine != nil {
 		logger.Info("init async task system ...")
+		async_task.SetWorkers(gConfig.AsyncTask.Workers)
+		for name, limit := range gConfig.AsyncTask.Limits {
+			async_task.SetConcurrency(name, limit)
+		}
 		if err := async_task.Init(); err != nil {
 			logger.Error("init async task system failed: %v", err)
 			return
 		}
+		for _, item := range gConfig.DMTransports {
+			if item.Type == "webhook" {
+				batch_dm.RegisterTransport(item.Name, batch_dm.NewWebhookTransport(item.URL, item.Secret,
+					time.Duration(item.Timeout)*time.Second))
+			} else {
+				batch_dm.RegisterTransport(item.Name, batch_dm.NewMockTransport(item.File, item.Codes))
+			}
+		}
+		ingest.Accept(gConfig.Ingest.Secret)
+		if err := batch_dm.SetServerKey(nil, gConfig.DMSenderKey); err != nil {
+			logger.Error("set dm sender key failed: %v", err)
+			return
+		}
+		if err := batch_dm.GetManager().Resume(nil); err != nil {
+			logger.Error("resume direct msg tasks failed: %v", err)
+		}
 		go engine.Serve(gConfig.WebAddr())
 		logger.Info("web app service started on %s", gConfig.WebAddr())
 	}
 
-	// load streamers from db
-	if err := LoadStreamers(); err != nil {
-		logger.Error("load streamers from db failed: %v", err)
-		return
+	// load streamers from db, rooms are tracked by core instances for collectors without db
+	if !gConfig.ShipsToCore() {
+		if err := LoadStreamers(); err != nil {
+			logger.Error("load streamers from db failed: %v", err)
+			return
+		}
 	}
 
 	chStop := make(chan bool, 1)
@@ -131,6 +197,22 @@ func InitDB() error {
 	return nil
 }
 
+func RebuildRollups() error {
+	logger := swe.CtxLogger(nil)
+	rooms, err := db.GetRollupDAL().Rooms(nil)
+	if err != nil {
+		return err
+	}
+	for _, roomID := range rooms {
+		logger.Info("rebuilding rollups of room %d ...", roomID)
+		if err := db.GetRollupDAL().Rebuild(nil, roomID); err != nil {
+			return fmt.Errorf("room %d: %v", roomID, err)
+		}
+	}
+	logger.Info("rollups of %d rooms rebuilt", len(rooms))
+	return nil
+}
+
 func InitCollectorBridge() (bridge.Bridge, *clientv3.Client, error) {
 	var collectorBridge bridge.Bridge
 	var client *clientv3.Client
@@ -0,0 +1,209 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type ChineseAddress struct {
+	Province string `json:"province"`
+	City     string `json:"city"`
+	District string `json:"district"`
+	Detail   string `json:"detail"`
+}
+
+type provinceInfo struct {
+	short     string
+	full      string
+	municipal bool
+}
+
+var provinces []provinceInfo = []provinceInfo{
+	{"北京", "北京市", true},
+	{"天津", "天津市", true},
+	{"上海", "上海市", true},
+	{"重庆", "重庆市", true},
+	{"河北", "河北省", false},
+	{"山西", "山西省", false},
+	{"辽宁", "辽宁省", false},
+	{"吉林", "吉林省", false},
+	{"黑龙江", "黑龙江省", false},
+	{"江苏", "江苏省", false},
+	{"浙江", "浙江省", false},
+	{"安徽", "安徽省", false},
+	{"福建", "福建省", false},
+	{"江西", "江西省", false},
+	{"山东", "山东省", false},
+	{"河南", "河南省", false},
+	{"湖北", "湖北省", false},
+	{"湖南", "湖南省", false},
+	{"广东", "广东省", false},
+	{"海南", "海南省", false},
+	{"四川", "四川省", false},
+	{"贵州", "贵州省", false},
+	{"云南", "云南省", false},
+	{"陕西", "陕西省", false},
+	{"甘肃", "甘肃省", false},
+	{"青海", "青海省", false},
+	{"台湾", "台湾省", false},
+	{"内蒙古", "内蒙古自治区", false},
+	{"广西", "广西壮族自治区", false},
+	{"西藏", "西藏自治区", false},
+	{"宁夏", "宁夏回族自治区", false},
+	{"新疆", "新疆维吾尔自治区", false},
+	{"香港", "香港特别行政区", true},
+	{"澳门", "澳门特别行政区", true},
+}
+
+var provinceSuffixes []string = []string{"省", "市", "壮族自治区", "回族自治区", "维吾尔自治区", "自治区", "特别行政区"}
+var citySuffixes []string = []string{"自治州", "地区", "市", "盟"}
+var districtSuffixes []string = []string{"区", "县", "市", "旗"}
+
+const (
+	maxCityRunes     = 8
+	maxDistrictRunes = 8
+	minDetailRunes   = 4
+
+	addrStopRunes = "路街道镇乡村号巷弄"
+)
+
+// ParseChineseAddress splits a free-text chinese address into province, city, district and detail.
+// Parts that cannot be recognized are left empty, the unparsed remainder goes to Detail.
+func ParseChineseAddress(addr string) ChineseAddress {
+	ret := ChineseAddress{}
+	rest := trimAddrSeparator(addr)
+
+	// province
+	for _, item := range provinces {
+		if strings.HasPrefix(rest, item.full) {
+			ret.Province = item.full
+			rest = rest[len(item.full):]
+		} else if strings.HasPrefix(rest, item.short) {
+			ret.Province = item.full
+			rest = rest[len(item.short):]
+			for _, suffix := range provinceSuffixes {
+				if strings.HasPrefix(rest, suffix) {
+					rest = rest[len(suffix):]
+					break
+				}
+			}
+		} else {
+			continue
+		}
+
+		rest = trimAddrSeparator(rest)
+		if item.municipal {
+			ret.City = item.full
+			// some people write the municipality twice, e.g. 北京市北京市朝阳区
+			if strings.HasPrefix(rest, item.full) {
+				rest = trimAddrSeparator(rest[len(item.full):])
+			} else if strings.HasPrefix(rest, "市辖区") {
+				rest = trimAddrSeparator(rest[len("市辖区"):])
+			}
+		}
+		break
+	}
+
+	// city
+	if len(ret.City) == 0 {
+		if seg, ok := cutAddrSegment(rest, citySuffixes, maxCityRunes); ok {
+			ret.City = seg
+			rest = trimAddrSeparator(rest[len(seg):])
+		}
+	}
+
+	// district
+	if seg, ok := cutAddrSegment(rest, districtSuffixes, maxDistrictRunes); ok {
+		ret.District = seg
+		rest = trimAddrSeparator(rest[len(seg):])
+	}
+
+	ret.Detail = strings.TrimSpace(rest)
+	return ret
+}
+
+// cutAddrSegment finds the shortest prefix of value ending with one of suffixes, and no longer than maxRunes.
+// Street level words stop the search so that something like 长安镇乌沙社区 is not taken as a district.
+func cutAddrSegment(value string, suffixes []string, maxRunes int) (string, bool) {
+	count := 0
+	for idx, ch := range value {
+		if count >= maxRunes || unicode.IsSpace(ch) || strings.ContainsRune(addrStopRunes, ch) {
+			break
+		}
+		count += 1
+		if count < 2 {
+			continue
+		}
+		tail := value[idx:]
+		for _, suffix := range suffixes {
+			if strings.HasPrefix(tail, suffix) {
+				return value[:idx+len(suffix)], true
+			}
+		}
+	}
+	return "", false
+}
+
+func trimAddrSeparator(value string) string {
+	return strings.TrimLeftFunc(value, func(ch rune) bool {
+		return unicode.IsSpace(ch) || strings.ContainsRune(",，、-/", ch)
+	})
+}
+
+var mobileChecker *regexp.Regexp = regexp.MustCompile(`^1[3-9][0-9]{9}$`)
+
+// NormalizeMobile strips separators and the +86 prefix from a mobile number
+func NormalizeMobile(phone string) string {
+	ret := strings.Map(func(ch rune) rune {
+		if unicode.IsSpace(ch) || ch == '-' || ch == '(' || ch == ')' {
+			return -1
+		}
+		return ch
+	}, phone)
+	ret = strings.TrimPrefix(ret, "+86")
+	if len(ret) == 13 && strings.HasPrefix(ret, "86") {
+		ret = ret[2:]
+	}
+	return ret
+}
+
+func IsValidMobile(phone string) bool {
+	return mobileChecker.MatchString(NormalizeMobile(phone))
+}
+
+// CheckShippingAddress returns a list of human readable problems found in the address, empty if it looks fine
+func CheckShippingAddress(addr *RewardUserAddress) []string {
+	ret := []string{}
+
+	if len(strings.TrimSpace(addr.Name)) == 0 {
+		ret = append(ret, "收件人姓名为空")
+	}
+	if len(strings.TrimSpace(addr.Phone)) == 0 {
+		ret = append(ret, "手机号为空")
+	} else if !IsValidMobile(addr.Phone) {
+		ret = append(ret, "手机号格式错误")
+	}
+
+	if len(strings.TrimSpace(addr.Addr)) == 0 {
+		ret = append(ret, "收件地址为空")
+		return ret
+	}
+
+	parsed := ParseChineseAddress(addr.Addr)
+	if len(parsed.Province) == 0 {
+		ret = append(ret, "无法识别省份")
+	}
+	if len(parsed.City) == 0 {
+		ret = append(ret, "无法识别城市")
+	}
+	if len(parsed.District) == 0 {
+		ret = append(ret, "无法识别区县")
+	}
+	if utf8.RuneCountInString(parsed.Detail) < minDetailRunes {
+		ret = append(ret, "详细地址过短")
+	}
+
+	return ret
+}
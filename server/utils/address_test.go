@@ -0,0 +1,43 @@
+package utils
+
+import "testing"
+
+func TestParseChineseAddress(t *testing.T) {
+	cases := []struct {
+		addr string
+		ans  ChineseAddress
+	}{
+		{"广东省深圳市南山区科技园南路1号", ChineseAddress{"广东省", "深圳市", "南山区", "科技园南路1号"}},
+		{"广东 东莞市 长安镇乌沙社区5号", ChineseAddress{"广东省", "东莞市", "", "长安镇乌沙社区5号"}},
+		{"北京市北京市朝阳区建国路88号", ChineseAddress{"北京市", "北京市", "朝阳区", "建国路88号"}},
+		{"上海浦东新区世纪大道100号", ChineseAddress{"上海市", "上海市", "浦东新区", "世纪大道100号"}},
+		{"内蒙古呼和浩特市新城区新华大街1号", ChineseAddress{"内蒙古自治区", "呼和浩特市", "新城区", "新华大街1号"}},
+		{"新疆维吾尔自治区伊犁哈萨克自治州伊宁市解放路2号", ChineseAddress{"新疆维吾尔自治区", "伊犁哈萨克自治州", "伊宁市", "解放路2号"}},
+		{"火星基地", ChineseAddress{"", "", "", "火星基地"}},
+	}
+
+	for _, item := range cases {
+		ret := ParseChineseAddress(item.addr)
+		if ret != item.ans {
+			t.Errorf("parse %s got %+v ans %+v", item.addr, ret, item.ans)
+		}
+	}
+}
+
+func TestIsValidMobile(t *testing.T) {
+	cases := map[string]bool{
+		"13800138000":       true,
+		"138-0013-8000":     true,
+		"+86 138 0013 8000": true,
+		"8613800138000":     true,
+		"12800138000":       false,
+		"1380013800":        false,
+		"010-12345678":      false,
+	}
+
+	for phone, ans := range cases {
+		if IsValidMobile(phone) != ans {
+			t.Errorf("check mobile %s ans %v", phone, ans)
+		}
+	}
+}
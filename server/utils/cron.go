@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a standard 5 field cron expression "minute hour day-of-month month day-of-week"
+// evaluated in the configured timezone. Fields support *, lists, ranges and steps like 1-10/2,
+// sunday is 0 or 7. Like vixie cron, a day matches if either day field matches when both are restricted.
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+var cronDescriptors map[string]string = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cron expressions not matching anything within this many years are rejected, e.g. "0 0 30 2 *"
+const cronSearchYears = 5
+
+func ParseCron(spec string) (*CronSchedule, error) {
+	if value, ok := cronDescriptors[strings.TrimSpace(spec)]; ok {
+		spec = value
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q should have 5 fields", spec)
+	}
+
+	ret := &CronSchedule{}
+	bounds := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	dest := []*uint64{&ret.minute, &ret.hour, &ret.dom, &ret.month, &ret.dow}
+	for idx, field := range fields {
+		bits, err := parseCronField(field, bounds[idx][0], bounds[idx][1])
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %v", spec, err)
+		}
+		*dest[idx] = bits
+	}
+	if ret.dow&(1<<7) != 0 {
+		ret.dow |= 1
+	}
+	ret.domAny = fields[2] == "*"
+	ret.dowAny = fields[4] == "*"
+
+	if ret.Next(time.Now().Unix()) == 0 {
+		return nil, fmt.Errorf("cron expression %q never fires", spec)
+	}
+	return ret, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var ret uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if pos := strings.IndexByte(part, '/'); pos >= 0 {
+			value, err := strconv.Atoi(part[pos+1:])
+			if err != nil || value < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rng, step = part[:pos], value
+		}
+
+		begin, end := min, max
+		if rng != "*" {
+			pos := strings.IndexByte(rng, '-')
+			var err error
+			if pos < 0 {
+				begin, err = strconv.Atoi(rng)
+				end = begin
+				if step > 1 {
+					// "5/10" means from 5 to the max
+					end = max
+				}
+			} else {
+				begin, err = strconv.Atoi(rng[:pos])
+				if err == nil {
+					end, err = strconv.Atoi(rng[pos+1:])
+				}
+			}
+			if err != nil || begin < min || end > max || begin > end {
+				return 0, fmt.Errorf("invalid range %q, should be within %d-%d", part, min, max)
+			}
+		}
+
+		for value := begin; value <= end; value += step {
+			ret |= 1 << value
+		}
+	}
+	return ret, nil
+}
+
+func (c *CronSchedule) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first time strictly after ts the schedule fires, or 0 if there is none
+func (c *CronSchedule) Next(ts int64) int64 {
+	loc := localLoc
+	t := time.Unix(ts, 0).In(loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.Year() + cronSearchYears
+
+	for t.Year() <= limit {
+		if c.month&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if c.hour&(1<<t.Hour()) == 0 {
+			// adding a duration instead of normalizing the date keeps moving forward over dst changes
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+		if c.minute&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t.Unix()
+	}
+	return 0
+}
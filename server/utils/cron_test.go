@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	defer SetLocalLocation(LocalLocation())
+	SetLocalLocation(cstLoc)
+
+	at := func(value string) int64 {
+		ret, err := TimeStringToUTC(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ret
+	}
+
+	cases := []struct {
+		spec string
+		from string
+		ans  string
+	}{
+		{"* * * * *", "20230801120030", "20230801120100"},
+		{"0 0 * * *", "20230801120000", "20230802000000"},
+		{"@hourly", "20230801120000", "20230801130000"},
+		{"*/15 9-10 * * *", "20230801104500", "20230802090000"},
+		{"5/20 * * * *", "20230801100600", "20230801102500"},
+		{"30 3 * * 1-5", "20230804040000", "20230807033000"},
+		{"0 0 * * 7", "20230801000000", "20230806000000"},
+		{"0 0 1,15 * *", "20230802000000", "20230815000000"},
+		{"0 0 13 * 5", "20230801000000", "20230804000000"},
+		{"0 0 29 2 *", "20230301000000", "20240229000000"},
+		{"0 12 31 * *", "20230430120000", "20230531120000"},
+	}
+
+	for _, item := range cases {
+		sched, err := ParseCron(item.spec)
+		if err != nil {
+			t.Errorf("parse %s failed: %v", item.spec, err)
+			continue
+		}
+		if ret := sched.Next(at(item.from)); ret != at(item.ans) {
+			t.Errorf("%s from %s got %s ans %s", item.spec, item.from, TimeToCSTString(ret), item.ans)
+		}
+	}
+}
+
+func TestCronDST(t *testing.T) {
+	defer SetLocalLocation(LocalLocation())
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	SetLocalLocation(loc)
+
+	// 02:30 does not exist on 2023-03-12, the next one is a day later
+	sched, _ := ParseCron("30 2 * * *")
+	from := time.Date(2023, 3, 11, 3, 0, 0, 0, loc).Unix()
+	if ret := sched.Next(from); ret != time.Date(2023, 3, 13, 2, 30, 0, 0, loc).Unix() {
+		t.Errorf("got %s", time.Unix(ret, 0).In(loc))
+	}
+
+	// hourly jobs fire once an hour over the gap
+	sched, _ = ParseCron("0 * * * *")
+	from = time.Date(2023, 3, 12, 1, 0, 0, 0, loc).Unix()
+	if ret := sched.Next(from); ret != from+3600 {
+		t.Errorf("got %s", time.Unix(ret, 0).In(loc))
+	}
+}
+
+func TestCronInvalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *",
+		"* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "0 0 30 2 *"} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("%q should be invalid", spec)
+		}
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/ecdh"
+	"crypto/hmac"
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 )
 
@@ -104,3 +106,66 @@ func Decrypt(sharedKey []byte, b64cipherData string) ([]byte, error) {
 	nonce := md5.Sum(sharedKey)
 	return gcm.Open(nil, nonce[:12], cipherText, nil)
 }
+
+// Seal encrypts data with a random nonce prepended to the cipher text, unlike Encrypt
+// it is safe to seal many different values with the same key
+func Seal(sharedKey, data []byte) (string, error) {
+	block, err := aes.NewCipher(sharedKey)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return Base64Encode(gcm.Seal(nonce, nonce, data, nil)), nil
+}
+
+func Open(sharedKey []byte, b64cipherData string) ([]byte, error) {
+	cipherText, err := Base64Decode(b64cipherData)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(sharedKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cipherText) < gcm.NonceSize() {
+		return nil, fmt.Errorf("cipher text too short")
+	}
+	return gcm.Open(nil, cipherText[:gcm.NonceSize()], cipherText[gcm.NonceSize():], nil)
+}
+
+// SignPayload signs requests octant posts to outside urls, it is hex of hmac-sha256 over timestamp + "." + body
+func SignPayload(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", ts)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RandomKey is a random 128-bit key in hex, unique across processes without any coordination
+func RandomKey() string {
+	var buf [16]byte
+	rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+// RandomToken is a random url-safe string, for secrets put in urls
+func RandomToken() string {
+	var buf [24]byte
+	rand.Read(buf[:])
+	return base64.RawURLEncoding.EncodeToString(buf[:])
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Requests to urls given by streamers, e.g. webhooks, must not reach the network of the server
+// itself. Loopback, private and link-local destinations are refused unless allowed by config.
+
+var ErrNotPublic error = errors.New("destination is not public")
+
+var allowLock sync.RWMutex
+var allowedNets []*net.IPNet
+
+// SetAllowedNetworks allows destinations in nets even if they are not public
+func SetAllowedNetworks(nets []*net.IPNet) {
+	allowLock.Lock()
+	defer allowLock.Unlock()
+	allowedNets = nets
+}
+
+// CheckPublicIP returns an error if ip is not public and not allowed
+func CheckPublicIP(ip net.IP) error {
+	if !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified()) {
+		return nil
+	}
+	allowLock.RLock()
+	defer allowLock.RUnlock()
+	for _, item := range allowedNets {
+		if item.Contains(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrNotPublic, ip)
+}
+
+// CheckPublicHost checks host of an url without resolving it, names resolved later are checked by
+// PublicDialControl when connecting
+func CheckPublicHost(host string) error {
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		return CheckPublicIP(ip)
+	}
+	if name := strings.ToLower(strings.TrimSuffix(host, ".")); name == "localhost" || strings.HasSuffix(name, ".localhost") {
+		return CheckPublicIP(net.IPv4(127, 0, 0, 1))
+	}
+	return nil
+}
+
+// PublicDialControl is a net.Dialer Control refusing connections to addresses not public, it sees the
+// address after resolving so that names resolved to private addresses can not get through
+func PublicDialControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("invalid destination %s", address)
+	}
+	return CheckPublicIP(ip)
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCheckPublicHost(t *testing.T) {
+	defer SetAllowedNetworks(nil)
+
+	cases := []struct {
+		host string
+		ok   bool
+	}{
+		{"example.com", true},
+		{"8.8.8.8", true},
+		{"[2001:4860:4860::8888]", true},
+		{"127.0.0.1", false},
+		{"localhost", false},
+		{"api.localhost.", false},
+		{"10.1.2.3", false},
+		{"192.168.1.10", false},
+		{"169.254.169.254", false},
+		{"0.0.0.0", false},
+		{"[::1]", false},
+		{"[fe80::1]", false},
+		{"[fd00::1]", false},
+	}
+	for _, item := range cases {
+		if err := CheckPublicHost(item.host); (err == nil) != item.ok {
+			t.Errorf("host %s got %v", item.host, err)
+		}
+	}
+
+	_, ipNet, _ := net.ParseCIDR("192.168.1.0/24")
+	SetAllowedNetworks([]*net.IPNet{ipNet})
+	if err := CheckPublicHost("192.168.1.10"); err != nil {
+		t.Errorf("allowed network refused: %v", err)
+	}
+	if err := CheckPublicHost("192.168.2.10"); err == nil {
+		t.Errorf("network not allowed passed")
+	}
+}
@@ -1,53 +1,120 @@
 package utils
 
+import "container/heap"
+
+// PriorQueue is a binary heap of pointers, values are popped in cmp order and values equal in cmp
+// are popped in the order they were put. Values are indexed by pointer, so that one can be removed
+// or moved after its fields used by cmp change. A value is in the queue at most once.
 type PriorQueue[T any] struct {
-	data []*T
-	cmp  func(*T, *T) bool
+	heap  pqHeap[T]
+	index map[*T]*pqItem[T]
+	seq   uint64
+}
+
+type pqItem[T any] struct {
+	value *T
+	seq   uint64
+	pos   int
+}
+
+type pqHeap[T any] struct {
+	items []*pqItem[T]
+	cmp   func(*T, *T) bool
+}
+
+func (h *pqHeap[T]) Len() int { return len(h.items) }
+
+func (h *pqHeap[T]) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	if h.cmp(a.value, b.value) {
+		return true
+	}
+	if h.cmp(b.value, a.value) {
+		return false
+	}
+	return a.seq < b.seq
+}
+
+func (h *pqHeap[T]) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.items[i].pos = i
+	h.items[j].pos = j
+}
+
+func (h *pqHeap[T]) Push(x any) {
+	item := x.(*pqItem[T])
+	item.pos = len(h.items)
+	h.items = append(h.items, item)
+}
+
+func (h *pqHeap[T]) Pop() any {
+	last := len(h.items) - 1
+	item := h.items[last]
+	h.items[last] = nil
+	h.items = h.items[:last]
+	return item
 }
 
 func PriorityQueue[T any](cmp func(*T, *T) bool) *PriorQueue[T] {
 	return &PriorQueue[T]{
-		data: make([]*T, 0),
-		cmp:  cmp,
+		heap:  pqHeap[T]{cmp: cmp},
+		index: map[*T]*pqItem[T]{},
 	}
 }
 
+// Put adds value to the queue, or moves it as a new one if it is already in
 func (q *PriorQueue[T]) Put(value *T) {
-	idx := q.binarySearch(value, 0, len(q.data))
-	if idx == len(q.data) {
-		q.data = append(q.data, value)
+	q.seq++
+	if item, ok := q.index[value]; ok {
+		item.seq = q.seq
+		heap.Fix(&q.heap, item.pos)
 		return
 	}
-	tmp := make([]*T, 0, len(q.data)+1)
-	tmp = append(tmp, q.data[:idx]...)
-	tmp = append(tmp, value)
-	tmp = append(tmp, q.data[idx:]...)
-	q.data = tmp
+	item := &pqItem[T]{value: value, seq: q.seq}
+	q.index[value] = item
+	heap.Push(&q.heap, item)
 }
 
 func (q *PriorQueue[T]) Head() *T {
-	if len(q.data) > 0 {
-		return q.data[0]
+	if len(q.heap.items) > 0 {
+		return q.heap.items[0].value
 	}
 	return nil
 }
 
 func (q *PriorQueue[T]) Pop() *T {
-	if len(q.data) == 0 {
+	if len(q.heap.items) == 0 {
 		return nil
 	}
-	ret := q.data[0]
-	q.data = q.data[1:]
-	return ret
+	item := heap.Pop(&q.heap).(*pqItem[T])
+	delete(q.index, item.value)
+	return item.value
 }
 
-func (q *PriorQueue[T]) binarySearch(value *T, l, r int) int {
-	if l == r {
-		return l
+func (q *PriorQueue[T]) Len() int { return len(q.heap.items) }
+
+func (q *PriorQueue[T]) Contains(value *T) bool {
+	_, ok := q.index[value]
+	return ok
+}
+
+// Remove takes value out of the queue, returns false if it is not in
+func (q *PriorQueue[T]) Remove(value *T) bool {
+	item, ok := q.index[value]
+	if !ok {
+		return false
 	}
-	mid := l + ((r - l) >> 1)
-	if q.cmp(value, q.data[mid]) {
-		return q.binarySearch(value, l, mid)
+	heap.Remove(&q.heap, item.pos)
+	delete(q.index, value)
+	return true
+}
+
+// Fix moves value to its place after fields used by cmp changed, keeping its order among equal values
+func (q *PriorQueue[T]) Fix(value *T) bool {
+	item, ok := q.index[value]
+	if !ok {
+		return false
 	}
-	return q.binarySearch(value, mid+1, r)
+	heap.Fix(&q.heap, item.pos)
+	return true
 }
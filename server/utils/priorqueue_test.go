@@ -2,6 +2,8 @@ package utils
 
 import (
 	"fmt"
+	"math/rand"
+	"sort"
 	"testing"
 )
 
@@ -18,13 +20,133 @@ func TestQueue(t *testing.T) {
 
 	ans := []int{1, 2, 3, 4, 4, 5}
 
-	if len(q.data) != len(ans) {
-		t.Error(fmt.Errorf("data len %d ans len %d", len(q.data), len(ans)))
+	if q.Len() != len(ans) {
+		t.Error(fmt.Errorf("data len %d ans len %d", q.Len(), len(ans)))
 	}
 
 	for idx, value := range ans {
-		if *q.data[idx] != value {
-			t.Error(fmt.Errorf("idx %d value %d ans %d", idx, *q.data[idx], ans))
+		if ret := q.Pop(); ret == nil || *ret != value {
+			t.Error(fmt.Errorf("idx %d value %v ans %d", idx, ret, value))
+		}
+	}
+	if q.Pop() != nil || q.Head() != nil {
+		t.Error("queue should be empty")
+	}
+}
+
+type pqTestItem struct {
+	key int
+	seq int
+}
+
+func TestQueueStable(t *testing.T) {
+	q := PriorityQueue(func(a, b *pqTestItem) bool { return a.key < b.key })
+	items := []*pqTestItem{}
+	for i := 0; i < 1000; i++ {
+		item := &pqTestItem{key: rand.Intn(10), seq: i}
+		items = append(items, item)
+		q.Put(item)
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].key < items[j].key })
+
+	for idx, item := range items {
+		if ret := q.Pop(); ret != item {
+			t.Fatalf("idx %d got %+v ans %+v", idx, *ret, *item)
+		}
+	}
+}
+
+func TestQueueRemoveFix(t *testing.T) {
+	q := PriorityQueue(func(a, b *pqTestItem) bool { return a.key < b.key })
+	items := []*pqTestItem{}
+	for i := 0; i < 100; i++ {
+		item := &pqTestItem{key: i, seq: i}
+		items = append(items, item)
+		q.Put(item)
+	}
+
+	// remove odd keys, move keys of multiples of 10 to the end, put one again
+	for i := 1; i < 100; i += 2 {
+		if !q.Remove(items[i]) {
+			t.Fatalf("remove %d failed", i)
+		}
+	}
+	if q.Remove(items[1]) || q.Contains(items[1]) {
+		t.Fatal("removed item still in queue")
+	}
+	for i := 0; i < 100; i += 10 {
+		items[i].key = 1000
+		q.Fix(items[i])
+	}
+	q.Put(items[2])
+
+	ans := []int{}
+	for i := 4; i < 100; i += 2 {
+		if i%10 != 0 {
+			ans = append(ans, i)
+		}
+	}
+	ans = append([]int{2}, ans...)
+	for i := 0; i < 100; i += 10 {
+		ans = append(ans, i)
+	}
+
+	if q.Len() != len(ans) {
+		t.Fatalf("len %d ans %d", q.Len(), len(ans))
+	}
+	for idx, seq := range ans {
+		if ret := q.Pop(); ret.seq != seq {
+			t.Errorf("idx %d got %d ans %d", idx, ret.seq, seq)
+		}
+	}
+}
+
+func benchQueue(b *testing.B, size int) (*PriorQueue[int], []*int) {
+	q := PriorityQueue(func(a, b *int) bool { return *a < *b })
+	values := make([]*int, size)
+	for i := range values {
+		value := rand.Intn(size)
+		values[i] = &value
+		q.Put(values[i])
+	}
+	b.ResetTimer()
+	return q, values
+}
+
+func BenchmarkQueuePutPop(b *testing.B) {
+	for _, size := range []int{1000, 100000} {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			q, _ := benchQueue(b, size)
+			for i := 0; i < b.N; i++ {
+				value := rand.Intn(size)
+				q.Put(&value)
+				q.Pop()
+			}
+		})
+	}
+}
+
+func BenchmarkQueueRemove(b *testing.B) {
+	for _, size := range []int{1000, 100000} {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			q, values := benchQueue(b, size)
+			for i := 0; i < b.N; i++ {
+				value := values[rand.Intn(size)]
+				q.Remove(value)
+				q.Put(value)
+			}
+		})
+	}
+}
+
+func BenchmarkQueueFill(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		q := PriorityQueue(func(a, b *int) bool { return *a < *b })
+		for j := 0; j < 100000; j++ {
+			value := j ^ 0x5555
+			q.Put(&value)
+		}
+		for q.Pop() != nil {
 		}
 	}
 }
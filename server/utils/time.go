@@ -16,7 +16,22 @@ var cstLoc *time.Location = time.FixedZone("CST", 8*3600)
 var errInvalidTimeString error = fmt.Errorf("invalid time string")
 var maxDayInMonths []int = []int{31, 28, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}
 
+// localLoc is the timezone statistics are bucketed in, CST unless configured otherwise
+var localLoc *time.Location = cstLoc
+
+func SetLocalLocation(loc *time.Location) { localLoc = loc }
+func LocalLocation() *time.Location       { return localLoc }
+
 func TimeStringToUTC(ts string) (int64, error) {
+	return timeStringIn(ts, cstLoc)
+}
+
+// LocalTimeStringToUTC is TimeStringToUTC in the configured timezone
+func LocalTimeStringToUTC(ts string) (int64, error) {
+	return timeStringIn(ts, localLoc)
+}
+
+func timeStringIn(ts string, loc *time.Location) (int64, error) {
 	year := strToInt(ts, 0, 4)
 	if year == 0 {
 		return 0, errInvalidTimeString
@@ -41,7 +56,7 @@ func TimeStringToUTC(ts string) (int64, error) {
 	if second > 59 {
 		return 0, errInvalidTimeString
 	}
-	return time.Date(year, time.Month(month), day, hour, minute, second, 0, cstLoc).Unix(), nil
+	return time.Date(year, time.Month(month), day, hour, minute, second, 0, loc).Unix(), nil
 }
 
 func strToInt(value string, begin, end int) int {
@@ -64,3 +79,113 @@ func TimeToCSTString(timestamp int64) string {
 		ts.Year(), ts.Month(), ts.Day(),
 		ts.Hour(), ts.Minute(), ts.Second())
 }
+
+func TimeToLocalString(timestamp int64) string {
+	return time.Unix(timestamp, 0).In(localLoc).Format("2006-01-02 15:04:05 MST")
+}
+
+const (
+	TIME_UNIT_DAY   = "day"
+	TIME_UNIT_WEEK  = "week"
+	TIME_UNIT_MONTH = "month"
+)
+
+func IsValidTimeUnit(unit string) bool {
+	return unit == TIME_UNIT_DAY || unit == TIME_UNIT_WEEK || unit == TIME_UNIT_MONTH
+}
+
+// TimeBuckets splits [begin, end) into consecutive days, weeks (starting on monday) or months
+// of the configured timezone. The first bucket starts at the beginning of the unit containing begin.
+func TimeBuckets(begin, end int64, unit string) [][2]int64 {
+	ret := [][2]int64{}
+	if begin >= end {
+		return ret
+	}
+
+	t := time.Unix(begin, 0).In(localLoc)
+	t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, localLoc)
+	switch unit {
+	case TIME_UNIT_WEEK:
+		t = t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+	case TIME_UNIT_MONTH:
+		t = t.AddDate(0, 0, 1-t.Day())
+	}
+
+	for t.Unix() < end {
+		var next time.Time
+		switch unit {
+		case TIME_UNIT_WEEK:
+			next = t.AddDate(0, 0, 7)
+		case TIME_UNIT_MONTH:
+			next = t.AddDate(0, 1, 0)
+		default:
+			next = t.AddDate(0, 0, 1)
+		}
+		ret = append(ret, [2]int64{t.Unix(), next.Unix()})
+		t = next
+	}
+	return ret
+}
+
+// HourStart returns the beginning of the hour containing ts in the configured timezone
+func HourStart(ts int64) int64 {
+	shift := HourShift()
+	return ts - (ts+shift)%3600
+}
+
+// DayStart returns the midnight of the day containing ts in the configured timezone
+func DayStart(ts int64) int64 {
+	t := time.Unix(ts, 0).In(localLoc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, localLoc).Unix()
+}
+
+// HourShift returns the seconds to add to a timestamp so that whole hours in utc line up
+// with whole hours of the configured timezone, non-zero only for zones like +05:30
+func HourShift() int64 {
+	_, offset := time.Now().In(localLoc).Zone()
+	return int64(((offset % 3600) + 3600) % 3600)
+}
+
+// DayWindow is the minutes [Start, End) of every day in the configured timezone, it wraps
+// past midnight if Start > End and covers the whole day if Start == End
+type DayWindow struct {
+	Start int
+	End   int
+}
+
+// ParseDayMinute reads "HHMM" as minutes after midnight
+func ParseDayMinute(value string) (int, error) {
+	if len(value) != 4 || !timeReqChecker.MatchString(value) {
+		return 0, errInvalidTimeString
+	}
+	hour, minute := strToInt(value, 0, 2), strToInt(value, 2, 4)
+	if hour > 23 || minute > 59 {
+		return 0, errInvalidTimeString
+	}
+	return hour*60 + minute, nil
+}
+
+func (w DayWindow) Contains(ts int64) bool {
+	if w.Start == w.End {
+		return true
+	}
+	t := time.Unix(ts, 0).In(localLoc)
+	minute := t.Hour()*60 + t.Minute()
+	if w.Start < w.End {
+		return minute >= w.Start && minute < w.End
+	}
+	return minute >= w.Start || minute < w.End
+}
+
+// NextOpen returns ts if it is in the window, or the time the window opens next after ts
+func (w DayWindow) NextOpen(ts int64) int64 {
+	if w.Contains(ts) {
+		return ts
+	}
+	t := time.Unix(ts, 0).In(localLoc)
+	open := time.Date(t.Year(), t.Month(), t.Day(), w.Start/60, w.Start%60, 0, 0, localLoc)
+	if open.Unix() <= ts {
+		open = time.Date(t.Year(), t.Month(), t.Day()+1, w.Start/60, w.Start%60, 0, 0, localLoc)
+	}
+	return open.Unix()
+}
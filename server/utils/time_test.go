@@ -0,0 +1,52 @@
+package utils
+
+import "testing"
+
+func TestDayWindow(t *testing.T) {
+	defer SetLocalLocation(LocalLocation())
+	SetLocalLocation(cstLoc)
+
+	at := func(value string) int64 {
+		ret, err := TimeStringToUTC(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ret
+	}
+
+	cases := []struct {
+		start, end string
+		from       string
+		ans        string
+	}{
+		{"1000", "2300", "20230801120000", "20230801120000"},
+		{"1000", "2300", "20230801083000", "20230801100000"},
+		{"1000", "2300", "20230801230000", "20230802100000"},
+		{"2200", "0200", "20230801010000", "20230801010000"},
+		{"2200", "0200", "20230801230000", "20230801230000"},
+		{"2200", "0200", "20230801030000", "20230801220000"},
+		{"0000", "0000", "20230801030000", "20230801030000"},
+	}
+
+	for _, item := range cases {
+		start, err := ParseDayMinute(item.start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		end, err := ParseDayMinute(item.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		window := DayWindow{Start: start, End: end}
+		if ret := window.NextOpen(at(item.from)); ret != at(item.ans) {
+			t.Errorf("window %s-%s from %s got %s ans %s", item.start, item.end, item.from,
+				TimeToCSTString(ret), item.ans)
+		}
+	}
+
+	for _, value := range []string{"2400", "1060", "930", "ab00"} {
+		if _, err := ParseDayMinute(value); err == nil {
+			t.Errorf("%s should be invalid", value)
+		}
+	}
+}
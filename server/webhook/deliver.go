@@ -0,0 +1,150 @@
+package webhook
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zerozwt/octant/server/async_task"
+	"github.com/zerozwt/octant/server/db"
+	"github.com/zerozwt/octant/server/utils"
+	"github.com/zerozwt/swe"
+)
+
+const (
+	asyncTaskDeliverWebhook = "deliver_webhook"
+	asyncTaskPruneWebhook   = "prune_webhook_deliveries"
+)
+
+const (
+	// attempts of a delivery before it is given up, retried with backoff of the async task system
+	WEBHOOK_MAX_ATTEMPTS = 6
+	WEBHOOK_BACKOFF      = 30
+	WEBHOOK_MAX_BACKOFF  = 3600
+
+	WEBHOOK_TIMEOUT = 10 * time.Second
+	// finished deliveries are kept this long in the log
+	WEBHOOK_KEEP_DAYS = 30
+)
+
+// destinations are checked after resolving, so that names resolved to private addresses are refused too
+var client *http.Client = &http.Client{
+	Timeout: WEBHOOK_TIMEOUT,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: WEBHOOK_TIMEOUT, Control: utils.PublicDialControl}).DialContext,
+		TLSHandshakeTimeout: WEBHOOK_TIMEOUT,
+		MaxIdleConnsPerHost: 2,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+func init() {
+	async_task.RegisterHandler(asyncTaskDeliverWebhook, deliverTask)
+	async_task.SetRetryPolicy(asyncTaskDeliverWebhook, async_task.RetryPolicy{
+		MaxAttempts: WEBHOOK_MAX_ATTEMPTS,
+		Backoff:     WEBHOOK_BACKOFF,
+		MaxBackoff:  WEBHOOK_MAX_BACKOFF,
+	})
+	// slow endpoints should not take all workers from other tasks
+	async_task.SetConcurrency(asyncTaskDeliverWebhook, 2)
+
+	async_task.RegisterHandler(asyncTaskPruneWebhook, pruneTask)
+	async_task.RegisterCronJob(async_task.CronJob{
+		Name:    asyncTaskPruneWebhook,
+		Spec:    "30 4 * * *",
+		Handler: asyncTaskPruneWebhook,
+	})
+}
+
+// Post sends payload to hook, returns the http status of the answer. With a secret, requests carry
+// X-Octant-Timestamp and X-Octant-Signature, see utils.SignPayload.
+func Post(hook *db.Webhook, event string, deliveryID int64, payload []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "octant-webhook")
+	req.Header.Set("X-Octant-Event", event)
+	req.Header.Set("X-Octant-Delivery", fmt.Sprint(deliveryID))
+	if len(hook.Secret) > 0 {
+		ts := time.Now().Unix()
+		req.Header.Set("X-Octant-Timestamp", fmt.Sprint(ts))
+		req.Header.Set("X-Octant-Signature", utils.SignPayload(hook.Secret, ts, payload))
+	}
+
+	rsp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer rsp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(rsp.Body, 64*1024))
+
+	if rsp.StatusCode/100 != 2 {
+		return rsp.StatusCode, fmt.Errorf("webhook answered http status %d", rsp.StatusCode)
+	}
+	return rsp.StatusCode, nil
+}
+
+// a request the endpoint refuses would be refused again
+func permanentStatus(code int) bool {
+	return code/100 == 4 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+}
+
+func deliverTask(ctx *swe.Context, taskCtx async_task.TaskContext) error {
+	logger := swe.CtxLogger(ctx)
+	dal := db.GetWebhookDAL()
+
+	id, err := strconv.ParseInt(taskCtx.Param(), 10, 64)
+	if err != nil {
+		return async_task.Permanent(err)
+	}
+
+	delivery, err := dal.GetDelivery(ctx, id)
+	if err != nil {
+		return err
+	}
+	// deleted with its hook, or finished
+	if delivery == nil || delivery.Status != db.WEBHOOK_DELIVERY_PENDING {
+		return nil
+	}
+
+	hook, err := dal.Get(ctx, delivery.HookID)
+	if err != nil {
+		return err
+	}
+	if hook == nil || hook.Enabled == 0 {
+		logger.Warn("webhook %d of delivery %d deleted or disabled", delivery.HookID, id)
+		return dal.DeliveryAttempt(ctx, id, db.WEBHOOK_DELIVERY_FAILED, 0, "webhook deleted or disabled", time.Now().Unix())
+	}
+
+	code, err := Post(hook, delivery.Event, delivery.ID, []byte(delivery.Payload))
+	status, errMsg := db.WEBHOOK_DELIVERY_DONE, ""
+	if err != nil {
+		status, errMsg = db.WEBHOOK_DELIVERY_PENDING, err.Error()
+		if permanentStatus(code) || errors.Is(err, utils.ErrNotPublic) || delivery.Attempts+1 >= WEBHOOK_MAX_ATTEMPTS {
+			status, err = db.WEBHOOK_DELIVERY_FAILED, async_task.Permanent(err)
+		}
+		logger.Error("post delivery %d to webhook %d failed: %v", id, hook.ID, errMsg)
+	}
+
+	// a delivery posted is not posted again even if its result is not saved
+	if dbErr := dal.DeliveryAttempt(ctx, id, status, code, errMsg, time.Now().Unix()); dbErr != nil {
+		logger.Error("save result of delivery %d failed: %v", id, dbErr)
+	}
+	return err
+}
+
+func pruneTask(ctx *swe.Context, taskCtx async_task.TaskContext) error {
+	count, err := db.GetWebhookDAL().PruneDeliveries(ctx, time.Now().Unix()-WEBHOOK_KEEP_DAYS*86400)
+	if err != nil {
+		return err
+	}
+	swe.CtxLogger(ctx).Info("%d webhook deliveries pruned", count)
+	return nil
+}
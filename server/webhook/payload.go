@@ -0,0 +1,101 @@
+package webhook
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/zerozwt/octant/server/db"
+	"github.com/zerozwt/octant/server/live"
+	"github.com/zerozwt/octant/server/utils"
+)
+
+// payload templates are json with {var} replaced by values escaped for json strings, so that
+// a var can be put in a string like "{name}: {message}" or as a number like {value}. Braces not
+// around a known var are kept as they are. An empty template posts the event as json.
+var payloadVars map[string]func(ev *live.Event) string = map[string]func(ev *live.Event) string{
+	"type":        func(ev *live.Event) string { return ev.Type },
+	"room":        func(ev *live.Event) string { return strconv.FormatInt(ev.RoomID, 10) },
+	"uid":         func(ev *live.Event) string { return strconv.FormatInt(ev.UID, 10) },
+	"name":        func(ev *live.Event) string { return ev.Name },
+	"time":        func(ev *live.Event) string { return utils.TimeToLocalString(ev.Time) },
+	"timestamp":   func(ev *live.Event) string { return strconv.FormatInt(ev.Time, 10) },
+	"message":     func(ev *live.Event) string { return ev.Message },
+	"guard_level": func(ev *live.Event) string { return strconv.Itoa(ev.Level) },
+	"gift_name":   func(ev *live.Event) string { return ev.GiftName },
+	"count":       func(ev *live.Event) string { return strconv.FormatInt(ev.Count, 10) },
+	"price":       func(ev *live.Event) string { return strconv.FormatInt(ev.Price, 10) },
+	"value":       func(ev *live.Event) string { return strconv.FormatInt(ev.Value, 10) },
+	"yuan":        func(ev *live.Event) string { return ev.Yuan() },
+}
+
+var varOrder []string = []string{"type", "room", "uid", "name", "time", "timestamp", "message", "guard_level",
+	"gift_name", "count", "price", "value", "yuan"}
+
+func TemplateVars() []string {
+	return append([]string{}, varOrder...)
+}
+
+// Render builds the payload of ev posted to a hook with template
+func Render(template string, ev *live.Event) ([]byte, error) {
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	if len(template) == 0 {
+		return json.Marshal(ev)
+	}
+
+	ret := strings.Builder{}
+	for len(template) > 0 {
+		begin := strings.IndexByte(template, '{')
+		if begin < 0 {
+			break
+		}
+		end := strings.IndexByte(template[begin+1:], '}')
+		if end < 0 {
+			break
+		}
+		end += begin + 1
+
+		fn, ok := payloadVars[template[begin+1:end]]
+		if !ok {
+			// not a var, the brace may start a json object with a var inside
+			ret.WriteString(template[:begin+1])
+			template = template[begin+1:]
+			continue
+		}
+		ret.WriteString(template[:begin])
+		value, _ := json.Marshal(fn(ev))
+		ret.Write(value[1 : len(value)-1])
+		template = template[end+1:]
+	}
+	ret.WriteString(template)
+
+	data := []byte(ret.String())
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("payload is not valid json")
+	}
+	return data, nil
+}
+
+// SampleEvent is an event of room for tests of hooks and templates
+func SampleEvent(roomID int64, ts int64) *live.Event {
+	return &live.Event{
+		Type:     db.WEBHOOK_EVENT_TEST,
+		RoomID:   roomID,
+		UID:      1,
+		Name:     "测试用户",
+		Time:     ts,
+		Message:  `这是一条 "测试" 消息`,
+		Level:    3,
+		GiftName: "舰长",
+		Count:    1,
+		Price:    30,
+		Value:    30,
+	}
+}
+
+// CheckTemplate tells if template renders valid json
+func CheckTemplate(template string) error {
+	_, err := Render(template, SampleEvent(0, 0))
+	return err
+}
@@ -0,0 +1,137 @@
+package webhook
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zerozwt/octant/server/async_task"
+	"github.com/zerozwt/octant/server/db"
+	"github.com/zerozwt/octant/server/live"
+	"github.com/zerozwt/octant/server/utils"
+	"github.com/zerozwt/swe"
+)
+
+// Match tells if hook wants ev by its event types and thresholds
+func Match(hook *db.Webhook, ev *live.Event) bool {
+	if !hook.HasEvent(ev.Type) {
+		return false
+	}
+	switch ev.Type {
+	case db.WEBHOOK_EVENT_SC:
+		return ev.Value >= hook.MinSC
+	case db.WEBHOOK_EVENT_GIFT:
+		return ev.Value >= hook.MinGift
+	case db.WEBHOOK_EVENT_GUARD:
+		return hook.GuardLevel == 0 || ev.Level <= hook.GuardLevel
+	}
+	return true
+}
+
+// hooks are cached for a while, so that collectors do not query db for every gift. Collectors
+// may run without core, changes of hooks reach them when cache expires.
+const WEBHOOK_CACHE_SECONDS = 30
+
+type cachedHooks struct {
+	hooks  []db.Webhook
+	expire int64
+}
+
+var cacheLock sync.Mutex
+var hookCache map[int64]*cachedHooks = map[int64]*cachedHooks{}
+
+func enabledHooks(ctx *swe.Context, roomID int64) ([]db.Webhook, error) {
+	now := time.Now().Unix()
+	cacheLock.Lock()
+	item, ok := hookCache[roomID]
+	cacheLock.Unlock()
+	if ok && item.expire > now {
+		return item.hooks, nil
+	}
+
+	hooks, err := db.GetWebhookDAL().Enabled(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheLock.Lock()
+	hookCache[roomID] = &cachedHooks{hooks: hooks, expire: now + WEBHOOK_CACHE_SECONDS}
+	cacheLock.Unlock()
+	return hooks, nil
+}
+
+// Invalidate drops cached hooks of room after they are changed
+func Invalidate(roomID int64) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	delete(hookCache, roomID)
+}
+
+// Dispatch queues ev for enabled hooks of its room that want it
+func Dispatch(ctx *swe.Context, ev *live.Event) {
+	logger := swe.CtxLogger(ctx)
+
+	hooks, err := enabledHooks(ctx, ev.RoomID)
+	if err != nil {
+		logger.Error("load webhooks of room %d failed: %v", ev.RoomID, err)
+		return
+	}
+
+	for idx := range hooks {
+		hook := &hooks[idx]
+		if !Match(hook, ev) {
+			continue
+		}
+		if _, err := Enqueue(ctx, hook, ev); err != nil {
+			logger.Error("queue %s event of room %d for webhook %d failed: %v", ev.Type, ev.RoomID, hook.ID, err)
+		}
+	}
+}
+
+// Enqueue records a delivery of ev to hook, and creates the async task posting it
+func Enqueue(ctx *swe.Context, hook *db.Webhook, ev *live.Event) (*db.WebhookDelivery, error) {
+	payload, err := Render(hook.Template, ev)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	delivery := &db.WebhookDelivery{
+		ID:         utils.GenerateID(),
+		HookID:     hook.ID,
+		RoomID:     hook.RoomID,
+		Event:      ev.Type,
+		Payload:    string(payload),
+		Status:     db.WEBHOOK_DELIVERY_PENDING,
+		CreateTime: now,
+		UpdateTime: now,
+	}
+	if err = db.GetWebhookDAL().PutDelivery(ctx, delivery); err != nil {
+		return nil, err
+	}
+
+	return delivery, schedule(ctx, delivery)
+}
+
+// Redeliver posts a finished delivery again from its first attempt
+func Redeliver(ctx *swe.Context, delivery *db.WebhookDelivery) error {
+	ok, err := db.GetWebhookDAL().ResetDelivery(ctx, delivery.ID, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("delivery %d is pending already", delivery.ID)
+	}
+	return schedule(ctx, delivery)
+}
+
+func schedule(ctx *swe.Context, delivery *db.WebhookDelivery) error {
+	var taskID int64
+	err := async_task.GetScheduler().AddTask(ctx, asyncTaskDeliverWebhook, fmt.Sprint(delivery.ID),
+		time.Now().Unix(), func(id int64) { taskID = id })
+	if err != nil {
+		return err
+	}
+	delivery.TaskID = taskID
+	return db.GetWebhookDAL().SetDeliveryTask(ctx, delivery.ID, taskID)
+}
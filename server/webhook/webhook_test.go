@@ -0,0 +1,103 @@
+package webhook
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/zerozwt/octant/server/db"
+	"github.com/zerozwt/octant/server/live"
+	"github.com/zerozwt/octant/server/utils"
+)
+
+func TestRender(t *testing.T) {
+	ev := &live.Event{Type: db.WEBHOOK_EVENT_GIFT, RoomID: 10, UID: 1, Name: `a"b`, GiftName: "小花花", Count: 3, Price: 100, Value: 300}
+
+	cases := []struct {
+		template string
+		ans      string
+	}{
+		{`{"text": "{name} 送出 {gift_name} x{count}", "value": {value}}`, `{"text": "a\"b 送出 小花花 x3", "value": 300}`},
+		{`{"yuan": "{yuan}", "raw": "{unknown}"}`, `{"yuan": "0.3", "raw": "{unknown}"}`},
+		{`{"embeds": [{"title": "{type}"}]}`, `{"embeds": [{"title": "gift"}]}`},
+	}
+
+	for idx, item := range cases {
+		ret, err := Render(item.template, ev)
+		if err != nil {
+			t.Errorf("case %d render failed: %v", idx, err)
+			continue
+		}
+		if string(ret) != item.ans {
+			t.Errorf("case %d got %s ans %s", idx, ret, item.ans)
+		}
+	}
+
+	if _, err := Render(`{"text": {name}}`, ev); err == nil {
+		t.Error("payload not in json should fail")
+	}
+	if ret, err := Render("", ev); err != nil || len(ret) == 0 {
+		t.Errorf("default payload failed: %v", err)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	hook := &db.Webhook{Events: "sc,guard", MinSC: 100, GuardLevel: 2}
+
+	cases := []struct {
+		ev  live.Event
+		ans bool
+	}{
+		{live.Event{Type: db.WEBHOOK_EVENT_SC, Value: 100}, true},
+		{live.Event{Type: db.WEBHOOK_EVENT_SC, Value: 50}, false},
+		{live.Event{Type: db.WEBHOOK_EVENT_GUARD, Level: 1}, true},
+		{live.Event{Type: db.WEBHOOK_EVENT_GUARD, Level: 3}, false},
+		{live.Event{Type: db.WEBHOOK_EVENT_GIFT, Value: 1000000}, false},
+	}
+
+	for idx, item := range cases {
+		if Match(hook, &item.ev) != item.ans {
+			t.Errorf("case %d should be %v", idx, item.ans)
+		}
+	}
+}
+
+func TestPost(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		ts, _ := strconv.ParseInt(r.Header.Get("X-Octant-Timestamp"), 10, 64)
+		if utils.SignPayload("secret", ts, body) != r.Header.Get("X-Octant-Signature") ||
+			r.Header.Get("X-Octant-Event") != db.WEBHOOK_EVENT_SC || r.Header.Get("X-Octant-Delivery") != "7" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	hook := &db.Webhook{URL: server.URL, Secret: "secret"}
+	if _, err := Post(hook, db.WEBHOOK_EVENT_SC, 7, []byte(`{}`)); !errors.Is(err, utils.ErrNotPublic) {
+		t.Fatalf("post to loopback should be refused: %v", err)
+	}
+
+	utils.SetAllowedNetworks([]*net.IPNet{{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}})
+	defer utils.SetAllowedNetworks(nil)
+	if code, err := Post(hook, db.WEBHOOK_EVENT_SC, 7, []byte(`{}`)); err != nil || code != http.StatusOK {
+		t.Fatalf("post failed: %d %v", code, err)
+	}
+
+	status = http.StatusServiceUnavailable
+	if code, err := Post(hook, db.WEBHOOK_EVENT_SC, 7, []byte(`{}`)); err == nil || permanentStatus(code) {
+		t.Errorf("status %d should be retried", code)
+	}
+
+	hook.Secret = "wrong"
+	if code, err := Post(hook, db.WEBHOOK_EVENT_SC, 7, []byte(`{}`)); err == nil || !permanentStatus(code) {
+		t.Errorf("status %d should not be retried", code)
+	}
+}
@@ -75,7 +75,7 @@ const zhCN = {
             ListCols: ["活动名称", "活动特典内容", "隐藏活动", "状态", "操作"],
             ListOps: ["观众名单", "编辑信息", "删除活动"],
             ListDelConfirm: "确定要删除这个活动？",
-            EvtStatus: ["数据收集中", "名单计算中", "名单计算错误", "名单计算完成"],
+            EvtStatus: ["数据收集中", "名单计算中", "名单计算错误", "名单计算完成", "名单计算失败，等待重试"],
             Add: {
                 Basic: "基本信息",
                 Cond: "参与条件",
@@ -189,7 +189,7 @@ const enUS = {
             ListCols: ["Activity name", "Special offer content", "Hidden activity", "Status", "Operation"],
             ListOps: ["Audience list", "Edit activity", "Delete"],
             ListDelConfirm: "Are you sure to delete this activity?",
-            EvtStatus: ["Gathering data", "Calculating list", "Calculation error", "Ready"],
+            EvtStatus: ["Gathering data", "Calculating list", "Calculation error", "Ready", "Calculation failed, retrying"],
             Add: {
                 Basic: "Basic information",
                 Cond: "Conditions",
@@ -302,7 +302,7 @@ const jaJP = {
             ListCols: ["イベント名", "特典内容", "隠しイベント", "状態", "操作"],
             ListOps: ["リスナー名簿", "情報変更", "デリート"],
             ListDelConfirm: "このイベントを消去しますか？",
-            EvtStatus: ["データ収集中", "リスナー名簿計算中", "計算エラー", "名簿計算完了"],
+            EvtStatus: ["データ収集中", "リスナー名簿計算中", "計算エラー", "名簿計算完了", "計算失敗、再試行待ち"],
             Add: {
                 Basic: "基本情報",
                 Cond: "参加条件",